@@ -0,0 +1,266 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gambl/models"
+)
+
+var (
+	ErrAINotConfigured = errors.New("the AI assistant is not configured")
+	ErrAINoEmbeddings  = errors.New("the AI provider cannot embed documents")
+)
+
+// AIProviderError is a completion the provider refused or failed
+type AIProviderError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *AIProviderError) Error() string {
+	return fmt.Sprintf("AI provider responded %d: %s", e.StatusCode, e.Message)
+}
+
+// ChatRequest is a chat completion request, whatever the provider. With a JSONSchema the answer must be a JSON
+// document matching it.
+type ChatRequest struct {
+	Model       string
+	Messages    []models.AIMessage
+	MaxTokens   int
+	Temperature float64
+	JSONSchema  *JSONSchema
+}
+
+// JSONSchema names the schema a structured answer must match
+type JSONSchema struct {
+	Name   string
+	Schema map[string]interface{}
+}
+
+// ChatProvider answers chats with a language model. The provider is picked with AI_PROVIDER.
+type ChatProvider interface {
+	Chat(ctx context.Context, request ChatRequest) (models.AIChatResponse, error)
+	// StreamChat calls onDelta with each piece of the answer as it is generated and returns the whole answer.
+	// An error from onDelta stops the stream.
+	StreamChat(ctx context.Context, request ChatRequest, onDelta func(delta string) error) (models.AIChatResponse, error)
+}
+
+// EmbeddingProvider turns texts into vectors whose cosine similarity measures how close their meaning is.
+// Chat providers that can embed implement it too.
+type EmbeddingProvider interface {
+	Embed(ctx context.Context, request EmbeddingRequest) (EmbeddingResponse, error)
+}
+
+// EmbeddingRequest asks for one vector per input
+type EmbeddingRequest struct {
+	Model string
+	Input []string
+}
+
+// EmbeddingResponse holds the vectors in the order of the inputs
+type EmbeddingResponse struct {
+	Model   string
+	Vectors [][]float64
+	Usage   models.AIUsage
+}
+
+var chatProviderState struct {
+	sync.Mutex
+	provider ChatProvider
+}
+
+// GetChatProvider returns the configured provider, creating it from the environment on first use
+func GetChatProvider() ChatProvider {
+	chatProviderState.Lock()
+	defer chatProviderState.Unlock()
+
+	if chatProviderState.provider == nil {
+		provider, err := NewChatProvider(os.Getenv("AI_PROVIDER"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		chatProviderState.provider = provider
+	}
+	return chatProviderState.provider
+}
+
+// SetChatProvider replaces the provider, for tests and local tools
+func SetChatProvider(provider ChatProvider) {
+	chatProviderState.Lock()
+	defer chatProviderState.Unlock()
+	chatProviderState.provider = provider
+}
+
+// NewChatProvider creates the provider of a driver: openai (the default, any OpenAI compatible API) or fake
+func NewChatProvider(driver string) (ChatProvider, error) {
+	switch strings.ToLower(driver) {
+	case "", "openai":
+		return NewOpenAIProviderFromEnv(), nil
+	case "fake":
+		return &FakeChatProvider{}, nil
+	}
+	return nil, fmt.Errorf("unknown AI_PROVIDER %q", driver)
+}
+
+// AISettings are the completion defaults, from OPENAI_MODEL, OPENAI_MAX_TOKENS, OPENAI_TEMPERATURE,
+// OPENAI_TIMEOUT_SECONDS and OPENAI_STREAM_IDLE_SECONDS. Timeout bounds a whole answer, or the wait for the first
+// piece of a streamed one. StreamIdleTimeout bounds the wait between two pieces of a stream.
+type AISettings struct {
+	Model             string
+	MaxTokens         int
+	Temperature       float64
+	Timeout           time.Duration
+	StreamIdleTimeout time.Duration
+}
+
+func AIChatSettings() AISettings {
+	temperature, err := strconv.ParseFloat(os.Getenv("OPENAI_TEMPERATURE"), 64)
+	if err != nil || temperature < 0 || temperature > 2 {
+		temperature = 0.7
+	}
+
+	return AISettings{
+		Model:             envOrDefault("OPENAI_MODEL", "gpt-4o-mini"),
+		MaxTokens:         envIntOrDefault("OPENAI_MAX_TOKENS", 512),
+		Temperature:       temperature,
+		Timeout:           time.Duration(envIntOrDefault("OPENAI_TIMEOUT_SECONDS", 60)) * time.Second,
+		StreamIdleTimeout: time.Duration(envIntOrDefault("OPENAI_STREAM_IDLE_SECONDS", 20)) * time.Second,
+	}
+}
+
+// NewChatRequest builds a request with the configured settings. The prompt's max_tokens and temperature override
+// them, max_tokens up to the configured limit.
+func NewChatRequest(prompt models.AIModel, messages []models.AIMessage) ChatRequest {
+	settings := AIChatSettings()
+	request := ChatRequest{
+		Model:       settings.Model,
+		Messages:    messages,
+		MaxTokens:   settings.MaxTokens,
+		Temperature: settings.Temperature,
+	}
+	if prompt.Max_tokens != nil && *prompt.Max_tokens < settings.MaxTokens {
+		request.MaxTokens = *prompt.Max_tokens
+	}
+	if prompt.Temperature != nil {
+		request.Temperature = *prompt.Temperature
+	}
+	return request
+}
+
+// PromptMessages are the messages of a prompt sent on its own, as a single user message
+func PromptMessages(prompt models.AIModel) []models.AIMessage {
+	return []models.AIMessage{{Role: "user", Content: prompt.Prompt}}
+}
+
+// AskAI sends the prompt as a user message to the configured provider
+func AskAI(ctx context.Context, prompt models.AIModel) (models.AIChatResponse, error) {
+	return ChatAI(ctx, prompt, PromptMessages(prompt))
+}
+
+// StreamAI sends the prompt as a user message to the configured provider and streams the answer to onDelta
+func StreamAI(ctx context.Context, prompt models.AIModel, onDelta func(delta string) error) (models.AIChatResponse, error) {
+	return StreamChatAI(ctx, prompt, PromptMessages(prompt), onDelta)
+}
+
+// ChatAI sends the messages to the configured provider with the prompt's settings
+func ChatAI(ctx context.Context, prompt models.AIModel, messages []models.AIMessage) (models.AIChatResponse, error) {
+	return SendChatRequest(ctx, NewChatRequest(prompt, messages))
+}
+
+// NewJSONChatRequest builds a request whose answer must match the schema. Generated documents are longer than chat
+// answers, so they may use up to AI_GENERATOR_MAX_TOKENS.
+func NewJSONChatRequest(messages []models.AIMessage, schema JSONSchema) ChatRequest {
+	settings := AIChatSettings()
+	return ChatRequest{
+		Model:       settings.Model,
+		Messages:    messages,
+		MaxTokens:   envIntOrDefault("AI_GENERATOR_MAX_TOKENS", 4096),
+		Temperature: settings.Temperature,
+		JSONSchema:  &schema,
+	}
+}
+
+// SendChatRequest sends a request to the configured provider
+func SendChatRequest(ctx context.Context, request ChatRequest) (models.AIChatResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, AIChatSettings().Timeout)
+	defer cancel()
+
+	return GetChatProvider().Chat(ctx, request)
+}
+
+// StreamChatAI sends the messages to the configured provider with the prompt's settings and streams the answer to
+// onDelta. A stream has no overall deadline, as long answers take long: it fails with context.DeadlineExceeded when
+// the first piece takes longer than the timeout or the next one longer than the idle timeout.
+func StreamChatAI(ctx context.Context, prompt models.AIModel, messages []models.AIMessage, onDelta func(delta string) error) (models.AIChatResponse, error) {
+	settings := AIChatSettings()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var timedOut int32
+	timer := time.AfterFunc(settings.Timeout, func() {
+		atomic.StoreInt32(&timedOut, 1)
+		cancel()
+	})
+	defer timer.Stop()
+
+	response, err := GetChatProvider().StreamChat(ctx, NewChatRequest(prompt, messages), func(delta string) error {
+		if !timer.Stop() {
+			// the timer fired while this piece was on its way
+			return context.DeadlineExceeded
+		}
+		timer.Reset(settings.StreamIdleTimeout)
+		return onDelta(delta)
+	})
+	if err != nil && atomic.LoadInt32(&timedOut) == 1 {
+		err = context.DeadlineExceeded
+	}
+	return response, err
+}
+
+// EmbeddingModel is the model embedding documents and questions, from AI_EMBEDDING_MODEL
+func EmbeddingModel() string {
+	return envOrDefault("AI_EMBEDDING_MODEL", "text-embedding-3-small")
+}
+
+// EmbedTexts embeds the texts with the configured provider, in batches of AI_EMBEDDING_BATCH_SIZE inputs
+func EmbedTexts(ctx context.Context, texts []string) (EmbeddingResponse, error) {
+	embedder, ok := GetChatProvider().(EmbeddingProvider)
+	if !ok {
+		return EmbeddingResponse{}, ErrAINoEmbeddings
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, AIChatSettings().Timeout)
+	defer cancel()
+
+	batchSize := envIntOrDefault("AI_EMBEDDING_BATCH_SIZE", 64)
+	result := EmbeddingResponse{Model: EmbeddingModel(), Vectors: make([][]float64, 0, len(texts))}
+	for start := 0; start < len(texts); start += batchSize {
+		end := start + batchSize
+		if end > len(texts) {
+			end = len(texts)
+		}
+
+		batch, err := embedder.Embed(ctx, EmbeddingRequest{Model: result.Model, Input: texts[start:end]})
+		if err != nil {
+			return result, err
+		}
+		if len(batch.Vectors) != end-start {
+			return result, &AIProviderError{StatusCode: 200, Message: fmt.Sprintf("%d embeddings returned for %d inputs", len(batch.Vectors), end-start)}
+		}
+
+		result.Model = batch.Model
+		result.Vectors = append(result.Vectors, batch.Vectors...)
+		result.Usage.Prompt_tokens += batch.Usage.Prompt_tokens
+		result.Usage.Total_tokens += batch.Usage.Total_tokens
+	}
+	return result, nil
+}
@@ -0,0 +1,96 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"gambl/models"
+)
+
+// pacedProvider streams one piece after each delay
+type pacedProvider struct {
+	delays []time.Duration
+}
+
+func (p *pacedProvider) Chat(ctx context.Context, request ChatRequest) (models.AIChatResponse, error) {
+	return models.AIChatResponse{}, errors.New("not streamed")
+}
+
+func (p *pacedProvider) StreamChat(ctx context.Context, request ChatRequest, onDelta func(delta string) error) (models.AIChatResponse, error) {
+	var content strings.Builder
+	for i, delay := range p.delays {
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return models.AIChatResponse{Content: content.String()}, ctx.Err()
+		}
+		delta := fmt.Sprintf("%d ", i)
+		content.WriteString(delta)
+		if err := onDelta(delta); err != nil {
+			return models.AIChatResponse{Content: content.String()}, err
+		}
+	}
+	return models.AIChatResponse{Content: content.String()}, nil
+}
+
+func useChatProvider(t *testing.T, provider ChatProvider) {
+	chatProviderState.Lock()
+	previous := chatProviderState.provider
+	chatProviderState.Unlock()
+
+	SetChatProvider(provider)
+	t.Cleanup(func() { SetChatProvider(previous) })
+}
+
+func TestStreamChatAITimeouts(t *testing.T) {
+	t.Setenv("OPENAI_TIMEOUT_SECONDS", "2")
+	t.Setenv("OPENAI_STREAM_IDLE_SECONDS", "1")
+
+	ms := time.Millisecond
+	tests := []struct {
+		name        string
+		delays      []time.Duration
+		wantErr     error
+		wantContent string
+	}{
+		{name: "slow first piece within the timeout", delays: []time.Duration{1500 * ms, 100 * ms}, wantContent: "0 1 "},
+		{name: "answer longer than the timeout", delays: []time.Duration{100 * ms, 700 * ms, 700 * ms, 700 * ms}, wantContent: "0 1 2 3 "},
+		{name: "first piece too slow", delays: []time.Duration{3 * time.Second}, wantErr: context.DeadlineExceeded, wantContent: ""},
+		{name: "stream stalls", delays: []time.Duration{100 * ms, 1500 * ms}, wantErr: context.DeadlineExceeded, wantContent: "0 "},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useChatProvider(t, &pacedProvider{delays: tt.delays})
+
+			var deltas strings.Builder
+			response, err := StreamChatAI(context.Background(), models.AIModel{}, nil, func(delta string) error {
+				deltas.WriteString(delta)
+				return nil
+			})
+
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+				t.Errorf("StreamChatAI() error = %v, want %v", err, tt.wantErr)
+			}
+			if response.Content != tt.wantContent || deltas.String() != tt.wantContent {
+				t.Errorf("content = %q and deltas %q, want %q", response.Content, deltas.String(), tt.wantContent)
+			}
+		})
+	}
+}
+
+func TestStreamChatAIKeepsCancellation(t *testing.T) {
+	useChatProvider(t, &pacedProvider{delays: []time.Duration{time.Second}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	_, err := StreamChatAI(ctx, models.AIModel{}, nil, func(string) error { return nil })
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("StreamChatAI() error = %v, want context.Canceled when the client goes away", err)
+	}
+}
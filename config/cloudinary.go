@@ -3,6 +3,7 @@ package config
 import (
 	"log"
 	"os"
+	"sync"
 
 	"github.com/cloudinary/cloudinary-go/v2"
 	"github.com/joho/godotenv"
@@ -19,7 +20,6 @@ func CLoudinaryInstance() *cloudinary.Cloudinary {
 	CLD_SECRET := os.Getenv("CLD_SECRET")
 
 	if CLD_NAME == "" || CLD_KEY == "" || CLD_SECRET == "" {
-		// Use a default value or handle the case where MONGO_URL is not set
 		log.Fatal("Cloudinary environment variable is not set")
 	}
 
@@ -44,4 +44,16 @@ func CloudinaryFolder() string {
 	return CLD_FOLDER
 }
 
-var CloudinaryClient *cloudinary.Cloudinary = CLoudinaryInstance()
+var (
+	cloudinaryOnce   sync.Once
+	cloudinaryClient *cloudinary.Cloudinary
+)
+
+// CloudinaryClient returns the cloudinary client, created on first use so the settings are only required where
+// uploads are made
+func CloudinaryClient() *cloudinary.Cloudinary {
+	cloudinaryOnce.Do(func() {
+		cloudinaryClient = CLoudinaryInstance()
+	})
+	return cloudinaryClient
+}
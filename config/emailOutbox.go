@@ -0,0 +1,288 @@
+package config
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"gambl/database"
+	"gambl/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var emailOutboxCollection *mongo.Collection = database.OpenCollection(database.Client, "email_outbox")
+
+// Statuses of an outbox email. A DEAD email ran out of attempts and waits to be replayed.
+const (
+	EmailPending = "PENDING"
+	EmailSending = "SENDING"
+	EmailSent    = "SENT"
+	EmailDead    = "DEAD"
+)
+
+var ErrOutboxEmailNotFound = errors.New("email not found in the outbox")
+
+// outboxEmailRedacted leaves out the content of emails read for the admin endpoints, it may hold codes or links
+var outboxEmailRedacted = bson.M{"message.text": 0, "message.html": 0}
+
+// EmailOutboxSettings controls the outbox workers, from the EMAIL_OUTBOX_* variables
+type EmailOutboxSettings struct {
+	Workers      int
+	MaxAttempts  int
+	BackoffBase  time.Duration
+	BackoffMax   time.Duration
+	Lease        time.Duration
+	PollInterval time.Duration
+	Retention    time.Duration
+}
+
+// outboxWake lets a worker pick up a new email without waiting for the next poll
+var outboxWake = make(chan struct{}, 1)
+
+// enqueueEmail is how sendMail queues emails, tests replace it to deliver them through the mailer without mongo
+var enqueueEmail = EnqueueEmail
+
+func emailOutboxSettings() EmailOutboxSettings {
+	return EmailOutboxSettings{
+		Workers:      envIntOrDefault("EMAIL_OUTBOX_WORKERS", 2),
+		MaxAttempts:  envIntOrDefault("EMAIL_OUTBOX_MAX_ATTEMPTS", 8),
+		BackoffBase:  time.Duration(envIntOrDefault("EMAIL_OUTBOX_BACKOFF_SECONDS", 30)) * time.Second,
+		BackoffMax:   time.Duration(envIntOrDefault("EMAIL_OUTBOX_BACKOFF_MAX_MINUTES", 60)) * time.Minute,
+		Lease:        time.Duration(envIntOrDefault("EMAIL_OUTBOX_LEASE_SECONDS", 120)) * time.Second,
+		PollInterval: time.Duration(envIntOrDefault("EMAIL_OUTBOX_POLL_SECONDS", 5)) * time.Second,
+		Retention:    time.Duration(envIntOrDefault("EMAIL_OUTBOX_RETENTION_DAYS", 7)) * 24 * time.Hour,
+	}
+}
+
+func envIntOrDefault(name string, fallback int) int {
+	value, err := strconv.Atoi(envOrDefault(name, ""))
+	if err != nil || value < 1 {
+		return fallback
+	}
+	return value
+}
+
+// CreateEmailOutboxIndexes makes idempotency keys unique, speeds up claiming due emails and lets mongo drop old sent emails
+func CreateEmailOutboxIndexes() {
+	database.CreateIndexes(emailOutboxCollection,
+		mongo.IndexModel{Keys: bson.D{{Key: "idempotency_key", Value: 1}}, Options: options.Index().SetUnique(true)},
+		mongo.IndexModel{Keys: bson.D{{Key: "message_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		mongo.IndexModel{Keys: bson.D{{Key: "status", Value: 1}, {Key: "next_attempt_at", Value: 1}}},
+		mongo.IndexModel{Keys: bson.D{{Key: "purge_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	)
+}
+
+// IdempotencyKey hashes the parts identifying an email, so keys never hold codes or tokens in clear
+func IdempotencyKey(parts ...string) string {
+	hash := sha256.New()
+	for _, part := range parts {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// EnqueueEmail stores an email in the outbox for the workers to send. An email whose idempotency key is already
+// in the outbox is not queued again, and the existing one is returned with queued set to false.
+func EnqueueEmail(ctx context.Context, idempotencyKey string, message Message) (email models.OutboxEmail, queued bool, err error) {
+	now := time.Now().UTC()
+	email = models.OutboxEmail{
+		ID:              primitive.NewObjectID(),
+		Idempotency_key: idempotencyKey,
+		Message:         message,
+		Status:          EmailPending,
+		Max_attempts:    emailOutboxSettings().MaxAttempts,
+		Next_attempt_at: now,
+		Created_at:      now,
+		Updated_at:      now,
+	}
+	email.Message_id = email.ID.Hex()
+
+	if _, err = emailOutboxCollection.InsertOne(ctx, email); err != nil {
+		if !database.IsDuplicateKeyError(err) {
+			return email, false, err
+		}
+		var existing models.OutboxEmail
+		err = emailOutboxCollection.FindOne(ctx, bson.M{"idempotency_key": idempotencyKey}).Decode(&existing)
+		return existing, false, err
+	}
+
+	select {
+	case outboxWake <- struct{}{}:
+	default:
+	}
+	return email, true, nil
+}
+
+// StartEmailWorkers starts the pool of workers sending the outbox
+func StartEmailWorkers() {
+	settings := emailOutboxSettings()
+	for i := 0; i < settings.Workers; i++ {
+		go emailWorker(settings)
+	}
+}
+
+func emailWorker(settings EmailOutboxSettings) {
+	for {
+		processed, err := processNextEmail(settings)
+		if err != nil {
+			log.Printf("Error processing the email outbox: %v", err)
+		}
+		if processed && err == nil {
+			continue
+		}
+
+		select {
+		case <-outboxWake:
+		case <-time.After(settings.PollInterval):
+		}
+	}
+}
+
+// processNextEmail claims one due email and tries to send it. Emails claimed by a worker that died are picked up
+// again once their lease runs out.
+func processNextEmail(settings EmailOutboxSettings) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), settings.Lease)
+	defer cancel()
+
+	now := time.Now().UTC()
+	filter := bson.M{"$or": []bson.M{
+		{"status": EmailPending, "next_attempt_at": bson.M{"$lte": now}},
+		{"status": EmailSending, "locked_until": bson.M{"$lte": now}},
+	}}
+	update := bson.M{
+		"$set": bson.M{"status": EmailSending, "locked_until": now.Add(settings.Lease), "updated_at": now},
+		"$inc": bson.M{"attempts": 1},
+	}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "next_attempt_at", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var email models.OutboxEmail
+	if err := emailOutboxCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&email); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return false, nil
+		}
+		return false, err
+	}
+
+	sendErr := GetMailer().Send(ctx, email.Message)
+
+	// only the worker holding the claim records the outcome
+	claim := bson.M{"_id": email.ID, "status": EmailSending, "attempts": email.Attempts}
+	now = time.Now().UTC()
+
+	if sendErr == nil {
+		purgeAt := now.Add(settings.Retention)
+		_, err := emailOutboxCollection.UpdateOne(ctx, claim, bson.M{
+			"$set": bson.M{"status": EmailSent, "sent_at": now, "purge_at": purgeAt, "last_error": "", "updated_at": now},
+			// the content is not needed once delivered and may hold codes or links
+			"$unset": bson.M{"message.text": "", "message.html": ""},
+		})
+		return true, err
+	}
+
+	log.Printf("Error sending email %s %q (attempt %d of %d): %v", email.Message_id, email.Message.Subject, email.Attempts, email.Max_attempts, sendErr)
+
+	set := bson.M{"last_error": sendErr.Error(), "updated_at": now}
+	if email.Attempts >= email.Max_attempts {
+		// a dead email is dropped with its content after the retention period unless it is replayed
+		set["status"] = EmailDead
+		set["purge_at"] = now.Add(settings.Retention)
+	} else {
+		set["status"] = EmailPending
+		set["next_attempt_at"] = now.Add(emailBackoff(settings, email.Attempts))
+	}
+	_, err := emailOutboxCollection.UpdateOne(ctx, claim, bson.M{"$set": set})
+	return true, err
+}
+
+// emailBackoff doubles the wait after each failed attempt up to BackoffMax, with some jitter so retries spread out
+func emailBackoff(settings EmailOutboxSettings, attempts int) time.Duration {
+	wait := settings.BackoffBase
+	for i := 1; i < attempts && wait < settings.BackoffMax; i++ {
+		wait *= 2
+	}
+	if wait > settings.BackoffMax {
+		wait = settings.BackoffMax
+	}
+	return wait + time.Duration(rand.Int63n(int64(wait)/5+1))
+}
+
+// ListOutboxEmails returns a page of outbox emails with the status, newest first, without their content
+func ListOutboxEmails(ctx context.Context, status string, skip int, limit int) ([]models.OutboxEmail, int64, error) {
+	filter := bson.M{"status": status}
+
+	total, err := emailOutboxCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "updated_at", Value: -1}}).
+		SetSkip(int64(skip)).
+		SetLimit(int64(limit)).
+		SetProjection(outboxEmailRedacted)
+	cursor, err := emailOutboxCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	emails := []models.OutboxEmail{}
+	if err = cursor.All(ctx, &emails); err != nil {
+		return nil, 0, err
+	}
+	return emails, total, nil
+}
+
+// GetOutboxEmail returns an outbox email by its message id, without its content
+func GetOutboxEmail(ctx context.Context, messageId string) (models.OutboxEmail, error) {
+	var email models.OutboxEmail
+	opts := options.FindOne().SetProjection(outboxEmailRedacted)
+	err := emailOutboxCollection.FindOne(ctx, bson.M{"message_id": messageId}, opts).Decode(&email)
+	if err == mongo.ErrNoDocuments {
+		return email, ErrOutboxEmailNotFound
+	}
+	return email, err
+}
+
+// ReplayOutboxEmails queues dead emails again with a fresh set of attempts, and keeps them until they are sent. An empty
+// message id replays every dead email.
+func ReplayOutboxEmails(ctx context.Context, messageId string) (int64, error) {
+	filter := bson.M{"status": EmailDead}
+	if messageId != "" {
+		filter["message_id"] = messageId
+	}
+
+	now := time.Now().UTC()
+	result, err := emailOutboxCollection.UpdateMany(ctx, filter, bson.M{
+		"$set": bson.M{
+			"status":          EmailPending,
+			"attempts":        0,
+			"max_attempts":    emailOutboxSettings().MaxAttempts,
+			"next_attempt_at": now,
+			"updated_at":      now,
+		},
+		"$unset": bson.M{"purge_at": ""},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if result.ModifiedCount > 0 {
+		select {
+		case outboxWake <- struct{}{}:
+		default:
+		}
+	}
+	return result.ModifiedCount, nil
+}
@@ -0,0 +1,182 @@
+package config
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	htmltemplate "html/template"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strings"
+	texttemplate "text/template"
+)
+
+// Email templates live in templates/<locale>/<name>.txt and .html. The text file defines the "subject" and "content"
+// blocks, the html file only "content", and both are wrapped in the shared layout with the locale's "footer".
+//
+//go:embed templates
+var emailTemplateFS embed.FS
+
+// DefaultEmailLocale is used when the recipient's locale has no template
+const DefaultEmailLocale = "en"
+
+var ErrUnknownEmailTemplate = errors.New("unknown email template")
+
+// RenderedEmail is an email template rendered for one recipient
+type RenderedEmail struct {
+	Locale  string `json:"locale"`
+	Subject string `json:"subject"`
+	Text    string `json:"text"`
+	HTML    string `json:"html"`
+}
+
+type emailTemplate struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+// emailTemplates holds the parsed templates by locale and name
+var emailTemplates = loadEmailTemplates()
+
+func loadEmailTemplates() map[string]map[string]emailTemplate {
+	locales, err := fs.ReadDir(emailTemplateFS, "templates")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	templates := map[string]map[string]emailTemplate{}
+	for _, locale := range locales {
+		if !locale.IsDir() {
+			continue
+		}
+		dir := path.Join("templates", locale.Name())
+
+		files, err := fs.ReadDir(emailTemplateFS, dir)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		templates[locale.Name()] = map[string]emailTemplate{}
+		for _, file := range files {
+			name := strings.TrimSuffix(file.Name(), ".txt")
+			if name == file.Name() || name == "footer" {
+				continue
+			}
+
+			var tmpl emailTemplate
+			tmpl.text, err = texttemplate.New(name).Option("missingkey=error").
+				ParseFS(emailTemplateFS, "templates/layout.txt", path.Join(dir, "footer.txt"), path.Join(dir, name+".txt"))
+			if err != nil {
+				log.Fatalf("Error parsing email template %s/%s: %v", locale.Name(), name, err)
+			}
+
+			if _, err := fs.Stat(emailTemplateFS, path.Join(dir, name+".html")); err == nil {
+				tmpl.html, err = htmltemplate.New(name).Option("missingkey=error").
+					ParseFS(emailTemplateFS, "templates/layout.html", path.Join(dir, "footer.html"), path.Join(dir, name+".html"))
+				if err != nil {
+					log.Fatalf("Error parsing email template %s/%s: %v", locale.Name(), name, err)
+				}
+			}
+			templates[locale.Name()][name] = tmpl
+		}
+	}
+	return templates
+}
+
+// EmailLocale picks the supported locale of a locale or Accept-Language value, such as fr-CA or "fr-FR,fr;q=0.9,en;q=0.8"
+func EmailLocale(value string) string {
+	for _, tag := range strings.Split(value, ",") {
+		tag = strings.ToLower(strings.TrimSpace(strings.SplitN(tag, ";", 2)[0]))
+		if i := strings.IndexAny(tag, "-_"); i >= 0 {
+			tag = tag[:i]
+		}
+		if _, ok := emailTemplates[tag]; ok {
+			return tag
+		}
+	}
+	return DefaultEmailLocale
+}
+
+// EmailLocales lists the locales that have templates
+func EmailLocales() []string {
+	locales := make([]string, 0, len(emailTemplates))
+	for locale := range emailTemplates {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+// EmailTemplateNames lists the templates of the default locale
+func EmailTemplateNames() []string {
+	names := make([]string, 0, len(emailTemplates[DefaultEmailLocale]))
+	for name := range emailTemplates[DefaultEmailLocale] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RenderEmail renders a template in the recipient's locale, falling back to the default locale when it has no variant.
+// AppName and Locale are added to the data.
+func RenderEmail(name string, locale string, data map[string]interface{}) (RenderedEmail, error) {
+	locale = EmailLocale(locale)
+	tmpl, ok := emailTemplates[locale][name]
+	if !ok {
+		locale = DefaultEmailLocale
+		if tmpl, ok = emailTemplates[locale][name]; !ok {
+			return RenderedEmail{}, ErrUnknownEmailTemplate
+		}
+	}
+
+	values := map[string]interface{}{}
+	for key, value := range data {
+		values[key] = value
+	}
+	values["AppName"] = MailSender().Name
+	values["Locale"] = locale
+
+	rendered := RenderedEmail{Locale: locale}
+	var buf bytes.Buffer
+
+	if err := tmpl.text.ExecuteTemplate(&buf, "subject", values); err != nil {
+		return rendered, err
+	}
+	rendered.Subject = strings.TrimSpace(buf.String())
+	values["Subject"] = rendered.Subject
+
+	buf.Reset()
+	if err := tmpl.text.ExecuteTemplate(&buf, "layout", values); err != nil {
+		return rendered, err
+	}
+	rendered.Text = buf.String()
+
+	if tmpl.html != nil {
+		buf.Reset()
+		if err := tmpl.html.ExecuteTemplate(&buf, "layout", values); err != nil {
+			return rendered, err
+		}
+		rendered.HTML = buf.String()
+	}
+	return rendered, nil
+}
+
+// EmailPreviewData is sample data to preview a template with
+func EmailPreviewData(name string) map[string]interface{} {
+	link := FrontendURL() + "/preview"
+	switch name {
+	case "otp":
+		return map[string]interface{}{"Code": "123456"}
+	case "verify":
+		return map[string]interface{}{"Link": link}
+	case "password_reset":
+		return map[string]interface{}{"Code": "123456", "Link": link}
+	case "account_locked":
+		return map[string]interface{}{"LockedUntil": "01 Jan 2030 12:00 UTC", "Link": link}
+	case "new_user", "user_details":
+		return map[string]interface{}{"Name": "Ada Lovelace", "Email": "ada@example.com", "UserType": "TEACHER", "Phone": "+2348000000000"}
+	}
+	return map[string]interface{}{}
+}
@@ -0,0 +1,183 @@
+package config
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash/fnv"
+	"math"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"gambl/models"
+)
+
+// FakeChatProvider answers without calling a model, for tests and offline development. It replies with Replies
+// in order, or once they run out echoes the last user message, or for structured requests answers a sample document
+// built from the schema. The same chat always gets the same answer.
+// Tokens are counted as words.
+type FakeChatProvider struct {
+	mu       sync.Mutex
+	Replies  []string
+	requests []ChatRequest
+}
+
+func (f *FakeChatProvider) Chat(ctx context.Context, request ChatRequest) (models.AIChatResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return models.AIChatResponse{}, err
+	}
+
+	f.mu.Lock()
+	f.requests = append(f.requests, request)
+	var content string
+	if len(f.Replies) > 0 {
+		content, f.Replies = f.Replies[0], f.Replies[1:]
+	} else if request.JSONSchema != nil {
+		sample, _ := json.Marshal(sampleFromSchema(request.JSONSchema.Schema))
+		content = string(sample)
+	} else {
+		content = "You said: " + lastUserMessage(request.Messages)
+	}
+	f.mu.Unlock()
+
+	promptTokens := 0
+	hash := sha256.New()
+	for _, message := range request.Messages {
+		promptTokens += len(strings.Fields(message.Content))
+		hash.Write([]byte(message.Role + "\x00" + message.Content + "\x00"))
+	}
+	completionTokens := len(strings.Fields(content))
+
+	return models.AIChatResponse{
+		ID:            "fake-" + hex.EncodeToString(hash.Sum(nil))[:16],
+		Model:         "fake",
+		Content:       content,
+		Finish_reason: "stop",
+		Usage: models.AIUsage{
+			Prompt_tokens:     promptTokens,
+			Completion_tokens: completionTokens,
+			Total_tokens:      promptTokens + completionTokens,
+		},
+		Created_at: time.Now().UTC(),
+	}, nil
+}
+
+// StreamChat sends the answer of Chat word by word
+func (f *FakeChatProvider) StreamChat(ctx context.Context, request ChatRequest, onDelta func(delta string) error) (models.AIChatResponse, error) {
+	response, err := f.Chat(ctx, request)
+	if err != nil {
+		return response, err
+	}
+
+	var content strings.Builder
+	for _, delta := range strings.SplitAfter(response.Content, " ") {
+		if err := ctx.Err(); err != nil {
+			response.Content = content.String()
+			return response, err
+		}
+		content.WriteString(delta)
+		if err := onDelta(delta); err != nil {
+			response.Content = content.String()
+			return response, err
+		}
+	}
+	return response, nil
+}
+
+// fakeEmbeddingDimensions is the length of the fake vectors
+const fakeEmbeddingDimensions = 256
+
+// Embed hashes the words of each input into a vector, so texts sharing words are similar. It is no match for a
+// model but lets document search run offline.
+func (f *FakeChatProvider) Embed(ctx context.Context, request EmbeddingRequest) (EmbeddingResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return EmbeddingResponse{}, err
+	}
+
+	response := EmbeddingResponse{Model: "fake-embedding", Vectors: make([][]float64, len(request.Input))}
+	for i, input := range request.Input {
+		vector := make([]float64, fakeEmbeddingDimensions)
+		words := strings.FieldsFunc(strings.ToLower(input), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+		})
+		for _, word := range words {
+			hash := fnv.New32a()
+			hash.Write([]byte(word))
+			vector[hash.Sum32()%fakeEmbeddingDimensions]++
+		}
+
+		var norm float64
+		for _, value := range vector {
+			norm += value * value
+		}
+		if norm > 0 {
+			norm = math.Sqrt(norm)
+			for j := range vector {
+				vector[j] /= norm
+			}
+		}
+
+		response.Vectors[i] = vector
+		response.Usage.Prompt_tokens += len(words)
+	}
+	response.Usage.Total_tokens = response.Usage.Prompt_tokens
+	return response, nil
+}
+
+// Requests returns the chats received so far
+func (f *FakeChatProvider) Requests() []ChatRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]ChatRequest(nil), f.requests...)
+}
+
+func lastUserMessage(messages []models.AIMessage) string {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == "user" {
+			return messages[i].Content
+		}
+	}
+	return ""
+}
+
+// sampleFromSchema builds the smallest document matching a JSON schema: enums take their first value, numbers their
+// minimum, arrays their minItems and strings a placeholder
+func sampleFromSchema(schema map[string]interface{}) interface{} {
+	if values, ok := schema["enum"].([]interface{}); ok && len(values) > 0 {
+		return values[0]
+	}
+
+	switch schema["type"] {
+	case "object":
+		sample := map[string]interface{}{}
+		properties, _ := schema["properties"].(map[string]interface{})
+		for name, property := range properties {
+			if propertySchema, ok := property.(map[string]interface{}); ok {
+				sample[name] = sampleFromSchema(propertySchema)
+			}
+		}
+		return sample
+	case "array":
+		count := 1
+		if minItems, ok := schema["minItems"].(int); ok && minItems > count {
+			count = minItems
+		}
+		items, _ := schema["items"].(map[string]interface{})
+		sample := make([]interface{}, count)
+		for i := range sample {
+			sample[i] = sampleFromSchema(items)
+		}
+		return sample
+	case "integer", "number":
+		if minimum, ok := schema["minimum"].(int); ok {
+			return minimum
+		}
+		return 1
+	case "boolean":
+		return false
+	}
+	return "Sample text"
+}
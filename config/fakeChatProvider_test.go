@@ -0,0 +1,201 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gambl/models"
+)
+
+func TestFakeChatProviderIsDeterministic(t *testing.T) {
+	request := ChatRequest{Messages: []models.AIMessage{
+		{Role: "system", Content: "You are a teacher"},
+		{Role: "user", Content: "What is photosynthesis?"},
+	}}
+
+	first, err := (&FakeChatProvider{}).Chat(context.Background(), request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := (&FakeChatProvider{}).Chat(context.Background(), request)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first.ID != second.ID || first.Content != second.Content || first.Usage != second.Usage {
+		t.Errorf("same chat answered %+v then %+v", first, second)
+	}
+	if first.Content != "You said: What is photosynthesis?" {
+		t.Errorf("Content = %q, want the last user message echoed", first.Content)
+	}
+	if want := (models.AIUsage{Prompt_tokens: 7, Completion_tokens: 5, Total_tokens: 12}); first.Usage != want {
+		t.Errorf("Usage = %+v, want %+v", first.Usage, want)
+	}
+
+	other, _ := (&FakeChatProvider{}).Chat(context.Background(), ChatRequest{Messages: []models.AIMessage{{Role: "user", Content: "Hello"}}})
+	if other.ID == first.ID {
+		t.Errorf("different chats share the id %s", first.ID)
+	}
+}
+
+func TestFakeChatProviderReplies(t *testing.T) {
+	provider := &FakeChatProvider{Replies: []string{"first", "second"}}
+	request := ChatRequest{Messages: []models.AIMessage{{Role: "user", Content: "Hi"}}}
+
+	for _, want := range []string{"first", "second", "You said: Hi"} {
+		response, err := provider.Chat(context.Background(), request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response.Content != want {
+			t.Errorf("Content = %q, want %q", response.Content, want)
+		}
+	}
+	if len(provider.Requests()) != 3 {
+		t.Errorf("%d requests recorded, want 3", len(provider.Requests()))
+	}
+}
+
+func TestFakeChatProviderStreamsTheChatAnswer(t *testing.T) {
+	provider := &FakeChatProvider{Replies: []string{"one two three"}}
+
+	var deltas []string
+	response, err := provider.StreamChat(context.Background(), ChatRequest{}, func(delta string) error {
+		deltas = append(deltas, delta)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(deltas, []string{"one ", "two ", "three"}) {
+		t.Errorf("deltas = %q", deltas)
+	}
+	if response.Content != "one two three" {
+		t.Errorf("Content = %q", response.Content)
+	}
+}
+
+func TestFakeChatProviderAnswersTheSchema(t *testing.T) {
+	schema := JSONSchema{Name: "quiz", Schema: map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"title": map[string]interface{}{"type": "string"},
+			"questions": map[string]interface{}{
+				"type":     "array",
+				"minItems": 2,
+				"items": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"kind":   map[string]interface{}{"type": "string", "enum": []interface{}{"MULTIPLE_CHOICE", "SHORT_ANSWER"}},
+						"points": map[string]interface{}{"type": "integer", "minimum": 1},
+					},
+				},
+			},
+		},
+	}}
+
+	response, err := (&FakeChatProvider{}).Chat(context.Background(), ChatRequest{JSONSchema: &schema})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var quiz struct {
+		Title     string
+		Questions []struct {
+			Kind   string
+			Points int
+		}
+	}
+	if err := json.Unmarshal([]byte(response.Content), &quiz); err != nil {
+		t.Fatalf("answer %q is not JSON: %v", response.Content, err)
+	}
+	if quiz.Title == "" || len(quiz.Questions) != 2 || quiz.Questions[0].Kind != "MULTIPLE_CHOICE" || quiz.Questions[0].Points != 1 {
+		t.Errorf("answer %s does not match the schema", response.Content)
+	}
+}
+
+func TestSampleFromSchema(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema map[string]interface{}
+		want   interface{}
+	}{
+		{name: "string", schema: map[string]interface{}{"type": "string"}, want: "Sample text"},
+		{name: "no type", schema: map[string]interface{}{}, want: "Sample text"},
+		{name: "enum", schema: map[string]interface{}{"type": "string", "enum": []interface{}{"EASY", "HARD"}}, want: "EASY"},
+		{name: "integer", schema: map[string]interface{}{"type": "integer"}, want: 1},
+		{name: "integer minimum", schema: map[string]interface{}{"type": "integer", "minimum": 3}, want: 3},
+		{name: "number minimum", schema: map[string]interface{}{"type": "number", "minimum": 0}, want: 0},
+		{name: "boolean", schema: map[string]interface{}{"type": "boolean"}, want: false},
+		{
+			name:   "array",
+			schema: map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "boolean"}},
+			want:   []interface{}{false},
+		},
+		{
+			name:   "array minItems",
+			schema: map[string]interface{}{"type": "array", "minItems": 3, "items": map[string]interface{}{"type": "integer"}},
+			want:   []interface{}{1, 1, 1},
+		},
+		{
+			name: "object",
+			schema: map[string]interface{}{"type": "object", "properties": map[string]interface{}{
+				"name":  map[string]interface{}{"type": "string"},
+				"level": map[string]interface{}{"type": "integer", "minimum": 2},
+				"tags":  map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+			}},
+			want: map[string]interface{}{"name": "Sample text", "level": 2, "tags": []interface{}{"Sample text"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sampleFromSchema(tt.schema); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("sampleFromSchema() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFakeEmbeddings(t *testing.T) {
+	provider := &FakeChatProvider{}
+	request := EmbeddingRequest{Input: []string{"The water cycle", "the WATER cycle!", "Fractions and decimals", ""}}
+
+	response, err := provider.Embed(context.Background(), request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Vectors) != len(request.Input) {
+		t.Fatalf("%d vectors for %d inputs", len(response.Vectors), len(request.Input))
+	}
+
+	again, _ := provider.Embed(context.Background(), request)
+	if !reflect.DeepEqual(response.Vectors, again.Vectors) {
+		t.Error("the same inputs got different vectors")
+	}
+
+	for i, vector := range response.Vectors[:3] {
+		var norm float64
+		for _, value := range vector {
+			norm += value * value
+		}
+		if math.Abs(norm-1) > 1e-9 {
+			t.Errorf("vector %d has squared norm %f, want 1", i, norm)
+		}
+	}
+	if !reflect.DeepEqual(response.Vectors[0], response.Vectors[1]) {
+		t.Error("case and punctuation changed the vector")
+	}
+	for _, value := range response.Vectors[3] {
+		if value != 0 {
+			t.Fatal("an empty input got a non zero vector")
+		}
+	}
+	if want := len(strings.Fields("The water cycle the WATER cycle Fractions and decimals")); response.Usage.Prompt_tokens != want {
+		t.Errorf("Prompt_tokens = %d, want %d", response.Usage.Prompt_tokens, want)
+	}
+}
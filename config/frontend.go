@@ -0,0 +1,16 @@
+package config
+
+import (
+	"os"
+	"strings"
+)
+
+// FrontendURL is the base url of the web app, used to build the links sent in emails
+func FrontendURL() string {
+	url := os.Getenv("FRONTEND_BASE_URL")
+	if url == "" {
+		url = "http://localhost:3000"
+	}
+
+	return strings.TrimRight(url, "/")
+}
@@ -1,102 +1,50 @@
 package config
 
-// using SendGrid's Go Library
-// https://github.com/sendgrid/sendgrid-go
-
 import (
-	"fmt"
+	"context"
 	"gambl/models"
 	"log"
-	"os"
-
-	"github.com/sendgrid/sendgrid-go"
-	"github.com/sendgrid/sendgrid-go/helpers/mail"
+	"net/url"
+	"time"
 )
 
-func SendOTPMail(email string, otp string) {
-	from := mail.NewEmail("LearnuimAI", "info@learniumai.com")
-	subject := "OTP"
-	to := mail.NewEmail("Hello", email)
-
-	m := mail.NewV3MailInit(from, subject, to) //  content,
-
-	m.Personalizations[0].SetDynamicTemplateData("otp", otp)
-	// m.Personalizations[0].SetSubstitution("-otp-", "10028")
-	m.SetTemplateID("d-178e0acf6fa74b8a8216cc96d6874b8c")
+func SendOTPMail(email string, otp string, locale string) {
+	sendTemplateMail(IdempotencyKey("otp", email, otp), []Address{{Name: "Hello", Email: email}}, "otp", locale,
+		map[string]interface{}{"Code": otp})
+}
 
-	client := sendgrid.NewSendClient(os.Getenv("SENDGRID_KEY"))
-	response, err := client.Send(m)
-	if err != nil {
-		log.Println(err)
-	} else {
-		fmt.Println(response.StatusCode)
-		fmt.Println(response.Body)
-		fmt.Println(response.Headers)
-	}
+// SendPrecisionVerifyMail sends the email verification link, the web app passes the token on to GET /users/verify/:token
+func SendPrecisionVerifyMail(email string, token string, locale string) {
+	completeLink := FrontendURL() + "/verify/" + url.PathEscape(token)
 
+	sendTemplateMail(IdempotencyKey("verify", email, token), []Address{{Name: "Hello", Email: email}}, "verify", locale,
+		map[string]interface{}{"Link": completeLink})
 }
 
-func SendPrecisionVerifyMail(email string, id string) {
-	from := mail.NewEmail("LearnuimAI", "info@learniumai.com")
-	subject := "Verify account"
-	to := mail.NewEmail("Hello", email)
+func SendPasswordResetMail(email string, code string, locale string) {
+	completeLink := FrontendURL() + "/reset-password?email=" + url.QueryEscape(email) + "&code=" + url.QueryEscape(code)
 
-	m := mail.NewV3MailInit(from, subject, to) //  content,
+	sendTemplateMail(IdempotencyKey("password_reset", email, code), []Address{{Name: "Hello", Email: email}}, "password_reset", locale,
+		map[string]interface{}{"Code": code, "Link": completeLink})
+}
 
-	completeLink := "http://frontend.com/" + id
-	m.Personalizations[0].SetDynamicTemplateData("link", completeLink)
-	m.SetTemplateID("d-258ea1b6842540bda156c6242db69d2c")
+func SendAccountLockedMail(email string, lockedUntil time.Time, locale string) {
+	until := lockedUntil.UTC().Format("02 Jan 2006 15:04 MST")
 
-	client := sendgrid.NewSendClient(os.Getenv("SENDGRID_KEY"))
-	response, err := client.Send(m)
-	if err != nil {
-		log.Println(err)
-	} else {
-		fmt.Println(response.StatusCode)
-		fmt.Println(response.Body)
-		fmt.Println(response.Headers)
-	}
+	sendTemplateMail(IdempotencyKey("account_locked", email, until), []Address{{Name: "Hello", Email: email}}, "account_locked", locale,
+		map[string]interface{}{"LockedUntil": until, "Link": FrontendURL() + "/forgot-password"})
 }
 
 func SendNewUserMail(email models.NewUserAlert) {
-	from := mail.NewEmail("LearnuimAI", "info@learniumai.com")
-	subject := "New User Registration"
-	to := mail.NewEmail("LearnuimAI", "info@learniumai.com")
-
-	m := mail.NewV3MailInit(from, subject, to)
-	personalization := mail.NewPersonalization()
-	personalization.AddTos(to)
-
-	// Create Content with plain text and HTML
-	content := []mail.Content{
-		*mail.NewContent("text/plain", "A new user has registered.\n Details:\nName: "+email.First_name+" "+email.Last_name+"\nEmail: "+email.Email+"\nRoleType: "+email.User_type),
-	}
-
-	m.AddPersonalizations(personalization)
-	for _, c := range content {
-		m.AddContent(&c)
-	}
-
-	client := sendgrid.NewSendClient(os.Getenv("SENDGRID_KEY"))
-	response, err := client.Send(m)
-	if err != nil {
-		log.Println(err)
-	} else {
-		fmt.Println(response.StatusCode)
-		fmt.Println(response.Body)
-		fmt.Println(response.Headers)
-	}
+	sendTemplateMail(IdempotencyKey("new_user", email.Email), []Address{MailAdminRecipient()}, "new_user", MailAdminLocale(),
+		map[string]interface{}{
+			"Name":     email.First_name + " " + email.Last_name,
+			"Email":    email.Email,
+			"UserType": email.User_type,
+		})
 }
 
 func SendUserDetails(user models.User) {
-	from := mail.NewEmail("LearnuimAI", "info@learniumai.com")
-	subject := "New User Registration"
-	to := mail.NewEmail("Learnuim-User-Alert", "info@learniumai.com")
-
-	m := mail.NewV3MailInit(from, subject, to)
-	personalization := mail.NewPersonalization()
-	personalization.AddTos(to)
-
 	// Build the content safely
 	var name string
 	if user.First_name != nil && user.Last_name != nil {
@@ -120,35 +68,45 @@ func SendUserDetails(user models.User) {
 	}
 
 	var phone string
-	if user.Phone == "" {
+	if user.Phone != "" {
 		phone = user.Phone
 	} else {
 		phone = "No phone number provided"
 	}
 
-	content := []mail.Content{
-		*mail.NewContent("text/plain", fmt.Sprintf(
-			"A new user has registered\nDetails:\nName: %s\nEmail: %s\nRoleType: %s\nPhoneNumber: %s",
-			name, email, roleType, phone)),
+	sendTemplateMail(IdempotencyKey("user_details", user.User_id), []Address{MailAdminRecipient()}, "user_details", MailAdminLocale(),
+		map[string]interface{}{
+			"Name":     name,
+			"Email":    email,
+			"UserType": roleType,
+			"Phone":    phone,
+		})
+}
+
+// sendTemplateMail renders a template in the locale and queues it
+func sendTemplateMail(idempotencyKey string, to []Address, name string, locale string, data map[string]interface{}) {
+	rendered, err := RenderEmail(name, locale, data)
+	if err != nil {
+		log.Printf("Error rendering the %s email: %v", name, err)
+		return
 	}
 
-	// Create Content with plain text and HTML
-	// content := []mail.Content{
-	// 	*mail.NewContent("text/plain", "A new user has registered\nDetails:\nName: "+*user.First_name+" "+*user.Last_name+"\nEmail: "+*user.Email+"\nRoleType: "+*user.User_type+"\nPhoneNumber: "+user.Phone),
-	// }
+	sendMail(idempotencyKey, Message{
+		From:    MailSender(),
+		To:      to,
+		Subject: rendered.Subject,
+		Text:    rendered.Text,
+		HTML:    rendered.HTML,
+	})
+}
 
-	m.AddPersonalizations(personalization)
-	for _, c := range content {
-		m.AddContent(&c)
-	}
+// sendMail queues a message in the outbox, the workers deliver it and retry on failure. An email with the same
+// idempotency key is only queued once.
+func sendMail(idempotencyKey string, message Message) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 
-	client := sendgrid.NewSendClient(os.Getenv("SENDGRID_KEY"))
-	response, err := client.Send(m)
-	if err != nil {
-		log.Println(err)
-	} else {
-		fmt.Println(response.StatusCode)
-		fmt.Println(response.Body)
-		fmt.Println(response.Headers)
+	if _, _, err := enqueueEmail(ctx, idempotencyKey, message); err != nil {
+		log.Printf("Error queueing %q to %v: %v", message.Subject, message.To, err)
 	}
 }
@@ -0,0 +1,98 @@
+package config
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"gambl/models"
+)
+
+// useMemoryMailer delivers the emails queued by sendMail straight to a MemoryMailer, standing in for the outbox
+// workers, and returns the mailer with the idempotency keys the emails were queued with
+func useMemoryMailer(t *testing.T) (*MemoryMailer, *[]string) {
+	mailerState.Lock()
+	previousMailer := mailerState.mailer
+	mailerState.Unlock()
+	previousEnqueue := enqueueEmail
+
+	mailer := &MemoryMailer{}
+	SetMailer(mailer)
+	keys := []string{}
+	enqueueEmail = func(ctx context.Context, idempotencyKey string, message Message) (models.OutboxEmail, bool, error) {
+		keys = append(keys, idempotencyKey)
+		return models.OutboxEmail{Idempotency_key: idempotencyKey, Message: message}, true, GetMailer().Send(ctx, message)
+	}
+
+	t.Cleanup(func() {
+		SetMailer(previousMailer)
+		enqueueEmail = previousEnqueue
+	})
+	return mailer, &keys
+}
+
+func TestSendOTPMailQueuesTheRenderedEmail(t *testing.T) {
+	t.Setenv("MAIL_FROM_NAME", "Gambl")
+	t.Setenv("MAIL_FROM_ADDRESS", "noreply@example.com")
+
+	tests := []struct {
+		locale      string
+		wantSubject string
+		wantText    string
+	}{
+		{locale: "en", wantSubject: "Your", wantText: "Your verification code is: 482913"},
+		{locale: "fr", wantSubject: "Votre code", wantText: "Votre code de vérification est : 482913"},
+		{locale: "de", wantSubject: "Your", wantText: "Your verification code is: 482913"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.locale, func(t *testing.T) {
+			mailer, keys := useMemoryMailer(t)
+
+			SendOTPMail("ada@example.com", "482913", tt.locale)
+
+			messages := mailer.Messages()
+			if len(messages) != 1 {
+				t.Fatalf("%d emails sent, want 1", len(messages))
+			}
+			message := messages[0]
+
+			if message.From != (Address{Name: "Gambl", Email: "noreply@example.com"}) {
+				t.Errorf("From = %+v", message.From)
+			}
+			if len(message.To) != 1 || message.To[0].Email != "ada@example.com" {
+				t.Errorf("To = %+v, want ada@example.com", message.To)
+			}
+			if !strings.HasPrefix(message.Subject, tt.wantSubject) {
+				t.Errorf("Subject = %q, want it to start with %q", message.Subject, tt.wantSubject)
+			}
+			if !strings.Contains(message.Text, tt.wantText) {
+				t.Errorf("Text = %q, want it to contain %q", message.Text, tt.wantText)
+			}
+			if !strings.Contains(message.HTML, "482913") {
+				t.Errorf("HTML does not contain the code: %q", message.HTML)
+			}
+			if len(*keys) != 1 || (*keys)[0] != IdempotencyKey("otp", "ada@example.com", "482913") {
+				t.Errorf("queued with keys %v", *keys)
+			}
+		})
+	}
+}
+
+func TestSendNewUserMailGoesToTheAdminRecipient(t *testing.T) {
+	t.Setenv("MAIL_ADMIN_ADDRESS", "alerts@example.com")
+	mailer, _ := useMemoryMailer(t)
+
+	SendNewUserMail(models.NewUserAlert{First_name: "Ada", Last_name: "Lovelace", Email: "ada@example.com", User_type: "TEACHER"})
+
+	messages := mailer.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("%d emails sent, want 1", len(messages))
+	}
+	if len(messages[0].To) != 1 || messages[0].To[0].Email != "alerts@example.com" {
+		t.Errorf("To = %+v, want alerts@example.com", messages[0].To)
+	}
+	if !strings.Contains(messages[0].Text, "Ada Lovelace") || !strings.Contains(messages[0].Text, "ada@example.com") {
+		t.Errorf("Text = %q, want the new user's name and email", messages[0].Text)
+	}
+}
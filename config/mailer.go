@@ -0,0 +1,97 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"gambl/models"
+)
+
+// Address and Message are the models, so every package can build emails
+type (
+	Address = models.Address
+	Message = models.Message
+)
+
+// Mailer delivers emails. The driver is picked with MAIL_DRIVER.
+type Mailer interface {
+	Send(ctx context.Context, message Message) error
+}
+
+var mailerState struct {
+	sync.Mutex
+	mailer Mailer
+}
+
+// GetMailer returns the configured mailer, creating it from the environment on first use
+func GetMailer() Mailer {
+	mailerState.Lock()
+	defer mailerState.Unlock()
+
+	if mailerState.mailer == nil {
+		mailer, err := NewMailer(os.Getenv("MAIL_DRIVER"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		mailerState.mailer = mailer
+	}
+	return mailerState.mailer
+}
+
+// SetMailer replaces the mailer, for tests and local tools
+func SetMailer(mailer Mailer) {
+	mailerState.Lock()
+	defer mailerState.Unlock()
+	mailerState.mailer = mailer
+}
+
+// NewMailer creates the mailer of a driver: sendgrid (the default), smtp, memory or file
+func NewMailer(driver string) (Mailer, error) {
+	switch strings.ToLower(driver) {
+	case "", "sendgrid":
+		return &SendgridMailer{Api_key: os.Getenv("SENDGRID_KEY")}, nil
+	case "smtp":
+		return NewSMTPMailerFromEnv()
+	case "memory":
+		return &MemoryMailer{}, nil
+	case "file":
+		dir := os.Getenv("MAIL_OUTBOX_DIR")
+		if dir == "" {
+			dir = "outbox"
+		}
+		return &FileMailer{Dir: dir}, nil
+	}
+	return nil, fmt.Errorf("unknown MAIL_DRIVER %q", driver)
+}
+
+// MailSender is the identity emails are sent from, from MAIL_FROM_NAME and MAIL_FROM_ADDRESS
+func MailSender() Address {
+	return Address{
+		Name:  envOrDefault("MAIL_FROM_NAME", "LearnuimAI"),
+		Email: envOrDefault("MAIL_FROM_ADDRESS", "info@learniumai.com"),
+	}
+}
+
+// MailAdminRecipient receives the new user alerts, from MAIL_ADMIN_NAME and MAIL_ADMIN_ADDRESS
+func MailAdminRecipient() Address {
+	return Address{
+		Name:  envOrDefault("MAIL_ADMIN_NAME", "Learnuim-User-Alert"),
+		Email: envOrDefault("MAIL_ADMIN_ADDRESS", "info@learniumai.com"),
+	}
+}
+
+// MailAdminLocale is the locale of the alerts sent to the admin recipient, from MAIL_ADMIN_LOCALE
+func MailAdminLocale() string {
+	return EmailLocale(envOrDefault("MAIL_ADMIN_LOCALE", DefaultEmailLocale))
+}
+
+func envOrDefault(name string, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -1,74 +1,314 @@
 package config
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
-	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"os"
+	"strings"
+	"time"
 
-	"github.com/joho/godotenv"
+	"gambl/models"
 )
 
-const apiURL = "https://api.openai.com/v1/chat/completions" // Endpoint URL
+const defaultOpenAIBaseURL = "https://api.openai.com/v1"
 
-func AskOpenAI(message string) (map[string]interface{}, error) {
+// OpenAIProvider talks to the OpenAI chat completions API, or any API compatible with it such as a self-hosted
+// model server or a proxy
+type OpenAIProvider struct {
+	Base_url string
+	Api_key  string
+	Client   *http.Client
+}
 
-	err := godotenv.Load(".env")
+// NewOpenAIProviderFromEnv reads OPENAI_BASE_URL and OPENAI_KEY
+func NewOpenAIProviderFromEnv() *OpenAIProvider {
+	return &OpenAIProvider{
+		Base_url: strings.TrimRight(envOrDefault("OPENAI_BASE_URL", defaultOpenAIBaseURL), "/"),
+		Api_key:  os.Getenv("OPENAI_KEY"),
+		Client:   http.DefaultClient,
+	}
+}
+
+type openAIRequest struct {
+	Model          string                `json:"model"`
+	Messages       []models.AIMessage    `json:"messages"`
+	MaxTokens      int                   `json:"max_tokens"`
+	Temperature    float64               `json:"temperature"`
+	Stream         bool                  `json:"stream,omitempty"`
+	StreamOptions  *openAIStreamOptions  `json:"stream_options,omitempty"`
+	ResponseFormat *openAIResponseFormat `json:"response_format,omitempty"`
+}
+
+type openAIResponseFormat struct {
+	Type       string `json:"type"`
+	JSONSchema struct {
+		Name   string                 `json:"name"`
+		Schema map[string]interface{} `json:"schema"`
+		Strict bool                   `json:"strict"`
+	} `json:"json_schema"`
+}
+
+// responseFormat asks for structured outputs when the request has a schema
+func responseFormat(request ChatRequest) *openAIResponseFormat {
+	if request.JSONSchema == nil {
+		return nil
+	}
+	format := &openAIResponseFormat{Type: "json_schema"}
+	format.JSONSchema.Name = request.JSONSchema.Name
+	format.JSONSchema.Schema = request.JSONSchema.Schema
+	format.JSONSchema.Strict = true
+	return format
+}
+
+type openAIStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+type openAIUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+func (u openAIUsage) toModel() models.AIUsage {
+	return models.AIUsage{
+		Prompt_tokens:     u.PromptTokens,
+		Completion_tokens: u.CompletionTokens,
+		Total_tokens:      u.TotalTokens,
+	}
+}
 
+type openAIResponse struct {
+	ID      string `json:"id"`
+	Model   string `json:"model"`
+	Created int64  `json:"created"`
+	Choices []struct {
+		Message      models.AIMessage `json:"message"`
+		FinishReason string           `json:"finish_reason"`
+	} `json:"choices"`
+	Usage openAIUsage `json:"usage"`
+}
+
+// openAIStreamChunk is one server-sent event of a streamed completion, the usage comes in a last chunk without choices
+type openAIStreamChunk struct {
+	ID      string `json:"id"`
+	Model   string `json:"model"`
+	Created int64  `json:"created"`
+	Choices []struct {
+		Delta        models.AIMessage `json:"delta"`
+		FinishReason string           `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *openAIUsage `json:"usage"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (p *OpenAIProvider) Chat(ctx context.Context, request ChatRequest) (models.AIChatResponse, error) {
+	var result openAIResponse
+	err := p.post(ctx, "/chat/completions", openAIRequest{
+		Model:          request.Model,
+		Messages:       request.Messages,
+		MaxTokens:      request.MaxTokens,
+		Temperature:    request.Temperature,
+		ResponseFormat: responseFormat(request),
+	}, &result)
 	if err != nil {
-		log.Fatal("Error loading .env file")
+		return models.AIChatResponse{}, err
+	}
+	if len(result.Choices) == 0 {
+		return models.AIChatResponse{}, &AIProviderError{StatusCode: http.StatusOK, Message: "response has no choices"}
 	}
 
-	apiKey := os.Getenv("OPENAI_KEY")
+	return models.AIChatResponse{
+		ID:            result.ID,
+		Model:         result.Model,
+		Content:       result.Choices[0].Message.Content,
+		Finish_reason: result.Choices[0].FinishReason,
+		Usage:         result.Usage.toModel(),
+		Created_at:    time.Unix(result.Created, 0).UTC(),
+	}, nil
+}
 
-	requestBody := map[string]interface{}{
-		"model": "gpt-4o-mini",
-		"messages": []map[string]string{
-			{"role": "user", "content": "Translate the following English text to French: 'Hello, how are you?'"},
-		},
-		"max_tokens":  60,
-		"temperature": 0.5,
+// StreamChat reads the completion as server-sent events. When it stops early the answer so far is returned with the error.
+func (p *OpenAIProvider) StreamChat(ctx context.Context, request ChatRequest, onDelta func(delta string) error) (models.AIChatResponse, error) {
+	req, err := p.newRequest(ctx, "/chat/completions", openAIRequest{
+		Model:          request.Model,
+		Messages:       request.Messages,
+		MaxTokens:      request.MaxTokens,
+		Temperature:    request.Temperature,
+		Stream:         true,
+		StreamOptions:  &openAIStreamOptions{IncludeUsage: true},
+		ResponseFormat: responseFormat(request),
+	})
+	if err != nil {
+		return models.AIChatResponse{}, err
 	}
 
-	requestData, err := json.Marshal(requestBody)
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return models.AIChatResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		return models.AIChatResponse{}, providerError(resp.StatusCode, body)
+	}
+
+	var response models.AIChatResponse
+	var content strings.Builder
+	answer := func() models.AIChatResponse {
+		response.Content = content.String()
+		return response
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "[DONE]" {
+			break
+		}
+
+		var chunk openAIStreamChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return answer(), &AIProviderError{StatusCode: resp.StatusCode, Message: "stream event is not valid JSON"}
+		}
+		if chunk.Error != nil {
+			return answer(), &AIProviderError{StatusCode: resp.StatusCode, Message: chunk.Error.Message}
+		}
+
+		if chunk.ID != "" {
+			response.ID = chunk.ID
+			response.Model = chunk.Model
+			response.Created_at = time.Unix(chunk.Created, 0).UTC()
+		}
+		for _, choice := range chunk.Choices {
+			if choice.Delta.Content != "" {
+				content.WriteString(choice.Delta.Content)
+				if err := onDelta(choice.Delta.Content); err != nil {
+					return answer(), err
+				}
+			}
+			if choice.FinishReason != "" {
+				response.Finish_reason = choice.FinishReason
+			}
+		}
+		if chunk.Usage != nil {
+			response.Usage = chunk.Usage.toModel()
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return answer(), err
+	}
+	return answer(), nil
+}
+
+type openAIEmbeddingRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+type openAIEmbeddingResponse struct {
+	Model string `json:"model"`
+	Data  []struct {
+		Index     int       `json:"index"`
+		Embedding []float64 `json:"embedding"`
+	} `json:"data"`
+	Usage openAIUsage `json:"usage"`
+}
+
+func (p *OpenAIProvider) Embed(ctx context.Context, request EmbeddingRequest) (EmbeddingResponse, error) {
+	var result openAIEmbeddingResponse
+	err := p.post(ctx, "/embeddings", openAIEmbeddingRequest{Model: request.Model, Input: request.Input}, &result)
+	if err != nil {
+		return EmbeddingResponse{}, err
+	}
+
+	vectors := make([][]float64, len(request.Input))
+	for _, data := range result.Data {
+		if data.Index < 0 || data.Index >= len(vectors) {
+			return EmbeddingResponse{}, &AIProviderError{StatusCode: http.StatusOK, Message: "embedding index out of range"}
+		}
+		vectors[data.Index] = data.Embedding
+	}
+	for _, vector := range vectors {
+		if vector == nil {
+			return EmbeddingResponse{}, &AIProviderError{StatusCode: http.StatusOK, Message: "response is missing embeddings"}
+		}
+	}
+
+	return EmbeddingResponse{Model: result.Model, Vectors: vectors, Usage: result.Usage.toModel()}, nil
+}
+
+// newRequest builds an authenticated request. Only the OpenAI API itself requires a key, self-hosted endpoints may not.
+func (p *OpenAIProvider) newRequest(ctx context.Context, path string, payload interface{}) (*http.Request, error) {
+	if p.Api_key == "" && p.Base_url == defaultOpenAIBaseURL {
+		return nil, ErrAINotConfigured
+	}
+
+	requestData, err := json.Marshal(payload)
 	if err != nil {
-		log.Fatalf("Error marshaling request data: %v", err)
 		return nil, err
 	}
 
-	req, err := http.NewRequest("POST", apiURL, bytes.NewBuffer(requestData))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.Base_url+path, bytes.NewBuffer(requestData))
 	if err != nil {
-		log.Fatalf("Error creating request: %v", err)
 		return nil, err
 	}
 
-	req.Header.Set("Authorization", "Bearer "+apiKey)
+	if p.Api_key != "" {
+		req.Header.Set("Authorization", "Bearer "+p.Api_key)
+	}
 	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+// post sends a request and decodes the response, errors reported by the API become an AIProviderError
+func (p *OpenAIProvider) post(ctx context.Context, path string, payload interface{}, result interface{}) error {
+	req, err := p.newRequest(ctx, path, payload)
 	if err != nil {
-		log.Fatalf("Error making request: %v", err)
-		return nil, err
+		return err
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	resp, err := p.Client.Do(req)
 	if err != nil {
-		log.Fatalf("Error reading response body: %v", err)
-		return nil, err
+		return err
 	}
+	defer resp.Body.Close()
 
-	var result map[string]interface{}
-	if err := json.Unmarshal(body, &result); err != nil {
-		log.Fatalf("Error unmarshaling response: %v", err)
-		return nil, err
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if err != nil {
+		return err
 	}
 
-	fmt.Println("Response:", result)
-	return result, nil
+	if resp.StatusCode >= 300 {
+		return providerError(resp.StatusCode, body)
+	}
+	if err := json.Unmarshal(body, result); err != nil {
+		return &AIProviderError{StatusCode: resp.StatusCode, Message: "response is not valid JSON"}
+	}
+	return nil
+}
 
+func providerError(statusCode int, body []byte) error {
+	var response struct {
+		Error *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	message := http.StatusText(statusCode)
+	if json.Unmarshal(body, &response) == nil && response.Error != nil && response.Error.Message != "" {
+		message = response.Error.Message
+	}
+	return &AIProviderError{StatusCode: statusCode, Message: message}
 }
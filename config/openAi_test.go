@@ -0,0 +1,307 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gambl/models"
+)
+
+// openAIServer answers every request with the handler and records the last request body
+func openAIServer(t *testing.T, handler func(w http.ResponseWriter, body map[string]interface{})) (*OpenAIProvider, *http.Request) {
+	var received http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = *r
+		data, _ := io.ReadAll(r.Body)
+		body := map[string]interface{}{}
+		if err := json.Unmarshal(data, &body); err != nil {
+			t.Errorf("request body is not JSON: %s", data)
+		}
+		handler(w, body)
+	}))
+	t.Cleanup(server.Close)
+
+	return &OpenAIProvider{Base_url: server.URL, Api_key: "sk-test", Client: server.Client()}, &received
+}
+
+func writeSSE(w http.ResponseWriter, events ...string) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	for _, event := range events {
+		fmt.Fprintf(w, "data: %s\n\n", event)
+	}
+}
+
+func TestOpenAIChat(t *testing.T) {
+	var body map[string]interface{}
+	provider, received := openAIServer(t, func(w http.ResponseWriter, request map[string]interface{}) {
+		body = request
+		fmt.Fprint(w, `{"id":"chatcmpl-1","model":"gpt-4o-mini","created":1700000000,
+			"choices":[{"message":{"role":"assistant","content":"Plants make food from light."},"finish_reason":"stop"}],
+			"usage":{"prompt_tokens":12,"completion_tokens":6,"total_tokens":18}}`)
+	})
+
+	schema := JSONSchema{Name: "answer", Schema: map[string]interface{}{"type": "object"}}
+	response, err := provider.Chat(context.Background(), ChatRequest{
+		Model:       "gpt-4o-mini",
+		Messages:    []models.AIMessage{{Role: "user", Content: "What is photosynthesis?"}},
+		MaxTokens:   100,
+		Temperature: 0.2,
+		JSONSchema:  &schema,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if received.URL.Path != "/chat/completions" || received.Header.Get("Authorization") != "Bearer sk-test" {
+		t.Errorf("request to %s with Authorization %q", received.URL.Path, received.Header.Get("Authorization"))
+	}
+	if body["model"] != "gpt-4o-mini" || body["max_tokens"] != float64(100) || body["temperature"] != 0.2 || body["stream"] != nil {
+		t.Errorf("request body = %v", body)
+	}
+	format, _ := body["response_format"].(map[string]interface{})
+	if format["type"] != "json_schema" {
+		t.Errorf("response_format = %v, want a json_schema", body["response_format"])
+	}
+
+	want := models.AIChatResponse{
+		ID:            "chatcmpl-1",
+		Model:         "gpt-4o-mini",
+		Content:       "Plants make food from light.",
+		Finish_reason: "stop",
+		Usage:         models.AIUsage{Prompt_tokens: 12, Completion_tokens: 6, Total_tokens: 18},
+	}
+	response.Created_at = want.Created_at
+	if !reflect.DeepEqual(response, want) {
+		t.Errorf("Chat() = %+v, want %+v", response, want)
+	}
+}
+
+func TestOpenAIChatErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		body       string
+		wantStatus int
+		wantText   string
+	}{
+		{name: "api error", status: http.StatusTooManyRequests, body: `{"error":{"message":"Rate limit reached"}}`, wantStatus: 429, wantText: "Rate limit reached"},
+		{name: "error without a body", status: http.StatusBadGateway, body: `<html>bad gateway</html>`, wantStatus: 502, wantText: "Bad Gateway"},
+		{name: "no choices", status: http.StatusOK, body: `{"id":"chatcmpl-1","choices":[]}`, wantStatus: 200, wantText: "no choices"},
+		{name: "invalid JSON", status: http.StatusOK, body: `{"id":`, wantStatus: 200, wantText: "not valid JSON"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider, _ := openAIServer(t, func(w http.ResponseWriter, _ map[string]interface{}) {
+				w.WriteHeader(tt.status)
+				fmt.Fprint(w, tt.body)
+			})
+
+			_, err := provider.Chat(context.Background(), ChatRequest{Model: "gpt-4o-mini"})
+
+			var providerErr *AIProviderError
+			if !errors.As(err, &providerErr) || providerErr.StatusCode != tt.wantStatus || !strings.Contains(providerErr.Message, tt.wantText) {
+				t.Errorf("Chat() error = %v, want status %d with %q", err, tt.wantStatus, tt.wantText)
+			}
+		})
+	}
+}
+
+func TestOpenAIChatNeedsAKeyForTheOpenAIAPI(t *testing.T) {
+	provider := &OpenAIProvider{Base_url: defaultOpenAIBaseURL, Client: http.DefaultClient}
+	if _, err := provider.Chat(context.Background(), ChatRequest{}); err != ErrAINotConfigured {
+		t.Errorf("Chat() error = %v, want ErrAINotConfigured", err)
+	}
+}
+
+func TestOpenAIStreamChat(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		events      []string
+		stopAfter   int
+		wantDeltas  []string
+		wantContent string
+		wantUsage   models.AIUsage
+		wantFinish  string
+		wantErr     string
+	}{
+		{
+			name: "deltas then usage",
+			events: []string{
+				`{"id":"chatcmpl-1","model":"gpt-4o-mini","created":1700000000,"choices":[{"delta":{"role":"assistant","content":""}}]}`,
+				`{"id":"chatcmpl-1","model":"gpt-4o-mini","created":1700000000,"choices":[{"delta":{"content":"Plants "}}]}`,
+				`{"id":"chatcmpl-1","model":"gpt-4o-mini","created":1700000000,"choices":[{"delta":{"content":"grow."},"finish_reason":"stop"}]}`,
+				`{"id":"chatcmpl-1","model":"gpt-4o-mini","created":1700000000,"choices":[],"usage":{"prompt_tokens":9,"completion_tokens":2,"total_tokens":11}}`,
+				`[DONE]`,
+			},
+			wantDeltas:  []string{"Plants ", "grow."},
+			wantContent: "Plants grow.",
+			wantUsage:   models.AIUsage{Prompt_tokens: 9, Completion_tokens: 2, Total_tokens: 11},
+			wantFinish:  "stop",
+		},
+		{
+			name: "events after DONE are ignored",
+			events: []string{
+				`{"id":"chatcmpl-1","choices":[{"delta":{"content":"Done"}}]}`,
+				`[DONE]`,
+				`{"id":"chatcmpl-1","choices":[{"delta":{"content":" and more"}}]}`,
+			},
+			wantDeltas:  []string{"Done"},
+			wantContent: "Done",
+		},
+		{
+			name: "error chunk",
+			events: []string{
+				`{"id":"chatcmpl-1","choices":[{"delta":{"content":"Partial"}}]}`,
+				`{"error":{"message":"The server had an error"}}`,
+			},
+			wantDeltas:  []string{"Partial"},
+			wantContent: "Partial",
+			wantErr:     "The server had an error",
+		},
+		{
+			name:        "invalid event",
+			events:      []string{`{"id":`},
+			wantContent: "",
+			wantErr:     "not valid JSON",
+		},
+		{
+			name:    "error status",
+			status:  http.StatusUnauthorized,
+			events:  []string{`{"error":{"message":"Incorrect API key provided"}}`},
+			wantErr: "Incorrect API key provided",
+		},
+		{
+			name: "onDelta stops the stream",
+			events: []string{
+				`{"id":"chatcmpl-1","choices":[{"delta":{"content":"One "}}]}`,
+				`{"id":"chatcmpl-1","choices":[{"delta":{"content":"two "}}]}`,
+				`{"id":"chatcmpl-1","choices":[{"delta":{"content":"three"}}]}`,
+			},
+			stopAfter:   2,
+			wantDeltas:  []string{"One ", "two "},
+			wantContent: "One two ",
+			wantErr:     "client went away",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body map[string]interface{}
+			provider, _ := openAIServer(t, func(w http.ResponseWriter, request map[string]interface{}) {
+				body = request
+				if tt.status != 0 {
+					w.WriteHeader(tt.status)
+					fmt.Fprint(w, tt.events[0])
+					return
+				}
+				writeSSE(w, tt.events...)
+			})
+
+			var deltas []string
+			response, err := provider.StreamChat(context.Background(), ChatRequest{Model: "gpt-4o-mini"}, func(delta string) error {
+				deltas = append(deltas, delta)
+				if tt.stopAfter > 0 && len(deltas) == tt.stopAfter {
+					return errors.New("client went away")
+				}
+				return nil
+			})
+
+			if body["stream"] != true {
+				t.Errorf("request body = %v, want stream", body)
+			}
+			if options, _ := body["stream_options"].(map[string]interface{}); options["include_usage"] != true {
+				t.Errorf("stream_options = %v, want include_usage", body["stream_options"])
+			}
+
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("StreamChat() error = %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("StreamChat() error = %v, want %q", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(deltas, tt.wantDeltas) {
+				t.Errorf("deltas = %q, want %q", deltas, tt.wantDeltas)
+			}
+			if response.Content != tt.wantContent {
+				t.Errorf("Content = %q, want %q", response.Content, tt.wantContent)
+			}
+			if response.Usage != tt.wantUsage {
+				t.Errorf("Usage = %+v, want %+v", response.Usage, tt.wantUsage)
+			}
+			if response.Finish_reason != tt.wantFinish {
+				t.Errorf("Finish_reason = %q, want %q", response.Finish_reason, tt.wantFinish)
+			}
+		})
+	}
+}
+
+func TestOpenAIEmbed(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		wantVectors [][]float64
+		wantErr     string
+	}{
+		{
+			name:        "vectors are placed by index",
+			body:        `{"model":"text-embedding-3-small","data":[{"index":1,"embedding":[0,1]},{"index":0,"embedding":[1,0]}],"usage":{"prompt_tokens":5,"total_tokens":5}}`,
+			wantVectors: [][]float64{{1, 0}, {0, 1}},
+		},
+		{
+			name:    "index out of range",
+			body:    `{"data":[{"index":0,"embedding":[1,0]},{"index":2,"embedding":[0,1]}]}`,
+			wantErr: "embedding index out of range",
+		},
+		{
+			name:    "negative index",
+			body:    `{"data":[{"index":-1,"embedding":[1,0]},{"index":1,"embedding":[0,1]}]}`,
+			wantErr: "embedding index out of range",
+		},
+		{
+			name:    "missing embedding",
+			body:    `{"data":[{"index":1,"embedding":[0,1]}]}`,
+			wantErr: "missing embeddings",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body map[string]interface{}
+			provider, received := openAIServer(t, func(w http.ResponseWriter, request map[string]interface{}) {
+				body = request
+				fmt.Fprint(w, tt.body)
+			})
+
+			response, err := provider.Embed(context.Background(), EmbeddingRequest{Model: "text-embedding-3-small", Input: []string{"first", "second"}})
+
+			if received.URL.Path != "/embeddings" || body["model"] != "text-embedding-3-small" || !reflect.DeepEqual(body["input"], []interface{}{"first", "second"}) {
+				t.Errorf("request to %s with %v", received.URL.Path, body)
+			}
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Embed() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(response.Vectors, tt.wantVectors) {
+				t.Errorf("Vectors = %v, want %v", response.Vectors, tt.wantVectors)
+			}
+			if response.Usage.Prompt_tokens != 5 || response.Model != "text-embedding-3-small" {
+				t.Errorf("response = %+v", response)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// MemoryMailer keeps sent emails in memory instead of delivering them, for tests
+type MemoryMailer struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func (m *MemoryMailer) Send(ctx context.Context, message Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, message)
+	return nil
+}
+
+// Messages returns the emails sent so far
+func (m *MemoryMailer) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Message(nil), m.messages...)
+}
+
+// Reset forgets the emails sent so far
+func (m *MemoryMailer) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = nil
+}
+
+// FileMailer writes every email to a directory instead of delivering it, for local development.
+// Each email is saved as an .eml file that mail clients can open, next to a .json file with the message.
+type FileMailer struct {
+	Dir string
+
+	mu  sync.Mutex
+	seq int
+}
+
+func (f *FileMailer) Send(ctx context.Context, message Message) error {
+	if err := os.MkdirAll(f.Dir, 0o755); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	f.mu.Lock()
+	f.seq++
+	name := filepath.Join(f.Dir, fmt.Sprintf("%s-%04d", now.Format("20060102T150405.000"), f.seq))
+	f.mu.Unlock()
+
+	eml, err := BuildMIMEMessage(message, now)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(name+".eml", eml, 0o644); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(message, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(name+".json", data, 0o644)
+}
@@ -0,0 +1,43 @@
+package config
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sendgrid/sendgrid-go"
+	"github.com/sendgrid/sendgrid-go/helpers/mail"
+)
+
+// SendgridMailer sends emails through the SendGrid API
+type SendgridMailer struct {
+	Api_key string
+}
+
+func (s *SendgridMailer) Send(ctx context.Context, message Message) error {
+	m := mail.NewV3Mail()
+	m.SetFrom(mail.NewEmail(message.From.Name, message.From.Email))
+	m.Subject = message.Subject
+
+	personalization := mail.NewPersonalization()
+	for _, to := range message.To {
+		personalization.AddTos(mail.NewEmail(to.Name, to.Email))
+	}
+	m.AddPersonalizations(personalization)
+
+	if message.Text != "" {
+		m.AddContent(mail.NewContent("text/plain", message.Text))
+	}
+	if message.HTML != "" {
+		m.AddContent(mail.NewContent("text/html", message.HTML))
+	}
+
+	// the client keeps the request body, so it is not shared between sends
+	response, err := sendgrid.NewSendClient(s.Api_key).SendWithContext(ctx, m)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("sendgrid responded %d: %s", response.StatusCode, response.Body)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SMTPMailer sends emails to an SMTP server, built by BuildMIMEMessage with their text and html bodies
+type SMTPMailer struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+}
+
+// NewSMTPMailerFromEnv reads SMTP_HOST, SMTP_PORT, SMTP_USERNAME and SMTP_PASSWORD
+func NewSMTPMailerFromEnv() (*SMTPMailer, error) {
+	host := envOrDefault("SMTP_HOST", "")
+	if host == "" {
+		return nil, errors.New("SMTP_HOST is required by the smtp MAIL_DRIVER")
+	}
+
+	port, err := strconv.Atoi(envOrDefault("SMTP_PORT", "587"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid SMTP_PORT: %v", err)
+	}
+
+	return &SMTPMailer{
+		Host:     host,
+		Port:     port,
+		Username: envOrDefault("SMTP_USERNAME", ""),
+		Password: envOrDefault("SMTP_PASSWORD", ""),
+	}, nil
+}
+
+func (s *SMTPMailer) Send(ctx context.Context, message Message) error {
+	body, err := BuildMIMEMessage(message, time.Now())
+	if err != nil {
+		return err
+	}
+
+	recipients := make([]string, 0, len(message.To))
+	for _, to := range message.To {
+		recipients = append(recipients, to.Email)
+	}
+
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, s.Host)
+	}
+
+	// net/smtp has no context support, so the send runs on its own and the caller stops waiting on cancellation
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(net.JoinHostPort(s.Host, strconv.Itoa(s.Port)), auth, message.From.Email, recipients, body)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// BuildMIMEMessage renders a message as a multipart/alternative email with its text and html bodies
+func BuildMIMEMessage(message Message, date time.Time) ([]byte, error) {
+	var buf bytes.Buffer
+
+	to := make([]string, 0, len(message.To))
+	for _, address := range message.To {
+		to = append(to, (&mail.Address{Name: address.Name, Address: address.Email}).String())
+	}
+
+	writer := multipart.NewWriter(&buf)
+	headers := []string{
+		"From: " + (&mail.Address{Name: message.From.Name, Address: message.From.Email}).String(),
+		"To: " + strings.Join(to, ", "),
+		"Subject: " + mime.QEncoding.Encode("utf-8", message.Subject),
+		"Date: " + date.Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: multipart/alternative; boundary=" + writer.Boundary(),
+	}
+	for _, header := range headers {
+		buf.WriteString(header + "\r\n")
+	}
+	buf.WriteString("\r\n")
+
+	parts := []struct {
+		contentType string
+		body        string
+	}{
+		{"text/plain", message.Text},
+		{"text/html", message.HTML},
+	}
+	for _, part := range parts {
+		if part.body == "" {
+			continue
+		}
+		w, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType + "; charset=utf-8"},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(w)
+		if _, err := qp.Write([]byte(part.body)); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,161 @@
+package config
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildMIMEMessage(t *testing.T) {
+	date := time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		message Message
+		// parts maps each expected content type to its decoded body
+		parts map[string]string
+	}{
+		{
+			name: "text and html",
+			message: Message{
+				From:    Address{Name: "Gambl", Email: "noreply@example.com"},
+				To:      []Address{{Name: "Ada", Email: "ada@example.com"}, {Email: "bob@example.com"}},
+				Subject: "Your code",
+				Text:    "Your verification code is: 482913",
+				HTML:    "<p>Your verification code is: <b>482913</b></p>",
+			},
+			parts: map[string]string{
+				"text/plain": "Your verification code is: 482913",
+				"text/html":  "<p>Your verification code is: <b>482913</b></p>",
+			},
+		},
+		{
+			name: "non-ASCII subject and body",
+			message: Message{
+				From:    Address{Name: "École Gambl", Email: "noreply@example.com"},
+				To:      []Address{{Name: "Zoé", Email: "zoe@example.com"}},
+				Subject: "Réinitialisez votre mot de passe — 密码",
+				Text:    "Votre code de vérification est : 482913",
+				HTML:    "<p>Votre code de vérification est : 482913</p>",
+			},
+			parts: map[string]string{
+				"text/plain": "Votre code de vérification est : 482913",
+				"text/html":  "<p>Votre code de vérification est : 482913</p>",
+			},
+		},
+		{
+			name: "text only",
+			message: Message{
+				From:    Address{Email: "noreply@example.com"},
+				To:      []Address{{Email: "ada@example.com"}},
+				Subject: "Plain",
+				Text:    strings.Repeat("a long line that quoted printable has to wrap ", 5),
+			},
+			parts: map[string]string{
+				"text/plain": strings.Repeat("a long line that quoted printable has to wrap ", 5),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			eml, err := BuildMIMEMessage(tt.message, date)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, line := range bytes.Split(eml, []byte("\r\n")) {
+				if len(line) > 998 {
+					t.Errorf("line of %d bytes exceeds the SMTP limit", len(line))
+				}
+			}
+
+			parsed, err := mail.ReadMessage(bytes.NewReader(eml))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			subject, err := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+			if err != nil || subject != tt.message.Subject {
+				t.Errorf("Subject = %q (%v), want %q", subject, err, tt.message.Subject)
+			}
+			if !isASCII(parsed.Header.Get("Subject")) {
+				t.Errorf("Subject header is not encoded: %q", parsed.Header.Get("Subject"))
+			}
+
+			from, err := parsed.Header.AddressList("From")
+			if err != nil || len(from) != 1 || from[0].Name != tt.message.From.Name || from[0].Address != tt.message.From.Email {
+				t.Errorf("From = %v (%v), want %+v", from, err, tt.message.From)
+			}
+			to, err := parsed.Header.AddressList("To")
+			if err != nil || len(to) != len(tt.message.To) {
+				t.Fatalf("To = %v (%v), want %+v", to, err, tt.message.To)
+			}
+			for i, address := range to {
+				if address.Name != tt.message.To[i].Name || address.Address != tt.message.To[i].Email {
+					t.Errorf("To[%d] = %v, want %+v", i, address, tt.message.To[i])
+				}
+			}
+			if got, err := parsed.Header.Date(); err != nil || !got.Equal(date) {
+				t.Errorf("Date = %v (%v), want %v", got, err, date)
+			}
+
+			mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+			if err != nil || mediaType != "multipart/alternative" {
+				t.Fatalf("Content-Type = %q (%v)", parsed.Header.Get("Content-Type"), err)
+			}
+
+			parts := map[string]string{}
+			reader := multipart.NewReader(parsed.Body, params["boundary"])
+			for {
+				part, err := reader.NextRawPart()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if part.Header.Get("Content-Transfer-Encoding") != "quoted-printable" {
+					t.Errorf("part %s is not quoted-printable", part.Header.Get("Content-Type"))
+				}
+				contentType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+				body, err := io.ReadAll(quotedprintable.NewReader(part))
+				if err != nil {
+					t.Fatal(err)
+				}
+				parts[contentType] = string(body)
+			}
+
+			if len(parts) != len(tt.parts) {
+				t.Errorf("got parts %v, want %v", keys(parts), keys(tt.parts))
+			}
+			for contentType, want := range tt.parts {
+				if parts[contentType] != want {
+					t.Errorf("%s part = %q, want %q", contentType, parts[contentType], want)
+				}
+			}
+		})
+	}
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+func keys(m map[string]string) []string {
+	list := make([]string, 0, len(m))
+	for key := range m {
+		list = append(list, key)
+	}
+	return list
+}
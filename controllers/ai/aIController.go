@@ -1,9 +1,14 @@
 package aIcontrollers
 
 import (
+	"context"
+	"errors"
+	"log"
 	"net/http"
 
 	config "gambl/config"
+	helper "gambl/helpers"
+	"gambl/middleware"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
@@ -13,6 +18,7 @@ import (
 
 var validateUser = validator.New()
 
+// OpenAiEndpoint answers the caller's prompt
 func OpenAiEndpoint() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var aImodel models.AIModel
@@ -28,12 +34,134 @@ func OpenAiEndpoint() gin.HandlerFunc {
 			return
 		}
 
-		result, err := config.AskOpenAI(aImodel.Prompt)
+		if err := helper.ReserveAIChatRequest(c, config.NewChatRequest(aImodel, config.PromptMessages(aImodel))); err != nil {
+			writeAIError(c, err)
+			return
+		}
+
+		// the provider call is cancelled with the request when the client goes away
+		result, err := config.AskAI(c.Request.Context(), aImodel)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			helper.RecordAIChat(c, "", aImodel.Prompt, result, chatStatus(err), false)
+			writeAIError(c, err)
 			return
 		}
+		helper.RecordAIChat(c, "", aImodel.Prompt, result, helper.AIChatCompleted, false)
 
 		c.JSON(http.StatusOK, result)
 	}
 }
+
+// StreamAiEndpoint answers the caller's prompt as server-sent events: a "delta" event per piece of the answer,
+// then "done" with the whole answer and its usage, or "error". Failures before the first piece are plain JSON
+// errors like /ai/chat. The answer is recorded even when the client disconnects halfway.
+func StreamAiEndpoint() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var aImodel models.AIModel
+
+		if err := c.BindJSON(&aImodel); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		validationErr := validateUser.Struct(aImodel)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		if err := helper.ReserveAIChatRequest(c, config.NewChatRequest(aImodel, config.PromptMessages(aImodel))); err != nil {
+			writeAIError(c, err)
+			return
+		}
+
+		result, err := relayStream(c, func(onDelta func(delta string) error) (models.AIChatResponse, error) {
+			return config.StreamAI(c.Request.Context(), aImodel, onDelta)
+		})
+		if err != nil {
+			helper.RecordAIChat(c, "", aImodel.Prompt, result, chatStatus(err), true)
+			return
+		}
+		helper.RecordAIChat(c, "", aImodel.Prompt, result, helper.AIChatCompleted, true)
+	}
+}
+
+// relayStream runs a streamed answer and relays its pieces to the client as "delta" events, then sends "done" with
+// the whole answer or reports the failure. It returns the answer, partial when there is an error.
+func relayStream(c *gin.Context, stream func(onDelta func(delta string) error) (models.AIChatResponse, error)) (models.AIChatResponse, error) {
+	started := false
+	result, err := stream(func(delta string) error {
+		if !started {
+			startEventStream(c)
+			started = true
+		}
+		c.SSEvent("delta", gin.H{"content": delta})
+		c.Writer.Flush()
+		// the write fails silently once the client is gone, the request context tells
+		return c.Request.Context().Err()
+	})
+
+	if err != nil {
+		if !started {
+			writeAIError(c, err)
+			return result, err
+		}
+		log.Printf("AI stream of %s stopped: %v", c.GetString("uid"), err)
+		if c.Request.Context().Err() == nil {
+			c.SSEvent("error", gin.H{"error": "the AI assistant could not finish the answer"})
+			c.Writer.Flush()
+		}
+		return result, err
+	}
+
+	if !started {
+		startEventStream(c)
+	}
+	c.SSEvent("done", result)
+	c.Writer.Flush()
+	return result, nil
+}
+
+func startEventStream(c *gin.Context) {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	// stops proxies such as nginx from buffering the events
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+}
+
+// chatStatus is the status an AI chat is recorded with after an error
+func chatStatus(err error) string {
+	if errors.Is(err, context.Canceled) {
+		return helper.AIChatCancelled
+	}
+	return helper.AIChatFailed
+}
+
+// writeAIError turns a provider failure into a response. Provider details are logged, not returned, except for
+// rejected prompts.
+func writeAIError(c *gin.Context, err error) {
+	log.Printf("AI request of %s failed: %v", c.GetString("uid"), err)
+
+	var providerErr *config.AIProviderError
+	var exceeded *helper.AIQuotaExceededError
+	switch {
+	case errors.As(err, &exceeded):
+		middleware.WriteAIQuotaError(c, err)
+	case errors.Is(err, config.ErrAINotConfigured), errors.Is(err, config.ErrAINoEmbeddings):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	case errors.Is(err, helper.ErrAIInvalidOutput):
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+	case errors.Is(err, context.DeadlineExceeded):
+		c.JSON(http.StatusGatewayTimeout, gin.H{"error": "the AI assistant took too long to answer"})
+	case errors.Is(err, context.Canceled):
+		c.Status(499)
+	case errors.As(err, &providerErr) && providerErr.StatusCode == http.StatusBadRequest:
+		c.JSON(http.StatusBadRequest, gin.H{"error": providerErr.Message})
+	case errors.As(err, &providerErr) && providerErr.StatusCode == http.StatusTooManyRequests:
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "the AI assistant is busy, please try again later"})
+	default:
+		c.JSON(http.StatusBadGateway, gin.H{"error": "the AI assistant could not answer"})
+	}
+}
@@ -0,0 +1,279 @@
+package aIcontrollers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	config "gambl/config"
+	helper "gambl/helpers"
+	"gambl/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// CreateConversation starts a conversation of the caller, with its own system prompt if given
+func CreateConversation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		var payload models.CreateAIConversation
+
+		if err := c.BindJSON(&payload); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		validationErr := validateUser.Struct(payload)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		now := time.Now().UTC()
+		conversation := models.AIConversation{
+			ID:            primitive.NewObjectID(),
+			User_id:       c.GetString("uid"),
+			School_id:     c.GetString("school_id"),
+			Branch_id:     c.GetString("branch_id"),
+			Title:         strings.TrimSpace(payload.Title),
+			System_prompt: strings.TrimSpace(payload.System_prompt),
+			Messages:      []models.AIConversationMessage{},
+			Created_at:    now,
+			Updated_at:    now,
+		}
+		conversation.Conversation_id = conversation.ID.Hex()
+
+		if err := helper.CreateConversation(ctx, conversation); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "conversation was not created"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, conversation)
+	}
+}
+
+// GetConversations lists the caller's conversations, most recent first
+func GetConversations() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		recordPerPage, err := strconv.Atoi(c.Query("recordPerPage"))
+		if err != nil || recordPerPage < 1 {
+			recordPerPage = 20
+		}
+
+		page, err1 := strconv.Atoi(c.Query("page"))
+		if err1 != nil || page < 1 {
+			page = 1
+		}
+
+		conversations, total, err := helper.ListConversations(ctx, c.GetString("uid"), (page-1)*recordPerPage, recordPerPage)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing conversations"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"total_count":        total,
+			"conversation_items": conversations,
+		})
+	}
+}
+
+// GetConversation returns a conversation of the caller with its messages
+func GetConversation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		conversation, err := helper.GetConversation(ctx, c.GetString("uid"), c.Param("conversation_id"))
+		if err != nil {
+			writeConversationError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, conversation)
+	}
+}
+
+// UpdateConversation renames a conversation or changes its system prompt
+func UpdateConversation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		var payload models.EditAIConversation
+
+		if err := c.BindJSON(&payload); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		validationErr := validateUser.Struct(payload)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		set := bson.M{}
+		if payload.Title != nil {
+			set["title"] = strings.TrimSpace(*payload.Title)
+		}
+		if payload.System_prompt != nil {
+			set["system_prompt"] = strings.TrimSpace(*payload.System_prompt)
+		}
+
+		if err := helper.UpdateConversation(ctx, c.GetString("uid"), c.Param("conversation_id"), set); err != nil {
+			writeConversationError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "conversation updated"})
+	}
+}
+
+// DeleteConversation deletes a conversation of the caller
+func DeleteConversation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		if err := helper.DeleteConversation(ctx, c.GetString("uid"), c.Param("conversation_id")); err != nil {
+			writeConversationError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "conversation deleted"})
+	}
+}
+
+// ContinueConversation sends a prompt in a conversation and saves it with the answer
+func ContinueConversation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		conversation, prompt, ok := prepareConversationPrompt(c)
+		if !ok {
+			return
+		}
+
+		messages := helper.ConversationContext(c.Request.Context(), helper.AIUsageOwnerFromContext(c), &conversation, prompt.Prompt)
+		if err := helper.ReserveAIChatRequest(c, config.NewChatRequest(prompt, messages)); err != nil {
+			writeAIError(c, err)
+			return
+		}
+		result, err := config.ChatAI(c.Request.Context(), prompt, messages)
+		if err != nil {
+			helper.RecordAIChat(c, conversation.Conversation_id, prompt.Prompt, result, chatStatus(err), false)
+			writeAIError(c, err)
+			return
+		}
+		helper.RecordAIChat(c, conversation.Conversation_id, prompt.Prompt, result, helper.AIChatCompleted, false)
+
+		if err := saveConversationTurn(conversation, prompt, result); err != nil {
+			writeConversationError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// StreamConversation is ContinueConversation with the answer streamed like /ai/chat/stream. The prompt and answer
+// are only saved when the answer completes.
+func StreamConversation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		conversation, prompt, ok := prepareConversationPrompt(c)
+		if !ok {
+			return
+		}
+
+		messages := helper.ConversationContext(c.Request.Context(), helper.AIUsageOwnerFromContext(c), &conversation, prompt.Prompt)
+		if err := helper.ReserveAIChatRequest(c, config.NewChatRequest(prompt, messages)); err != nil {
+			writeAIError(c, err)
+			return
+		}
+		result, err := relayStream(c, func(onDelta func(delta string) error) (models.AIChatResponse, error) {
+			return config.StreamChatAI(c.Request.Context(), prompt, messages, onDelta)
+		})
+		if err != nil {
+			helper.RecordAIChat(c, conversation.Conversation_id, prompt.Prompt, result, chatStatus(err), true)
+			return
+		}
+		helper.RecordAIChat(c, conversation.Conversation_id, prompt.Prompt, result, helper.AIChatCompleted, true)
+
+		if err := saveConversationTurn(conversation, prompt, result); err != nil {
+			log.Printf("Error saving conversation %s: %v", conversation.Conversation_id, err)
+		}
+	}
+}
+
+// prepareConversationPrompt reads the prompt and loads the caller's conversation, writing the error response when it cannot continue
+func prepareConversationPrompt(c *gin.Context) (models.AIConversation, models.AIModel, bool) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var prompt models.AIModel
+
+	if err := c.BindJSON(&prompt); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return models.AIConversation{}, prompt, false
+	}
+
+	validationErr := validateUser.Struct(prompt)
+	if validationErr != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+		return models.AIConversation{}, prompt, false
+	}
+
+	conversation, err := helper.GetConversation(ctx, c.GetString("uid"), c.Param("conversation_id"))
+	if err == nil {
+		err = helper.CheckConversationRoom(conversation)
+	}
+	if err != nil {
+		writeConversationError(c, err)
+		return conversation, prompt, false
+	}
+	return conversation, prompt, true
+}
+
+// saveConversationTurn appends the prompt and its answer, and titles an untitled conversation after its first prompt
+func saveConversationTurn(conversation models.AIConversation, prompt models.AIModel, result models.AIChatResponse) error {
+	var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	now := time.Now().UTC()
+	usage := result.Usage
+	err := helper.AppendConversationMessages(ctx, conversation,
+		models.AIConversationMessage{Role: "user", Content: prompt.Prompt, Created_at: now},
+		models.AIConversationMessage{Role: "assistant", Content: result.Content, Usage: &usage, Created_at: now},
+	)
+	if err != nil {
+		return err
+	}
+
+	if conversation.Title == "" {
+		title := strings.TrimSpace(prompt.Prompt)
+		if runes := []rune(title); len(runes) > 60 {
+			title = string(runes[:60]) + "..."
+		}
+		if err := helper.UpdateConversation(ctx, conversation.User_id, conversation.Conversation_id, bson.M{"title": title}); err != nil {
+			log.Printf("Error titling conversation %s: %v", conversation.Conversation_id, err)
+		}
+	}
+	return nil
+}
+
+func writeConversationError(c *gin.Context, err error) {
+	switch err {
+	case helper.ErrConversationNotFound:
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case helper.ErrConversationFull:
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while saving the conversation"})
+	}
+}
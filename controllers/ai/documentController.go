@@ -0,0 +1,267 @@
+package aIcontrollers
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	config "gambl/config"
+	helper "gambl/helpers"
+	"gambl/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// UploadDocument reads a PDF, text or markdown document sent as the "file" form field, splits it into passages and
+// embeds them so the AI assistant can answer from it in the caller's branch. A "title" field names it in citations.
+func UploadDocument() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		branchId := c.GetString("branch_id")
+		if branchId == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "X-Branch-Id header is required"})
+			return
+		}
+		settings := helper.AIDocumentSettingsFromEnv()
+
+		fileHeader, err := c.FormFile("file")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "file is required"})
+			return
+		}
+		if fileHeader.Size > settings.MaxBytes {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "document is larger than " + strconv.FormatInt(settings.MaxBytes>>20, 10) + " MB"})
+			return
+		}
+
+		file, err := fileHeader.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "file could not be read"})
+			return
+		}
+		content, err := io.ReadAll(io.LimitReader(file, settings.MaxBytes))
+		file.Close()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "file could not be read"})
+			return
+		}
+
+		documentType, err := helper.DocumentType(fileHeader.Filename, content)
+		if err != nil {
+			c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": err.Error()})
+			return
+		}
+		text, err := helper.ExtractDocumentText(documentType, content)
+		if err != nil {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		}
+
+		chunks := helper.ChunkText(text, settings.ChunkTokens, settings.OverlapTokens)
+		if len(chunks) > settings.MaxChunks {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "document is too long, split it into smaller documents"})
+			return
+		}
+
+		title := strings.TrimSpace(c.PostForm("title"))
+		if title == "" {
+			title = strings.TrimSuffix(filepath.Base(fileHeader.Filename), filepath.Ext(fileHeader.Filename))
+		}
+		title = helper.FirstBytes(title, 200)
+
+		var tokens int
+		for _, chunk := range chunks {
+			tokens += helper.EstimateTokens(chunk)
+		}
+		if err := helper.ReserveAIRequest(c, tokens); err != nil {
+			writeAIError(c, err)
+			return
+		}
+
+		embeddings, err := config.EmbedTexts(c.Request.Context(), chunks)
+		recordEmbeddingUsage(c, embeddings.Usage)
+		if err != nil {
+			writeAIError(c, err)
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		document := models.AIDocument{
+			ID:              primitive.NewObjectID(),
+			School_id:       c.GetString("school_id"),
+			Branch_id:       branchId,
+			Title:           title,
+			Filename:        filepath.Base(fileHeader.Filename),
+			Content_type:    documentType,
+			Size:            int64(len(content)),
+			Chunk_count:     len(chunks),
+			Embedding_model: embeddings.Model,
+			Usage:           embeddings.Usage,
+			Uploaded_by:     c.GetString("uid"),
+			Created_at:      time.Now().UTC(),
+		}
+		document.Document_id = document.ID.Hex()
+
+		if err := helper.SaveDocument(ctx, document, chunks, embeddings.Vectors); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "document was not saved"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, document)
+	}
+}
+
+// GetDocuments lists the documents of the caller's branch
+func GetDocuments() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		branchId := c.GetString("branch_id")
+		if branchId == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "X-Branch-Id header is required"})
+			return
+		}
+
+		recordPerPage, err := strconv.Atoi(c.Query("recordPerPage"))
+		if err != nil || recordPerPage < 1 {
+			recordPerPage = 20
+		}
+
+		page, err1 := strconv.Atoi(c.Query("page"))
+		if err1 != nil || page < 1 {
+			page = 1
+		}
+
+		documents, total, err := helper.ListDocuments(ctx, branchId, (page-1)*recordPerPage, recordPerPage)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing documents"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"total_count":    total,
+			"document_items": documents,
+		})
+	}
+}
+
+// GetDocument returns a document of the caller's branch
+func GetDocument() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		document, err := helper.GetDocument(ctx, c.GetString("branch_id"), c.Param("document_id"))
+		if err != nil {
+			writeDocumentError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, document)
+	}
+}
+
+// DeleteDocument removes a document of the caller's branch, the AI assistant no longer answers from it
+func DeleteDocument() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		if err := helper.DeleteDocument(ctx, c.GetString("branch_id"), c.Param("document_id")); err != nil {
+			writeDocumentError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "document deleted"})
+	}
+}
+
+// AskDocuments answers a question from the documents of the caller's branch. The answer cites the passages it was
+// given with [n] markers, which are returned as its citations.
+func AskDocuments() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ask models.AIAsk
+
+		if err := c.BindJSON(&ask); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		validationErr := validateUser.Struct(ask)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		branchId := c.GetString("branch_id")
+		if branchId == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "X-Branch-Id header is required"})
+			return
+		}
+
+		topK := helper.AIDocumentSettingsFromEnv().TopK
+		if ask.Top_k != nil {
+			topK = *ask.Top_k
+		}
+
+		query, err := config.EmbedTexts(c.Request.Context(), []string{ask.Question})
+		recordEmbeddingUsage(c, query.Usage)
+		if err != nil {
+			writeAIError(c, err)
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		citations, err := helper.SearchDocumentChunks(ctx, branchId, query.Model, query.Vectors[0], topK)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while searching the documents"})
+			return
+		}
+		if len(citations) == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "no documents have been uploaded to this branch"})
+			return
+		}
+
+		// the query embedding settled the reservation of the route, the answer gets its own
+		prompt, messages := models.AIModel{Prompt: ask.Question}, helper.AskMessages(ask.Question, citations)
+		if err := helper.ReserveAIChatRequest(c, config.NewChatRequest(prompt, messages)); err != nil {
+			writeAIError(c, err)
+			return
+		}
+		result, err := config.ChatAI(c.Request.Context(), prompt, messages)
+		if err != nil {
+			helper.RecordAIChat(c, "", ask.Question, result, chatStatus(err), false)
+			writeAIError(c, err)
+			return
+		}
+		helper.RecordAIChat(c, "", ask.Question, result, helper.AIChatCompleted, false)
+
+		c.JSON(http.StatusOK, models.AIAnswer{AIChatResponse: result, Citations: citations})
+	}
+}
+
+// recordEmbeddingUsage settles the caller's reservation with the tokens of an embedding request
+func recordEmbeddingUsage(c *gin.Context, usage models.AIUsage) {
+	if usage.Total_tokens == 0 {
+		helper.ReleaseAIRequest(c)
+		return
+	}
+	helper.RecordAIRequestUsage(c, usage)
+}
+
+func writeDocumentError(c *gin.Context, err error) {
+	if err == helper.ErrDocumentNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while reading the document"})
+}
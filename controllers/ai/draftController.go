@@ -0,0 +1,291 @@
+package aIcontrollers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	config "gambl/config"
+	helper "gambl/helpers"
+	"gambl/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// GenerateLessonPlan asks the AI assistant for a lesson plan and saves it as a draft of the caller
+func GenerateLessonPlan() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !canAuthorContent(c) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "only teachers and admins can generate teaching content"})
+			return
+		}
+		var payload models.GenerateLessonPlan
+
+		if err := c.BindJSON(&payload); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		validationErr := validateUser.Struct(payload)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		var plan models.LessonPlan
+		result, err := generateStructured(c, helper.LessonPlanMessages(payload), helper.LessonPlanSchema(), func(content string) (err error) {
+			plan, err = helper.ParseLessonPlan(content)
+			return err
+		})
+		if err != nil {
+			writeAIError(c, err)
+			return
+		}
+
+		draft := newDraft(c, helper.LessonPlanDraft, payload.Subject, payload.Class, payload.Topic, plan.Title, result)
+		draft.Lesson_plan = &plan
+		saveDraft(c, draft)
+	}
+}
+
+// GenerateQuiz asks the AI assistant for a multiple-choice quiz and saves it as a draft of the caller
+func GenerateQuiz() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !canAuthorContent(c) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "only teachers and admins can generate teaching content"})
+			return
+		}
+		var payload models.GenerateQuiz
+
+		if err := c.BindJSON(&payload); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		validationErr := validateUser.Struct(payload)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		count := helper.QuizQuestionCount(payload)
+		var quiz models.Quiz
+		result, err := generateStructured(c, helper.QuizMessages(payload), helper.QuizSchema(count), func(content string) (err error) {
+			quiz, err = helper.ParseQuiz(content, count)
+			return err
+		})
+		if err != nil {
+			writeAIError(c, err)
+			return
+		}
+
+		draft := newDraft(c, helper.QuizDraft, payload.Subject, payload.Class, payload.Topic, quiz.Title, result)
+		draft.Quiz = &quiz
+		saveDraft(c, draft)
+	}
+}
+
+// GetDrafts lists the caller's drafts, of one kind with kind=LESSON_PLAN or QUIZ
+func GetDrafts() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		kind := strings.ToUpper(c.Query("kind"))
+		if kind != "" && kind != helper.LessonPlanDraft && kind != helper.QuizDraft {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "kind must be LESSON_PLAN or QUIZ"})
+			return
+		}
+
+		recordPerPage, err := strconv.Atoi(c.Query("recordPerPage"))
+		if err != nil || recordPerPage < 1 {
+			recordPerPage = 20
+		}
+
+		page, err1 := strconv.Atoi(c.Query("page"))
+		if err1 != nil || page < 1 {
+			page = 1
+		}
+
+		drafts, total, err := helper.ListDrafts(ctx, c.GetString("uid"), kind, (page-1)*recordPerPage, recordPerPage)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing drafts"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"total_count": total,
+			"draft_items": drafts,
+		})
+	}
+}
+
+// GetDraft returns a draft of the caller with its content
+func GetDraft() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		draft, err := helper.GetDraft(ctx, c.GetString("uid"), c.Param("draft_id"))
+		if err != nil {
+			writeDraftError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, draft)
+	}
+}
+
+// UpdateDraft replaces the content of a draft of the caller with the teacher's edits
+func UpdateDraft() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		var payload models.EditAIDraft
+
+		if err := c.BindJSON(&payload); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		draft, err := helper.GetDraft(ctx, c.GetString("uid"), c.Param("draft_id"))
+		if err != nil {
+			writeDraftError(c, err)
+			return
+		}
+
+		set := bson.M{}
+		switch {
+		case draft.Kind == helper.LessonPlanDraft && payload.Lesson_plan != nil:
+			err = helper.ValidateDraftContent(payload.Lesson_plan)
+			set["lesson_plan"] = payload.Lesson_plan
+			set["title"] = payload.Lesson_plan.Title
+		case draft.Kind == helper.QuizDraft && payload.Quiz != nil:
+			err = helper.ValidateDraftContent(payload.Quiz)
+			set["quiz"] = payload.Quiz
+			set["title"] = payload.Quiz.Title
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "send the lesson_plan or quiz matching the draft kind " + draft.Kind})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := helper.UpdateDraft(ctx, draft.Owner_id, draft.Draft_id, set); err != nil {
+			writeDraftError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "draft updated"})
+	}
+}
+
+// DeleteDraft deletes a draft of the caller
+func DeleteDraft() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		if err := helper.DeleteDraft(ctx, c.GetString("uid"), c.Param("draft_id")); err != nil {
+			writeDraftError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "draft deleted"})
+	}
+}
+
+// generateStructured asks for a document matching the schema. When parse rejects the answer, the model is shown
+// the problem and asked again, up to AI_STRUCTURED_MAX_ATTEMPTS times. Every attempt reserves its messages and max_tokens on
+// the quotas and counts towards the usage. The answer is returned with the usage of all the attempts.
+func generateStructured(c *gin.Context, messages []models.AIMessage, schema config.JSONSchema, parse func(content string) error) (models.AIChatResponse, error) {
+	prompt := messages[len(messages)-1].Content
+
+	var usage models.AIUsage
+	for attempt := 1; attempt <= helper.AIStructuredAttempts(); attempt++ {
+		request := config.NewJSONChatRequest(messages, schema)
+		if err := helper.ReserveAIChatRequest(c, request); err != nil {
+			return models.AIChatResponse{}, err
+		}
+
+		result, err := config.SendChatRequest(c.Request.Context(), request)
+		if err != nil {
+			helper.RecordAIChat(c, "", prompt, result, chatStatus(err), false)
+			return result, err
+		}
+		usage.Prompt_tokens += result.Usage.Prompt_tokens
+		usage.Completion_tokens += result.Usage.Completion_tokens
+		usage.Total_tokens += result.Usage.Total_tokens
+
+		parseErr := parse(result.Content)
+		if parseErr == nil {
+			helper.RecordAIChat(c, "", prompt, result, helper.AIChatCompleted, false)
+			result.Usage = usage
+			return result, nil
+		}
+		helper.RecordAIChat(c, "", prompt, result, helper.AIChatInvalid, false)
+
+		messages = append(messages,
+			models.AIMessage{Role: "assistant", Content: result.Content},
+			models.AIMessage{Role: "user", Content: "That answer is not valid: " + parseErr.Error() + ". Reply again with only the corrected JSON document."},
+		)
+	}
+	return models.AIChatResponse{}, helper.ErrAIInvalidOutput
+}
+
+func newDraft(c *gin.Context, kind string, subject string, class string, topic string, title string, result models.AIChatResponse) models.AIDraft {
+	now := time.Now().UTC()
+	draft := models.AIDraft{
+		ID:         primitive.NewObjectID(),
+		Kind:       kind,
+		Owner_id:   c.GetString("uid"),
+		School_id:  c.GetString("school_id"),
+		Branch_id:  c.GetString("branch_id"),
+		Subject:    subject,
+		Class:      class,
+		Topic:      topic,
+		Title:      title,
+		Status:     helper.DraftStatus,
+		Model:      result.Model,
+		Usage:      result.Usage,
+		Created_at: now,
+		Updated_at: now,
+	}
+	draft.Draft_id = draft.ID.Hex()
+	return draft
+}
+
+func saveDraft(c *gin.Context, draft models.AIDraft) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := helper.CreateDraft(ctx, draft); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "draft was not saved"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, draft)
+}
+
+// canAuthorContent reports whether the caller may generate teaching content
+func canAuthorContent(c *gin.Context) bool {
+	switch c.GetString("user_type") {
+	case helper.TeacherUserType, helper.AdminUserType:
+		return true
+	}
+	return false
+}
+
+func writeDraftError(c *gin.Context, err error) {
+	if err == helper.ErrDraftNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while saving the draft"})
+}
@@ -0,0 +1,77 @@
+package aIcontrollers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	helper "gambl/helpers"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetMyAIUsage returns the caller's AI usage, and their branch's, in the current day and month against the quotas
+func GetMyAIUsage() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		statuses, err := helper.AIQuotaStatuses(ctx, helper.AIUsageOwnerFromContext(c))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while reading the AI usage"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"usage": statuses})
+	}
+}
+
+// GetAIUsageReport lists the users of the caller's school by AI tokens used in a day or month, with their branch
+// totals. period is day (the default) or month, date a YYYY-MM-DD day inside the period, today by default.
+func GetAIUsageReport() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		period := c.DefaultQuery("period", helper.AIUsageDay)
+		if period != helper.AIUsageDay && period != helper.AIUsageMonth {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "period must be day or month"})
+			return
+		}
+
+		date := time.Now().UTC()
+		if value := c.Query("date"); value != "" {
+			var err error
+			if date, err = time.Parse("2006-01-02", value); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "date must be a YYYY-MM-DD day"})
+				return
+			}
+		}
+		start := helper.AIPeriodStart(date, period)
+
+		recordPerPage, err := strconv.Atoi(c.Query("recordPerPage"))
+		if err != nil || recordPerPage < 1 {
+			recordPerPage = 50
+		}
+
+		page, err1 := strconv.Atoi(c.Query("page"))
+		if err1 != nil || page < 1 {
+			page = 1
+		}
+
+		users, total, branches, err := helper.AIUsageReport(ctx, c.GetString("school_id"), period, start, (page-1)*recordPerPage, recordPerPage)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while building the AI usage report"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"period":       period,
+			"period_start": start,
+			"total_count":  total,
+			"user_items":   users,
+			"branch_items": branches,
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gambl/config"
+	helper "gambl/helpers"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetOutboxEmails lists outbox emails by status, DEAD by default. The outbox holds every school's emails so only a super admin can read it.
+func GetOutboxEmails() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, helper.SuperAdminUserType); err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		status := strings.ToUpper(c.DefaultQuery("status", config.EmailDead))
+		switch status {
+		case config.EmailPending, config.EmailSending, config.EmailSent, config.EmailDead:
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "status must be PENDING, SENDING, SENT or DEAD"})
+			return
+		}
+
+		recordPerPage, err := strconv.Atoi(c.Query("recordPerPage"))
+		if err != nil || recordPerPage < 1 {
+			recordPerPage = 20
+		}
+
+		page, err1 := strconv.Atoi(c.Query("page"))
+		if err1 != nil || page < 1 {
+			page = 1
+		}
+
+		emails, total, err := config.ListOutboxEmails(ctx, status, (page-1)*recordPerPage, recordPerPage)
+		if err != nil {
+			log.Printf("Error listing the email outbox: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing emails"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"total_count": total,
+			"email_items": emails,
+		})
+	}
+}
+
+// GetOutboxEmail returns one outbox email with its attempts and last error
+func GetOutboxEmail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, helper.SuperAdminUserType); err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		email, err := config.GetOutboxEmail(ctx, c.Param("message_id"))
+		if err == config.ErrOutboxEmailNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while fetching the email"})
+			return
+		}
+
+		c.JSON(http.StatusOK, email)
+	}
+}
+
+// ReplayOutboxEmail queues a dead email again
+func ReplayOutboxEmail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, helper.SuperAdminUserType); err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		replayed, err := config.ReplayOutboxEmails(ctx, c.Param("message_id"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "email was not replayed"})
+			return
+		}
+		if replayed == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "no dead email with this id"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "email queued again"})
+	}
+}
+
+// ReplayOutboxEmails queues every dead email again
+func ReplayOutboxEmails() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.CheckUserType(c, helper.SuperAdminUserType); err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		replayed, err := config.ReplayOutboxEmails(ctx, "")
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "emails were not replayed"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"replayed_count": replayed})
+	}
+}
+
+// GetEmailTemplates lists the email templates and the locales they can be rendered in
+func GetEmailTemplates() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !helper.IsSuperAdmin(c) && c.GetString("user_type") != helper.AdminUserType {
+			c.JSON(http.StatusForbidden, gin.H{"error": "unauthorized to access this resource"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"templates":      config.EmailTemplateNames(),
+			"locales":        config.EmailLocales(),
+			"default_locale": config.DefaultEmailLocale,
+		})
+	}
+}
+
+// PreviewEmailTemplate renders a template with sample data in the locale query, or the Accept-Language header.
+// format=html returns the html page itself, format=text the plain text.
+func PreviewEmailTemplate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !helper.IsSuperAdmin(c) && c.GetString("user_type") != helper.AdminUserType {
+			c.JSON(http.StatusForbidden, gin.H{"error": "unauthorized to access this resource"})
+			return
+		}
+
+		name := c.Param("name")
+		locale := c.Query("locale")
+		if locale == "" {
+			locale = c.GetHeader("Accept-Language")
+		}
+
+		rendered, err := config.RenderEmail(name, locale, config.EmailPreviewData(name))
+		if err == config.ErrUnknownEmailTemplate {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			log.Printf("Error rendering the %s email: %v", name, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "email template could not be rendered"})
+			return
+		}
+
+		switch c.Query("format") {
+		case "html":
+			c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(rendered.HTML))
+		case "text":
+			c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(rendered.Text))
+		default:
+			c.JSON(http.StatusOK, rendered)
+		}
+	}
+}
@@ -0,0 +1,17 @@
+package controllers
+
+import (
+	"net/http"
+
+	helper "gambl/helpers"
+
+	"github.com/gin-gonic/gin"
+)
+
+// JWKS publishes the public keys other services use to verify gambl tokens
+func JWKS() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Cache-Control", "public, max-age=300")
+		c.JSON(http.StatusOK, helper.JWKS())
+	}
+}
@@ -0,0 +1,310 @@
+package controllers
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"time"
+
+	helper "gambl/helpers"
+	"gambl/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skip2/go-qrcode"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// LoginMfa completes a login with the mfa pending token and a TOTP or recovery code
+func LoginMfa() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		var payload models.MfaLogin
+		var foundUser models.User
+
+		if err := c.BindJSON(&payload); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		validationErr := validateUser.Struct(payload)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		claims, msg := helper.ValidateToken(*payload.Mfa_token)
+		if msg != "" || claims.Token_type != helper.MfaPendingTokenType {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "mfa token is invalid or expired, please login again"})
+			return
+		}
+
+		err := userCollection.FindOne(ctx, bson.M{"user_id": claims.Uid}).Decode(&foundUser)
+		if err != nil || foundUser.Email == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "user doesnt exist"})
+			return
+		}
+
+		// wrong second factors count towards the same lockout as wrong passwords
+		reservation, err := helper.ReserveLoginAttempt(ctx, *foundUser.Email, c.ClientIP())
+		if err != nil {
+			writeLoginThrottledError(c, err)
+			return
+		}
+		defer reservation.Release(ctx)
+
+		var code, recoveryCode string
+		if payload.Code != nil {
+			code = *payload.Code
+		}
+		if payload.Recovery_code != nil {
+			recoveryCode = *payload.Recovery_code
+		}
+
+		if err := helper.VerifyMfa(ctx, foundUser.User_id, code, recoveryCode); err != nil {
+			if err == helper.ErrMfaInvalidCode {
+				recordLoginFailure(c, ctx, *foundUser.Email)
+			}
+			writeMfaError(c, err)
+			return
+		}
+
+		// the pending token is single use
+		if err := helper.RevokeToken(ctx, claims.Id, claims.Uid, time.Unix(claims.ExpiresAt, 0)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "couldnt generate token"})
+			return
+		}
+
+		response, err := issueLoginTokens(c, ctx, foundUser)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "couldnt generate token"})
+			return
+		}
+
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// EnrollMfa generates a new TOTP secret and returns it with its otpauth:// uri and QR code
+func EnrollMfa() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		secret, err := helper.StartMfaEnrollment(ctx, c.GetString("uid"))
+		if err != nil {
+			writeMfaError(c, err)
+			return
+		}
+
+		uri := helper.TOTPURI(helper.MfaIssuer(), c.GetString("email"), secret)
+		png, err := qrcode.Encode(uri, qrcode.Medium, 256)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "could not generate QR code"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"secret":      secret,
+			"otpauth_uri": uri,
+			"qr_png":      "data:image/png;base64," + base64.StdEncoding.EncodeToString(png),
+		})
+	}
+}
+
+// MfaQRCode serves the QR code of a pending enrollment as a PNG image
+func MfaQRCode() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		factor, found, err := helper.GetMfaFactor(ctx, c.GetString("uid"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		// the secret of an enabled factor is never shown again
+		if !found || factor.Enabled {
+			writeMfaError(c, helper.ErrMfaNoPendingFactor)
+			return
+		}
+
+		png, err := qrcode.Encode(helper.TOTPURI(helper.MfaIssuer(), c.GetString("email"), factor.Secret), qrcode.Medium, 256)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "could not generate QR code"})
+			return
+		}
+
+		c.Header("Cache-Control", "no-store")
+		c.Data(http.StatusOK, "image/png", png)
+	}
+}
+
+// EnableMfa confirms the enrollment with a first code and returns the recovery codes.
+// When called with an mfa pending token it also completes the login.
+func EnableMfa() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		var payload models.MfaCode
+
+		if err := c.BindJSON(&payload); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		validationErr := validateUser.Struct(payload)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		uid := c.GetString("uid")
+
+		recoveryCodes, err := helper.EnableMfa(ctx, uid, *payload.Code)
+		if err != nil {
+			writeMfaError(c, err)
+			return
+		}
+
+		if c.GetString("token_type") != helper.MfaPendingTokenType {
+			c.JSON(http.StatusOK, gin.H{
+				"success":        true,
+				"recovery_codes": recoveryCodes,
+			})
+			return
+		}
+
+		var foundUser models.User
+		err = userCollection.FindOne(ctx, bson.M{"user_id": uid}).Decode(&foundUser)
+		if err != nil || foundUser.Email == nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "user doesnt exist"})
+			return
+		}
+
+		if err := helper.RevokeToken(ctx, c.GetString("jti"), uid, time.Unix(c.GetInt64("token_expires_at"), 0)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "couldnt generate token"})
+			return
+		}
+
+		response, err := issueLoginTokens(c, ctx, foundUser)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "couldnt generate token"})
+			return
+		}
+		response["recovery_codes"] = recoveryCodes
+
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// DisableMfa removes the second factor, unless the MFA policy requires one for the account
+func DisableMfa() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("token_type") != helper.AccessTokenType {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "please complete the login first"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		var payload models.MfaCode
+		var user models.User
+
+		if err := c.BindJSON(&payload); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		validationErr := validateUser.Struct(payload)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		uid := c.GetString("uid")
+
+		err := userCollection.FindOne(ctx, bson.M{"user_id": uid}).Decode(&user)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "user doesnt exist"})
+			return
+		}
+
+		if helper.MfaRequired(user) {
+			writeMfaError(c, helper.ErrMfaRequiredByRole)
+			return
+		}
+
+		if err := helper.VerifyMfa(ctx, uid, *payload.Code, ""); err != nil {
+			writeMfaError(c, err)
+			return
+		}
+
+		if err := helper.DisableMfa(ctx, uid); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "two-factor authentication was not disabled"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"msg":     "two-factor authentication disabled",
+		})
+	}
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes after checking a current TOTP code
+func RegenerateRecoveryCodes() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("token_type") != helper.AccessTokenType {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "please complete the login first"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		var payload models.MfaCode
+
+		if err := c.BindJSON(&payload); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		validationErr := validateUser.Struct(payload)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		uid := c.GetString("uid")
+
+		if err := helper.VerifyMfa(ctx, uid, *payload.Code, ""); err != nil {
+			writeMfaError(c, err)
+			return
+		}
+
+		recoveryCodes, err := helper.RegenerateRecoveryCodes(ctx, uid)
+		if err != nil {
+			writeMfaError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"success":        true,
+			"recovery_codes": recoveryCodes,
+		})
+	}
+}
+
+func writeMfaError(c *gin.Context, err error) {
+	switch err {
+	case helper.ErrMfaInvalidCode:
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case helper.ErrMfaNotEnrolled, helper.ErrMfaNoPendingFactor, helper.ErrMfaAlreadyEnabled:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case helper.ErrMfaRequiredByRole:
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not process two-factor authentication"})
+	}
+}
@@ -0,0 +1,287 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	config "gambl/config"
+	helper "gambl/helpers"
+	"gambl/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var (
+	errMissingBranchForRoles = errors.New("roles can only be assigned once you belong to a school")
+	errUnknownRoles          = errors.New("some roles do not exist in this branch")
+)
+
+// OnboardUser completes the profile of an account. An UNBOARDED account takes the user type, roles, school and branch
+// of the invite sent to its email by a school's admin, the admin who created a school completes their own profile and
+// stays ADMIN. It generates a staff id unless the account already has one, notifies the admin team and returns tokens
+// carrying the new user type.
+func OnboardUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		var payload models.ValidateUser
+		var user models.User
+
+		if err := c.BindJSON(&payload); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		validationErr := validateUser.Struct(payload)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		uid := c.GetString("uid")
+
+		err := userCollection.FindOne(ctx, bson.M{"user_id": uid}).Decode(&user)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user doesnt exist"})
+			return
+		}
+
+		if !user.OtpVerified {
+			c.JSON(http.StatusForbidden, gin.H{"error": "please verify your email first"})
+			return
+		}
+
+		// only one onboarding can win if the request is sent twice
+		var filter bson.M
+		var invite models.StaffInvite
+		switch {
+		case user.User_type != nil && *user.User_type == helper.UnboardedUserType:
+			invite, err = helper.PendingStaffInvite(ctx, *user.Email)
+			if err == helper.ErrStaffInviteNotFound {
+				c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+				return
+			}
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while reading the invite"})
+				return
+			}
+			// roles may have been deleted since the invite was sent
+			if err := checkRolesExist(ctx, invite.Branch_id, invite.Role); err != nil {
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+				return
+			}
+
+			filter = bson.M{"user_id": uid, "user_type": helper.UnboardedUserType, "school_id": bson.M{"$in": []interface{}{nil, ""}}}
+			user.User_type = &invite.User_type
+			user.Role = invite.Role
+			user.School_id = invite.School_id
+			user.Branch_id = invite.Branch_id
+		case user.User_type != nil && *user.User_type == helper.AdminUserType && user.School_id != "" && user.Status != "ACTIVE":
+			filter = bson.M{"user_id": uid, "user_type": helper.AdminUserType, "status": bson.M{"$ne": "ACTIVE"}}
+			user.Role = []string{}
+		default:
+			c.JSON(http.StatusConflict, gin.H{"error": "account is already onboarded"})
+			return
+		}
+
+		classesHandled := []string{}
+		if payload.ClassesHandled != nil {
+			classesHandled = *payload.ClassesHandled
+		}
+
+		staffId := user.Staff_id
+		if staffId == "" {
+			staffId, err = helper.GenerateStaffID(ctx, user.School_id)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "could not generate a staff id"})
+				return
+			}
+		}
+
+		payload.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+		update := bson.D{{Key: "$set", Value: bson.D{
+			{Key: "first_name", Value: payload.First_name},
+			{Key: "last_name", Value: payload.Last_name},
+			{Key: "phone", Value: *payload.Phone},
+			{Key: "address", Value: payload.Address},
+			{Key: "role", Value: user.Role},
+			{Key: "classeshandled", Value: classesHandled},
+			{Key: "staff_id", Value: staffId},
+			{Key: "user_type", Value: user.User_type},
+			{Key: "school_id", Value: user.School_id},
+			{Key: "branch_id", Value: user.Branch_id},
+			{Key: "status", Value: "ACTIVE"},
+			{Key: "updated_at", Value: payload.Updated_at},
+		}}}
+
+		result, err := userCollection.UpdateOne(ctx, filter, update)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "user was not onboarded"})
+			return
+		}
+		if result.ModifiedCount == 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "account is already onboarded"})
+			return
+		}
+
+		if invite.Invite_id != "" {
+			if err := helper.AcceptStaffInvite(ctx, invite.Invite_id, uid); err != nil {
+				log.Println(err)
+			}
+		}
+
+		user.First_name = payload.First_name
+		user.Last_name = payload.Last_name
+		user.Phone = *payload.Phone
+		user.Address = payload.Address
+		user.ClassesHandled = classesHandled
+		user.Staff_id = staffId
+		user.Status = "ACTIVE"
+		user.Updated_at = payload.Updated_at
+
+		token, refreshToken, err := helper.IssueTokens(ctx, helper.SubjectFromUser(user), helper.DeviceID(c))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "couldnt generate token"})
+			return
+		}
+
+		advanceReferral(ctx, uid, helper.ReferralJoined, helper.ReferralOnboarded)
+
+		config.SendUserDetails(user)
+
+		user.Password = nil
+		c.JSON(http.StatusOK, gin.H{
+			"user":          user,
+			"jwt_token":     token,
+			"refresh_token": refreshToken,
+			"device_id":     helper.DeviceID(c),
+		})
+	}
+}
+
+// checkRolesExist makes sure every role is defined in the branch
+func checkRolesExist(ctx context.Context, branchId string, roles []string) error {
+	if len(roles) == 0 {
+		return nil
+	}
+	if branchId == "" {
+		return errMissingBranchForRoles
+	}
+
+	unique := map[string]bool{}
+	for _, role := range roles {
+		unique[role] = true
+	}
+
+	count, err := rolesCollection.CountDocuments(ctx, bson.M{"branch_id": branchId, "role_name": bson.M{"$in": roles}})
+	if err != nil {
+		return err
+	}
+	if int(count) != len(unique) {
+		return errUnknownRoles
+	}
+	return nil
+}
+
+// GetOnboardingStatus returns the caller's progress through the onboarding steps
+func GetOnboardingStatus() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		status, found, err := helper.GetOnboardingStatus(ctx, c.GetString("uid"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while reading the onboarding status"})
+			return
+		}
+
+		c.JSON(http.StatusOK, onboardingResponse(c, status, found))
+	}
+}
+
+// CompleteOnboardingStep marks a step done for steps that are not completed by a domain endpoint of this service,
+// the others answer 409. Required steps must be completed in order.
+func CompleteOnboardingStep() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		uid := c.GetString("uid")
+		step := c.Param("step")
+
+		status, found, err := helper.GetOnboardingStatus(ctx, uid)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while reading the onboarding status"})
+			return
+		}
+		if !found {
+			c.JSON(http.StatusNotFound, gin.H{"error": "onboarding is not tracked for this account"})
+			return
+		}
+
+		if err := helper.CheckOnboardingOrder(status, step); err != nil {
+			writeOnboardingError(c, err)
+			return
+		}
+
+		if err := helper.MarkOnboardingStep(ctx, uid, step); err != nil {
+			writeOnboardingError(c, err)
+			return
+		}
+
+		status, _, err = helper.GetOnboardingStatus(ctx, uid)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while reading the onboarding status"})
+			return
+		}
+
+		c.JSON(http.StatusOK, onboardingResponse(c, status, true))
+	}
+}
+
+func onboardingResponse(c *gin.Context, status models.OnboardedUserStatus, found bool) gin.H {
+	steps := []gin.H{}
+	for _, step := range helper.OnboardingSteps() {
+		steps = append(steps, gin.H{"name": step, "completed": !found || helper.OnboardingStepCompleted(status, step)})
+	}
+
+	next := ""
+	if found {
+		next = helper.NextOnboardingStep(status)
+	}
+
+	return gin.H{
+		"tracked":   found,
+		"required":  helper.OnboardingRequired(c.GetString("user_type")),
+		"completed": next == "",
+		"next_step": next,
+		"steps":     steps,
+		"status":    status,
+	}
+}
+
+// markOnboardingStep records a step done by a domain endpoint, a failure does not fail the request
+func markOnboardingStep(ctx context.Context, uid string, step string) {
+	if err := helper.MarkOnboardingStep(ctx, uid, step); err != nil {
+		log.Println(err)
+	}
+}
+
+func writeOnboardingError(c *gin.Context, err error) {
+	var orderErr *helper.OnboardingOrderError
+	switch {
+	case errors.As(err, &orderErr):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "next_step": orderErr.Next})
+	case err == helper.ErrOnboardingStepManaged:
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case err == helper.ErrUnknownOnboardingStep:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not update onboarding"})
+	}
+}
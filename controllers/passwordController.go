@@ -0,0 +1,182 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	config "gambl/config"
+	helper "gambl/helpers"
+	"gambl/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// ForgotPassword emails a single-use reset code and link. The response is the same whether or not the email exists.
+func ForgotPassword() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		var payload models.ForgotPassword
+		var user models.User
+
+		if err := c.BindJSON(&payload); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		validationErr := validateUser.Struct(payload)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		// a resend cooldown or lockout is not reported either, so the response cannot be used to probe accounts
+		err := userCollection.FindOne(ctx, bson.M{"email": payload.Email}).Decode(&user)
+		if err == nil && user.Email != nil {
+			code, err := helper.IssueOTP(ctx, helper.OTPPurposeReset, user.User_id)
+			if err == nil {
+				config.SendPasswordResetMail(*user.Email, code, user.Locale)
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"msg":     "if an account exists for this email, a reset code has been sent",
+		})
+	}
+}
+
+// ResetPassword sets a new password using an emailed reset code and logs the user out everywhere
+func ResetPassword() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		var payload models.ResetPassword
+
+		if err := c.BindJSON(&payload); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		validationErr := validateUser.Struct(payload)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		if *payload.New_password != *payload.Confirm_password {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "new password doesnt match confirm password!"})
+			return
+		}
+
+		var user models.User
+		err := userCollection.FindOne(ctx, bson.M{"email": payload.Email}).Decode(&user)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": helper.ErrOTPInvalid.Error()})
+			return
+		}
+
+		if err := helper.VerifyOTP(ctx, helper.OTPPurposeReset, user.User_id, *payload.Code); err != nil {
+			writeOTPError(c, err)
+			return
+		}
+
+		if err := setPassword(ctx, user.User_id, *payload.New_password); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "password was not updated"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"msg":     "password has been reset, please login",
+		})
+	}
+}
+
+// ResetStudentPassword sets a new password on a student account of the caller's school and logs the student out
+func ResetStudentPassword() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		var payload models.ChangeStudentPassword
+		var student models.Student
+
+		if err := c.BindJSON(&payload); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		validationErr := validateUser.Struct(payload)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		if *payload.New_password != *payload.Confirm_password {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "new password doesnt match confirm password!"})
+			return
+		}
+
+		schoolId := c.GetString("school_id")
+		if schoolId == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "X-School-Id header is required"})
+			return
+		}
+
+		filter := bson.M{"school_id": schoolId, "admission_num": helper.NormalizeAdmissionNum(*payload.Admission_num)}
+		err := studentCollection.FindOne(ctx, filter).Decode(&student)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "student doesnt exist"})
+			return
+		}
+
+		password := HashPassword(*payload.New_password)
+		Updated_at, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+		update := bson.D{{Key: "$set", Value: bson.D{
+			{Key: "password", Value: password},
+			{Key: "updated_at", Value: Updated_at},
+		}}}
+
+		if _, err := studentCollection.UpdateOne(ctx, bson.M{"student_id": student.Student_id}, update); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "password was not updated"})
+			return
+		}
+
+		if err := helper.RevokeAllUserTokens(ctx, student.Student_id); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "password was updated but the student could not be logged out"})
+			return
+		}
+
+		if err := helper.UnlockAccount(ctx, helper.StudentLoginKey(schoolId, student.Admission_num)); err != nil {
+			log.Println(err)
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"msg":     "password has been reset",
+		})
+	}
+}
+
+// setPassword stores a new password for the user and revokes every token they hold
+func setPassword(ctx context.Context, userId string, newPassword string) error {
+	password := HashPassword(newPassword)
+	Updated_at, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+	filter := bson.D{{Key: "user_id", Value: userId}}
+
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "password", Value: password},
+		{Key: "updated_at", Value: Updated_at},
+	}}}
+
+	if _, err := userCollection.UpdateOne(ctx, filter, update); err != nil {
+		return err
+	}
+
+	return helper.RevokeAllUserTokens(ctx, userId)
+}
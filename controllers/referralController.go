@@ -0,0 +1,110 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	helper "gambl/helpers"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetMyReferrals returns the caller's referral code and how their referrals are doing
+func GetMyReferrals() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		uid := c.GetString("uid")
+
+		code, err := helper.ReferralCode(ctx, uid)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "could not get your referral code"})
+			return
+		}
+
+		stats, err := helper.GetReferralStats(ctx, uid)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while counting referrals"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"referral_code": code,
+			"stats":         stats,
+		})
+	}
+}
+
+// GetReferralReport lists referrers with their referrals by status, optionally between the from and to RFC3339 times
+func GetReferralReport() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		recordPerPage, err := strconv.Atoi(c.Query("recordPerPage"))
+		if err != nil || recordPerPage < 1 {
+			recordPerPage = 50
+		}
+
+		page, err1 := strconv.Atoi(c.Query("page"))
+		if err1 != nil || page < 1 {
+			page = 1
+		}
+
+		var from, to time.Time
+		if value := c.Query("from"); value != "" {
+			if from, err = time.Parse(time.RFC3339, value); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "from must be an RFC3339 time"})
+				return
+			}
+		}
+		if value := c.Query("to"); value != "" {
+			if to, err = time.Parse(time.RFC3339, value); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "to must be an RFC3339 time"})
+				return
+			}
+		}
+
+		items, total, err := helper.ReferralReport(ctx, c.GetString("school_id"), from, to, (page-1)*recordPerPage, recordPerPage)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while building the referral report"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"total_count":    total,
+			"referrer_items": items,
+		})
+	}
+}
+
+// RewardReferral marks an onboarded referral as rewarded
+func RewardReferral() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		referral, err := helper.RewardReferral(ctx, c.Param("referral_id"), c.GetString("school_id"))
+		switch err {
+		case nil:
+			c.JSON(http.StatusOK, referral)
+		case helper.ErrReferralNotFound:
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case helper.ErrReferralNotOnboarded:
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "referral was not rewarded"})
+		}
+	}
+}
+
+// advanceReferral moves the user's referral forward, a failure does not fail the request
+func advanceReferral(ctx context.Context, uid string, from string, to string) {
+	if err := helper.AdvanceReferral(ctx, uid, from, to); err != nil {
+		log.Println(err)
+	}
+}
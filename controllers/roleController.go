@@ -0,0 +1,274 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gambl/database"
+	helper "gambl/helpers"
+	"gambl/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var rolesCollection *mongo.Collection = database.OpenCollection(database.Client, "roles")
+
+// CreateRole creates a role in the caller's branch with permissions from the catalog
+func CreateRole() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		var role models.CreateRolesDTO
+
+		if err := c.BindJSON(&role); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		validationErr := validateUser.Struct(role)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		// the permission to manage roles was checked against the branch of the header
+		if *role.Branch_id != c.GetString("branch_id") {
+			c.JSON(http.StatusForbidden, gin.H{"error": "branch_id does not match the X-Branch-Id header"})
+			return
+		}
+
+		if err := helper.ValidatePermissions(*role.Permissions); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		count, err := rolesCollection.CountDocuments(ctx, bson.M{"branch_id": role.Branch_id, "role_name": role.Role_name})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while checking the role"})
+			return
+		}
+		if count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "a role with this name already exists in the branch"})
+			return
+		}
+
+		role.ID = primitive.NewObjectID()
+		role.Role_id = role.ID.Hex()
+		role.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		role.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+		if _, err := rolesCollection.InsertOne(ctx, role); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "role was not created"})
+			return
+		}
+		// a lookup before the role existed is cached as granting nothing
+		helper.InvalidateRolePermissions(*role.Branch_id, *role.Role_name)
+		// setting up the roles of the staff is the team step of onboarding
+		markOnboardingStep(ctx, c.GetString("uid"), helper.OnboardingStepTeam)
+
+		c.JSON(http.StatusOK, role)
+	}
+}
+
+// GetRoles lists the roles of a branch
+func GetRoles() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		branchId := c.GetString("branch_id")
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		recordPerPage, err := strconv.Atoi(c.Query("recordPerPage"))
+		if err != nil || recordPerPage < 1 {
+			recordPerPage = 50
+		}
+
+		page, err1 := strconv.Atoi(c.Query("page"))
+		if err1 != nil || page < 1 {
+			page = 1
+		}
+
+		filter := bson.M{"branch_id": branchId}
+		opts := options.Find().
+			SetSort(bson.D{{Key: "role_name", Value: 1}}).
+			SetSkip(int64((page - 1) * recordPerPage)).
+			SetLimit(int64(recordPerPage))
+
+		cursor, err := rolesCollection.Find(ctx, filter, opts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing roles"})
+			return
+		}
+
+		roles := []models.RolesDTO{}
+		if err := cursor.All(ctx, &roles); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing roles"})
+			return
+		}
+
+		total, err := rolesCollection.CountDocuments(ctx, filter)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing roles"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"total_count": total,
+			"role_items":  roles,
+		})
+	}
+}
+
+// GetRole returns a single role
+func GetRole() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		var role models.RolesDTO
+
+		err := rolesCollection.FindOne(ctx, bson.M{"branch_id": c.GetString("branch_id"), "role_id": c.Param("role_id")}).Decode(&role)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "role doesnt exist"})
+			return
+		}
+
+		c.JSON(http.StatusOK, role)
+	}
+}
+
+// UpdateRole changes the name, permissions, description or type of a role
+func UpdateRole() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		var payload models.UpdateRolesDTO
+		var role models.RolesDTO
+
+		if err := c.BindJSON(&payload); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		err := rolesCollection.FindOne(ctx, bson.M{"branch_id": c.GetString("branch_id"), "role_id": c.Param("role_id")}).Decode(&role)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "role doesnt exist"})
+			return
+		}
+		previousName := role.Role_name
+
+		if payload.Permissions != nil {
+			if err := helper.ValidatePermissions(*payload.Permissions); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			role.Permissions = *payload.Permissions
+		}
+
+		// users hold roles by name, so a role in use cannot be renamed from under them
+		if payload.Role_name != nil && *payload.Role_name != role.Role_name {
+			assigned, err := countUsersWithRole(ctx, role)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while checking the role"})
+				return
+			}
+			if assigned > 0 {
+				c.JSON(http.StatusConflict, gin.H{"error": "cannot rename a role that is assigned to users", "assigned_users": assigned})
+				return
+			}
+
+			count, err := rolesCollection.CountDocuments(ctx, bson.M{"branch_id": role.Branch_id, "role_name": payload.Role_name})
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while checking the role"})
+				return
+			}
+			if count > 0 {
+				c.JSON(http.StatusConflict, gin.H{"error": "a role with this name already exists in the branch"})
+				return
+			}
+			role.Role_name = *payload.Role_name
+		}
+
+		if payload.Description != nil {
+			role.Description = *payload.Description
+		}
+		if payload.Role_type != nil {
+			role.Role_type = *payload.Role_type
+		}
+
+		role.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+		filter := bson.D{{Key: "role_id", Value: role.Role_id}}
+
+		update := bson.D{{Key: "$set", Value: bson.D{
+			{Key: "role_name", Value: role.Role_name},
+			{Key: "permissions", Value: role.Permissions},
+			{Key: "description", Value: role.Description},
+			{Key: "role_type", Value: role.Role_type},
+			{Key: "updated_at", Value: role.Updated_at},
+		}}}
+
+		if _, err := rolesCollection.UpdateOne(ctx, filter, update); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "role was not updated"})
+			return
+		}
+		helper.InvalidateRolePermissions(role.Branch_id, previousName)
+		helper.InvalidateRolePermissions(role.Branch_id, role.Role_name)
+
+		c.JSON(http.StatusOK, role)
+	}
+}
+
+// DeleteRole removes a role that is no longer assigned to any user
+func DeleteRole() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		var role models.RolesDTO
+
+		err := rolesCollection.FindOne(ctx, bson.M{"branch_id": c.GetString("branch_id"), "role_id": c.Param("role_id")}).Decode(&role)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "role doesnt exist"})
+			return
+		}
+
+		assigned, err := countUsersWithRole(ctx, role)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while checking the role"})
+			return
+		}
+		if assigned > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "role is still assigned to users", "assigned_users": assigned})
+			return
+		}
+
+		if _, err := rolesCollection.DeleteOne(ctx, bson.M{"role_id": role.Role_id}); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "role was not deleted"})
+			return
+		}
+		helper.InvalidateRolePermissions(role.Branch_id, role.Role_name)
+
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"msg":     "role deleted",
+		})
+	}
+}
+
+// GetPermissions lists the permission catalog roles can be granted from
+func GetPermissions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"permissions": helper.Permissions()})
+	}
+}
+
+// countUsersWithRole counts the users of the role's branch that hold it, a role of the same name in another
+// branch is a different role
+func countUsersWithRole(ctx context.Context, role models.RolesDTO) (int64, error) {
+	return userCollection.CountDocuments(ctx, bson.M{"branch_id": role.Branch_id, "role": role.Role_name})
+}
@@ -0,0 +1,258 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gambl/database"
+	helper "gambl/helpers"
+	"gambl/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var studentCollection *mongo.Collection = database.OpenCollection(database.Client, "students")
+
+// CreateStudent creates a student account in the caller's branch. Students cannot sign up themselves.
+func CreateStudent() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		var payload models.CreateStudent
+
+		if err := c.BindJSON(&payload); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		validationErr := validateUser.Struct(payload)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		schoolId := c.GetString("school_id")
+		if schoolId == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "students can only be created in a school"})
+			return
+		}
+
+		admissionNum := helper.NormalizeAdmissionNum(*payload.Admission_num)
+		password := HashPassword(*payload.Password)
+		now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+		student := models.Student{
+			ID:            primitive.NewObjectID(),
+			First_name:    payload.First_name,
+			Last_name:     payload.Last_name,
+			Admission_num: admissionNum,
+			Password:      &password,
+			School_id:     schoolId,
+			Branch_id:     c.GetString("branch_id"),
+			Active:        true,
+			Created_by:    c.GetString("uid"),
+			Created_at:    now,
+			Updated_at:    now,
+		}
+		student.Student_id = student.ID.Hex()
+		if payload.Class != nil {
+			student.Class = *payload.Class
+		}
+
+		_, err := studentCollection.InsertOne(ctx, student)
+		if database.IsDuplicateKeyError(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "a student with this admission number already exists"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "student was not created"})
+			return
+		}
+
+		student.Password = nil
+		c.JSON(http.StatusOK, student)
+	}
+}
+
+// GetStudents lists the students of the caller's school, filtered by the branch and class queries
+func GetStudents() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		schoolId := c.GetString("school_id")
+		if schoolId == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "X-School-Id header is required"})
+			return
+		}
+
+		recordPerPage, err := strconv.Atoi(c.Query("recordPerPage"))
+		if err != nil || recordPerPage < 1 {
+			recordPerPage = 50
+		}
+
+		page, err1 := strconv.Atoi(c.Query("page"))
+		if err1 != nil || page < 1 {
+			page = 1
+		}
+
+		// staff list their branch, admins may pick another branch of the school
+		branchId := c.GetString("branch_id")
+		if requested := c.Query("branch_id"); requested != "" && requested != branchId {
+			if !helper.CanManageSchool(c, schoolId) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "only admins can list the students of another branch"})
+				return
+			}
+			branchId = requested
+		}
+
+		filter := bson.M{"school_id": schoolId}
+		if branchId != "" {
+			filter["branch_id"] = branchId
+		}
+		if class := c.Query("class"); class != "" {
+			filter["class"] = class
+		}
+
+		opts := options.Find().
+			SetSort(bson.D{{Key: "admission_num", Value: 1}}).
+			SetSkip(int64((page - 1) * recordPerPage)).
+			SetLimit(int64(recordPerPage)).
+			SetProjection(bson.M{"password": 0})
+
+		cursor, err := studentCollection.Find(ctx, filter, opts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing students"})
+			return
+		}
+
+		students := []models.Student{}
+		if err := cursor.All(ctx, &students); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing students"})
+			return
+		}
+
+		total, err := studentCollection.CountDocuments(ctx, filter)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing students"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"total_count":   total,
+			"student_items": students,
+		})
+	}
+}
+
+// StudentLogin logs a student in with their school, admission number and PIN or password
+func StudentLogin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		var payload models.PrecisionStudentLogin
+		var student models.Student
+
+		if err := c.BindJSON(&payload); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		validationErr := validateUser.Struct(payload)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		admissionNum := helper.NormalizeAdmissionNum(*payload.Admission_num)
+		loginKey := helper.StudentLoginKey(*payload.School_id, admissionNum)
+
+		reservation, err := helper.ReserveLoginAttempt(ctx, loginKey, c.ClientIP())
+		if err != nil {
+			writeLoginThrottledError(c, err)
+			return
+		}
+		defer reservation.Release(ctx)
+
+		err = studentCollection.FindOne(ctx, bson.M{"school_id": payload.School_id, "admission_num": admissionNum}).Decode(&student)
+		if err != nil || student.Password == nil {
+			if _, _, err := helper.RecordLoginFailure(ctx, loginKey, c.ClientIP()); err != nil {
+				log.Println(err)
+			}
+			c.JSON(http.StatusForbidden, gin.H{"error": "admission number or password is incorrect"})
+			return
+		}
+
+		if passwordIsValid, _ := VerifyPassword(*payload.Password, *student.Password); !passwordIsValid {
+			if _, _, err := helper.RecordLoginFailure(ctx, loginKey, c.ClientIP()); err != nil {
+				log.Println(err)
+			}
+			c.JSON(http.StatusForbidden, gin.H{"error": "admission number or password is incorrect"})
+			return
+		}
+
+		if !student.Active {
+			c.JSON(http.StatusForbidden, gin.H{"error": "this account has been deactivated"})
+			return
+		}
+
+		token, err := helper.GenerateStudentToken(student)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "couldnt generate token"})
+			return
+		}
+
+		if err := helper.RecordLoginSuccess(ctx, loginKey); err != nil {
+			log.Println(err)
+		}
+
+		student.Password = nil
+		c.JSON(http.StatusOK, gin.H{
+			"jwt_token":  token,
+			"expires_in": int(helper.StudentTokenTTL().Seconds()),
+			"student":    student,
+		})
+	}
+}
+
+// GetStudentProfile returns the account of the logged in student
+func GetStudentProfile() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		var student models.Student
+
+		err := studentCollection.FindOne(ctx, bson.M{"student_id": c.GetString("uid")}, options.FindOne().SetProjection(bson.M{"password": 0})).Decode(&student)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "student doesnt exist"})
+			return
+		}
+
+		c.JSON(http.StatusOK, student)
+	}
+}
+
+// StudentLogout revokes the token used for the request
+func StudentLogout() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := helper.RevokeToken(ctx, c.GetString("jti"), c.GetString("uid"), time.Unix(c.GetInt64("token_expires_at"), 0)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "could not logout"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"msg":     "logged out",
+		})
+	}
+}
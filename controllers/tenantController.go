@@ -0,0 +1,370 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"gambl/database"
+	helper "gambl/helpers"
+	"gambl/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var schoolCollection *mongo.Collection = database.OpenCollection(database.Client, "schools")
+var branchCollection *mongo.Collection = database.OpenCollection(database.Client, "branches")
+
+// CreateSchool creates a school with its first branch. A user who does not belong to a school yet becomes its
+// admin and gets new tokens carrying the school, a super admin creates it without joining it.
+func CreateSchool() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		var payload models.CreateSchool
+
+		if err := c.BindJSON(&payload); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		validationErr := validateUser.Struct(payload)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		superAdmin := helper.IsSuperAdmin(c)
+		uid := c.GetString("uid")
+
+		var user models.User
+		if !superAdmin {
+			err := userCollection.FindOne(ctx, bson.M{"user_id": uid}).Decode(&user)
+			if err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": "user doesnt exist"})
+				return
+			}
+			if user.School_id != "" {
+				c.JSON(http.StatusConflict, gin.H{"error": "you already belong to a school"})
+				return
+			}
+		}
+
+		now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+		school := models.School{
+			ID:         primitive.NewObjectID(),
+			Name:       *payload.Name,
+			Created_at: now,
+			Updated_at: now,
+		}
+		school.School_id = school.ID.Hex()
+		if !superAdmin {
+			school.Owner_id = uid
+		}
+
+		branch := models.Branch{
+			ID:         primitive.NewObjectID(),
+			School_id:  school.School_id,
+			Name:       *payload.Branch_name,
+			Created_at: now,
+			Updated_at: now,
+		}
+		branch.Branch_id = branch.ID.Hex()
+		if payload.Address != nil {
+			branch.Address = *payload.Address
+		}
+
+		if _, err := schoolCollection.InsertOne(ctx, school); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "school was not created"})
+			return
+		}
+		if _, err := branchCollection.InsertOne(ctx, branch); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "branch was not created"})
+			return
+		}
+
+		if superAdmin {
+			c.JSON(http.StatusOK, gin.H{"school": school, "branch": branch})
+			return
+		}
+
+		markOnboardingStep(ctx, uid, helper.OnboardingStepSchool)
+
+		// only claim the school if the user did not join another one in the meantime
+		userType := helper.AdminUserType
+		result, err := userCollection.UpdateOne(ctx,
+			bson.M{"user_id": uid, "school_id": bson.M{"$in": []interface{}{nil, ""}}},
+			bson.M{"$set": bson.M{"school_id": school.School_id, "branch_id": branch.Branch_id, "user_type": userType, "updated_at": now}},
+		)
+		if err != nil || result.ModifiedCount == 0 {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "could not join the school"})
+			return
+		}
+
+		user.School_id = school.School_id
+		user.Branch_id = branch.Branch_id
+		user.User_type = &userType
+
+		token, refreshToken, err := helper.IssueTokens(ctx, helper.SubjectFromUser(user), helper.DeviceID(c))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "couldnt generate token"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"school":        school,
+			"branch":        branch,
+			"jwt_token":     token,
+			"refresh_token": refreshToken,
+			"device_id":     helper.DeviceID(c),
+		})
+	}
+}
+
+// GetSchools lists every school for a super admin, and the caller's school for everyone else
+func GetSchools() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		filter := bson.M{}
+		if schoolId := c.GetString("school_id"); schoolId != "" || !helper.IsSuperAdmin(c) {
+			filter["school_id"] = schoolId
+		}
+
+		cursor, err := schoolCollection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "name", Value: 1}}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing schools"})
+			return
+		}
+
+		schools := []models.School{}
+		if err := cursor.All(ctx, &schools); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing schools"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"school_items": schools})
+	}
+}
+
+// GetSchool returns a school the caller belongs to
+func GetSchool() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		schoolId := c.Param("school_id")
+		if !helper.IsSuperAdmin(c) && c.GetString("school_id") != schoolId {
+			c.JSON(http.StatusNotFound, gin.H{"error": "school doesnt exist"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		var school models.School
+
+		if err := schoolCollection.FindOne(ctx, bson.M{"school_id": schoolId}).Decode(&school); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "school doesnt exist"})
+			return
+		}
+
+		c.JSON(http.StatusOK, school)
+	}
+}
+
+// CreateBranch adds a branch to a school the caller administers
+func CreateBranch() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		schoolId := c.Param("school_id")
+		if !helper.CanManageSchool(c, schoolId) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "unauthorized to access this resource"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		var payload models.CreateBranch
+
+		if err := c.BindJSON(&payload); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		validationErr := validateUser.Struct(payload)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		count, err := schoolCollection.CountDocuments(ctx, bson.M{"school_id": schoolId})
+		if err != nil || count == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "school doesnt exist"})
+			return
+		}
+
+		now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		branch := models.Branch{
+			ID:         primitive.NewObjectID(),
+			School_id:  schoolId,
+			Name:       *payload.Name,
+			Created_at: now,
+			Updated_at: now,
+		}
+		branch.Branch_id = branch.ID.Hex()
+		if payload.Address != nil {
+			branch.Address = *payload.Address
+		}
+
+		if _, err := branchCollection.InsertOne(ctx, branch); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "branch was not created"})
+			return
+		}
+
+		c.JSON(http.StatusOK, branch)
+	}
+}
+
+// GetBranches lists the branches of a school the caller belongs to
+func GetBranches() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		schoolId := c.Param("school_id")
+		if !helper.IsSuperAdmin(c) && c.GetString("school_id") != schoolId {
+			c.JSON(http.StatusNotFound, gin.H{"error": "school doesnt exist"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		cursor, err := branchCollection.Find(ctx, bson.M{"school_id": schoolId}, options.Find().SetSort(bson.D{{Key: "name", Value: 1}}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing branches"})
+			return
+		}
+
+		branches := []models.Branch{}
+		if err := cursor.All(ctx, &branches); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing branches"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"branch_items": branches})
+	}
+}
+
+// InviteStaff invites a teacher or non teacher to a branch of a school the caller administers, with the roles they
+// will hold there. An existing onboarded account is attached to the school right away, a new account takes the invite
+// when it onboards.
+func InviteStaff() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		schoolId := c.Param("school_id")
+		if !helper.CanManageSchool(c, schoolId) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "unauthorized to access this resource"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		var payload models.InviteStaff
+
+		if err := c.BindJSON(&payload); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		validationErr := validateUser.Struct(payload)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		if !branchOfSchool(ctx, c, *payload.Branch_id, schoolId) {
+			return
+		}
+
+		roles := []string{}
+		if payload.Role != nil {
+			roles = *payload.Role
+		}
+		if err := checkRolesExist(ctx, *payload.Branch_id, roles); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		invite, err := helper.InviteStaff(ctx, models.StaffInvite{
+			Email:      strings.TrimSpace(*payload.Email),
+			School_id:  schoolId,
+			Branch_id:  *payload.Branch_id,
+			User_type:  *payload.User_type,
+			Role:       roles,
+			Invited_by: c.GetString("uid"),
+		})
+		switch err {
+		case nil:
+		case helper.ErrStaffInAnotherSchool, helper.ErrStaffNotInvitable:
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "invite was not created"})
+			return
+		}
+
+		c.JSON(http.StatusOK, invite)
+	}
+}
+
+// BackfillStaff lets a super admin attach the staff accounts created before schools existed to a branch of a school
+func BackfillStaff() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !helper.IsSuperAdmin(c) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "unauthorized to access this resource"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		var payload models.BackfillStaff
+
+		if err := c.BindJSON(&payload); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		validationErr := validateUser.Struct(payload)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		schoolId := c.Param("school_id")
+		if !branchOfSchool(ctx, c, *payload.Branch_id, schoolId) {
+			return
+		}
+
+		attached, err := helper.BackfillStaff(ctx, schoolId, *payload.Branch_id, *payload.Created_before)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "could not attach staff"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"attached": attached})
+	}
+}
+
+// branchOfSchool answers with an error unless the branch belongs to the school
+func branchOfSchool(ctx context.Context, c *gin.Context, branchId string, schoolId string) bool {
+	branchSchool, err := helper.BranchSchool(ctx, branchId)
+	if err == helper.ErrBranchNotFound || (err == nil && branchSchool != schoolId) {
+		c.JSON(http.StatusNotFound, gin.H{"error": helper.ErrBranchNotFound.Error()})
+		return false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while reading the branch"})
+		return false
+	}
+	return true
+}
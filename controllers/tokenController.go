@@ -0,0 +1,113 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	helper "gambl/helpers"
+	"gambl/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RefreshToken exchanges a refresh token for a new jwt and refresh token pair
+func RefreshToken() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		var payload models.RefreshTokenRequest
+
+		if err := c.BindJSON(&payload); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		validationErr := validateUser.Struct(payload)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		token, refreshToken, err := helper.RotateRefreshToken(ctx, *payload.Refresh_token)
+		if err == helper.ErrRefreshTokenInvalid || err == helper.ErrRefreshTokenReused {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		if err == helper.ErrEmailNotVerified {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error(), "verification_required": true})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "could not refresh token"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"jwt_token":     token,
+			"refresh_token": refreshToken,
+		})
+	}
+}
+
+// Logout revokes the token used for the request along with the refresh tokens of the current device
+func Logout() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		var payload models.LogoutRequest
+
+		// the body is optional, a refresh token issued to another device can be passed to revoke it too
+		if c.Request.ContentLength > 0 {
+			if err := c.BindJSON(&payload); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		uid := c.GetString("uid")
+		expiresAt := time.Unix(c.GetInt64("token_expires_at"), 0)
+
+		if err := helper.RevokeToken(ctx, c.GetString("jti"), uid, expiresAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "could not logout"})
+			return
+		}
+
+		if deviceId := c.Request.Header.Get("X-Device-Id"); deviceId != "" {
+			if err := helper.RevokeDeviceRefreshTokens(ctx, uid, deviceId); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "could not logout"})
+				return
+			}
+		}
+
+		if payload.Refresh_token != nil {
+			if err := helper.RevokeRefreshToken(ctx, uid, *payload.Refresh_token); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "could not logout"})
+				return
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"msg":     "logged out",
+		})
+	}
+}
+
+// LogoutAll revokes every token issued to the user on every device
+func LogoutAll() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		if err := helper.RevokeAllUserTokens(ctx, c.GetString("uid")); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "could not logout"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"msg":     "logged out of all devices",
+		})
+	}
+}
@@ -2,7 +2,9 @@ package controllers
 
 import (
 	"context"
+	"errors"
 	"log"
+	"math"
 	"strconv"
 
 	"net/http"
@@ -76,6 +78,17 @@ func SignUp() gin.HandlerFunc {
 			return
 		}
 
+		var referrer models.User
+		referred := user.Referral_code != nil && *user.Referral_code != ""
+		if referred {
+			var err error
+			referrer, err = helper.FindReferrer(ctx, *user.Referral_code)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": helper.ErrReferralCodeInvalid.Error()})
+				return
+			}
+		}
+
 		password := HashPassword(*user.Password)
 		user.Password = &password
 
@@ -84,28 +97,57 @@ func SignUp() gin.HandlerFunc {
 		user.ID = primitive.NewObjectID()
 		user.User_id = user.ID.Hex()
 		user.Status = "INACTIVE"
-		token, _, err := helper.GenerateAllTokens(*user.Email, "UNBOARDED", user.User_id)
+		// the user type comes from a staff invite or from creating a school, never from the signup payload
+		userType := helper.UnboardedUserType
+		user.User_type = &userType
+		user.Is_Verified = false
+		user.Verification_sent_at = time.Time{}
+		if user.Locale == "" {
+			user.Locale = c.GetHeader("Accept-Language")
+		}
+		user.Locale = config.EmailLocale(user.Locale)
 
-		if err != nil {
-			msg := "couldnt generate token"
+		resultInsertionNumber, insertErr := userCollection.InsertOne(ctx, user)
+		if insertErr != nil {
+			msg := "User item was not created"
 			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
 			return
 		}
 
-		user.OTP = config.GenerateOTP(4)
+		if err := helper.CreateOnboardingStatus(ctx, user.User_id); err != nil {
+			log.Println(err)
+		}
 
-		config.SendOTPMail(*user.Email, user.OTP)
+		sendVerificationEmail(ctx, user.User_id, *user.Email, user.Locale)
 
-		resultInsertionNumber, insertErr := userCollection.InsertOne(ctx, user)
-		if insertErr != nil {
-			msg := "User item was not created"
+		if referred {
+			if err := helper.RecordReferral(ctx, referrer, user.User_id, *user.Email, "UNBOARDED"); err != nil {
+				log.Println(err)
+			}
+		}
+
+		// the account exists at this point, if the otp cannot be issued the user can ask for it again
+		otp, err := helper.IssueOTP(ctx, helper.OTPPurposeSignup, user.User_id)
+		if err != nil {
+			log.Println(err)
+		} else {
+			config.SendOTPMail(*user.Email, otp, user.Locale)
+		}
+
+		subject := helper.TokenSubject{Email: *user.Email, Uid: user.User_id, User_type: helper.UnboardedUserType}
+		token, refreshToken, err := helper.IssueTokens(ctx, subject, helper.DeviceID(c))
+
+		if err != nil {
+			msg := "couldnt generate token"
 			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
 			return
 		}
 
 		c.JSON(http.StatusOK, gin.H{
-			"user":      resultInsertionNumber,
-			"jwt_token": string(token)})
+			"user":          resultInsertionNumber,
+			"jwt_token":     string(token),
+			"refresh_token": refreshToken,
+			"device_id":     helper.DeviceID(c)})
 
 	}
 }
@@ -138,15 +180,19 @@ func ValidateOTP() gin.HandlerFunc {
 			return
 		}
 
-		if user.OTP != *otp.OTP {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid OTP!"})
+		if err := helper.VerifyOTP(ctx, helper.OTPPurposeSignup, id, *otp.OTP); err != nil {
+			writeOTPError(c, err)
 			return
 		}
 
 		filter := bson.D{{Key: "user_id", Value: id}}
 
+		// otp codes used to be stored in plain text on the user, drop any that is left over
 		update := bson.D{{Key: "$set", Value: bson.D{
 			{Key: "otpVerified", Value: true},
+			{Key: "is_verified", Value: true},
+		}}, {Key: "$unset", Value: bson.D{
+			{Key: "otp", Value: ""},
 		}}}
 
 		_, insertErr := userCollection.UpdateOne(ctx, filter, update)
@@ -156,6 +202,8 @@ func ValidateOTP() gin.HandlerFunc {
 			return
 		}
 
+		advanceReferral(ctx, id, helper.ReferralPending, helper.ReferralJoined)
+
 		c.JSON(http.StatusOK, gin.H{
 			"success": true,
 			"msg":     "OTP validated",
@@ -218,9 +266,24 @@ func ChangePassword() gin.HandlerFunc {
 			return
 		}
 
+		// a changed password must end every existing session, the caller gets a fresh pair for this device
+		if err := helper.RevokeAllUserTokens(ctx, id); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "could not revoke existing tokens"})
+			return
+		}
+
+		token, refreshToken, err := helper.IssueTokens(ctx, helper.SubjectFromUser(user), helper.DeviceID(c))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "couldnt generate token"})
+			return
+		}
+
 		c.JSON(http.StatusOK, gin.H{
-			"success": true,
-			"msg":     "password changed",
+			"success":       true,
+			"msg":           "password changed",
+			"jwt_token":     token,
+			"refresh_token": refreshToken,
+			"device_id":     helper.DeviceID(c),
 		})
 	}
 }
@@ -251,36 +314,32 @@ func ResendOTP() gin.HandlerFunc {
 		}
 
 		u_type := user.User_type
-		otp := config.GenerateOTP(4)
 
 		if *u_type != "UNBOARDED" {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "user has been onboarded"})
 			return
 		}
 
-		token, _, err := helper.GenerateAllTokens(*user.Email, "UNBOARDED", user.User_id)
-
+		otp, err := helper.IssueOTP(ctx, helper.OTPPurposeSignup, user.User_id)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "could not generate token"})
+			writeOTPError(c, err)
 			return
 		}
 
-		filter := bson.D{{Key: "email", Value: resendOtp.Email}}
-
-		update := bson.D{{Key: "$set", Value: bson.D{
-			{Key: "otp", Value: otp},
-		}}}
+		token, refreshToken, err := helper.IssueTokens(ctx, helper.SubjectFromUser(user), helper.DeviceID(c))
 
-		_, insertErr := userCollection.UpdateOne(ctx, filter, update)
-		if insertErr != nil {
-			msg := "otp was not sent"
-			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "could not generate token"})
 			return
 		}
 
+		config.SendOTPMail(*user.Email, otp, user.Locale)
+
 		c.JSON(http.StatusOK, gin.H{
-			"msg":   "OTP sent",
-			"token": token,
+			"msg":           "OTP sent",
+			"token":         token,
+			"refresh_token": refreshToken,
+			"device_id":     helper.DeviceID(c),
 		})
 	}
 }
@@ -290,7 +349,7 @@ func TestOTP() gin.HandlerFunc {
 		var _, cancel = context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
 
-		config.SendOTPMail("elvis.osujic@gmail.com", "4000")
+		config.SendOTPMail("elvis.osujic@gmail.com", "4000", config.EmailLocale(c.GetHeader("Accept-Language")))
 
 		c.JSON(http.StatusOK, gin.H{
 			"msg": "OTP sent",
@@ -310,14 +369,28 @@ func Login() gin.HandlerFunc {
 			return
 		}
 
-		err := userCollection.FindOne(ctx, bson.M{"email": user.Email}).Decode(&foundUser)
+		if user.Email == nil || user.Password == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "email and password are required"})
+			return
+		}
+
+		reservation, err := helper.ReserveLoginAttempt(ctx, *user.Email, c.ClientIP())
 		if err != nil {
+			writeLoginThrottledError(c, err)
+			return
+		}
+		defer reservation.Release(ctx)
+
+		err = userCollection.FindOne(ctx, bson.M{"email": user.Email}).Decode(&foundUser)
+		if err != nil {
+			recordLoginFailure(c, ctx, *user.Email)
 			c.JSON(http.StatusForbidden, gin.H{"error": "login or passowrd is incorrect"})
 			return
 		}
 
 		passwordIsValid, msg := VerifyPassword(*user.Password, *foundUser.Password)
 		if !passwordIsValid {
+			recordLoginFailure(c, ctx, *user.Email)
 			c.JSON(http.StatusForbidden, gin.H{"error": msg})
 			return
 		}
@@ -327,28 +400,194 @@ func Login() gin.HandlerFunc {
 			return
 		}
 
-		token, _, _ := helper.GenerateAllTokens(*foundUser.Email, *foundUser.User_type, foundUser.User_id)
+		completeLogin(c, ctx, foundUser)
+
+	}
+}
+
+// RequestLoginOTP emails a one-time password that can be used instead of the account password
+func RequestLoginOTP() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		var payload models.ResendOtp
+		var user models.User
+
+		if err := c.BindJSON(&payload); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		validationErr := validateUser.Struct(payload)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		// the response does not reveal whether the account exists or a code was actually sent
+		err := userCollection.FindOne(ctx, bson.M{"email": payload.Email}).Decode(&user)
+		if err == nil && user.Email != nil {
+			otp, err := helper.IssueOTP(ctx, helper.OTPPurposeLogin, user.User_id)
+			if err == nil {
+				config.SendOTPMail(*user.Email, otp, user.Locale)
+			}
+		}
 
 		c.JSON(http.StatusOK, gin.H{
-			"jwt_token": string(token),
-			"user":      foundUser,
+			"msg": "if an account exists for this email, an OTP has been sent",
 		})
-
 	}
 }
 
-func GetUsers() gin.HandlerFunc {
+// LoginWithOTP logs a user in with an emailed one-time password
+func LoginWithOTP() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		var payload models.LoginOtp
+		var foundUser models.User
 
-		if err := helper.RoleTypeCheck(c, "ADMIN", "65f2e8b6ab423f0349550c4f", "staff_indicators_charts:readk"); err != nil {
+		if err := c.BindJSON(&payload); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
-		if err := helper.CheckUserType(c, "ADMIN"); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		validationErr := validateUser.Struct(payload)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
 			return
 		}
+
+		reservation, err := helper.ReserveLoginAttempt(ctx, *payload.Email, c.ClientIP())
+		if err != nil {
+			writeLoginThrottledError(c, err)
+			return
+		}
+		defer reservation.Release(ctx)
+
+		err = userCollection.FindOne(ctx, bson.M{"email": payload.Email}).Decode(&foundUser)
+		if err != nil || foundUser.Email == nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": "login or otp is incorrect"})
+			return
+		}
+
+		if err := helper.VerifyOTP(ctx, helper.OTPPurposeLogin, foundUser.User_id, *payload.OTP); err != nil {
+			writeOTPError(c, err)
+			return
+		}
+
+		completeLogin(c, ctx, foundUser)
+	}
+}
+
+// completeLogin writes the login response for a user whose credentials have been checked. Accounts with a
+// second factor, or that the MFA policy requires to have one, get an mfa pending token instead of a session.
+func completeLogin(c *gin.Context, ctx context.Context, foundUser models.User) {
+	if helper.MustVerifyEmail(foundUser) {
+		c.JSON(http.StatusForbidden, gin.H{"error": helper.ErrEmailNotVerified.Error(), "verification_required": true})
+		return
+	}
+
+	mfaEnabled, err := helper.MfaEnabled(ctx, foundUser.User_id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "couldnt check two-factor authentication"})
+		return
+	}
+
+	if mfaEnabled || helper.MfaRequired(foundUser) {
+		mfaToken, err := helper.GenerateMfaPendingToken(*foundUser.Email, *foundUser.User_type, foundUser.User_id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "couldnt generate token"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"mfa_required":            mfaEnabled,
+			"mfa_enrollment_required": !mfaEnabled,
+			"mfa_token":               mfaToken,
+		})
+		return
+	}
+
+	response, err := issueLoginTokens(c, ctx, foundUser)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "couldnt generate token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// issueLoginTokens starts a session for a fully authenticated user and returns the login response body
+func issueLoginTokens(c *gin.Context, ctx context.Context, foundUser models.User) (gin.H, error) {
+	token, refreshToken, err := helper.IssueTokens(ctx, helper.SubjectFromUser(foundUser), helper.DeviceID(c))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := helper.RecordLoginSuccess(ctx, *foundUser.Email); err != nil {
+		log.Println(err)
+	}
+
+	return gin.H{
+		"jwt_token":     string(token),
+		"refresh_token": refreshToken,
+		"device_id":     helper.DeviceID(c),
+		"user":          foundUser,
+	}, nil
+}
+
+// recordLoginFailure counts a failed login and emails the account owner when it locks the account
+func recordLoginFailure(c *gin.Context, ctx context.Context, email string) {
+	locked, lockedUntil, err := helper.RecordLoginFailure(ctx, email, c.ClientIP())
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	if locked {
+		var user models.User
+		if err := userCollection.FindOne(ctx, bson.M{"email": email}).Decode(&user); err == nil && user.Email != nil {
+			config.SendAccountLockedMail(*user.Email, lockedUntil, user.Locale)
+		}
+	}
+}
+
+// writeLoginThrottledError maps the error of helper.ReserveLoginAttempt to a response
+func writeLoginThrottledError(c *gin.Context, err error) {
+	var throttled *helper.LoginThrottledError
+	if !errors.As(err, &throttled) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not process login"})
+		return
+	}
+
+	retryAfter := int(math.Ceil(throttled.Retry_after.Seconds()))
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":       err.Error(),
+		"locked":      throttled.Locked,
+		"retry_after": retryAfter,
+	})
+}
+
+// writeOTPError maps the errors of helper.IssueOTP and helper.VerifyOTP to a response
+func writeOTPError(c *gin.Context, err error) {
+	var cooldown *helper.OTPCooldownError
+
+	switch {
+	case errors.As(err, &cooldown):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error(), "retry_after": int(cooldown.Retry_after.Seconds() + 0.5)})
+	case err == helper.ErrOTPLocked:
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+	case err == helper.ErrOTPInvalid, err == helper.ErrOTPExpired:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not process OTP"})
+	}
+}
+
+func GetUsers() gin.HandlerFunc {
+	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 		// recordPerPage := 10
@@ -365,7 +604,7 @@ func GetUsers() gin.HandlerFunc {
 		startIndex := (page - 1) * recordPerPage
 		// startIndex, err = strconv.Atoi(c.Query("startIndex"))
 
-		matchStage := bson.D{{Key: "$match", Value: bson.D{{}}}}
+		matchStage := bson.D{{Key: "$match", Value: helper.ScopeToTenant(c, bson.M{})}}
 		groupStage := bson.D{{Key: "$group", Value: bson.D{{Key: "_id", Value: bson.D{{Key: "_id", Value: "null"}}}, {Key: "total_count", Value: bson.D{{Key: "$sum", Value: 1}}}, {Key: "data", Value: bson.D{{Key: "$push", Value: "$$ROOT"}}}}}}
 		projectStage := bson.D{
 			{Key: "$project", Value: bson.D{
@@ -380,11 +619,17 @@ func GetUsers() gin.HandlerFunc {
 
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occured while listing user items"})
+			return
 		}
 		var allusers []bson.M
 		if err = result.All(ctx, &allusers); err != nil {
 			log.Fatal(err)
 		}
+		// the group stage yields nothing when no user matches the tenant
+		if len(allusers) == 0 {
+			c.JSON(http.StatusOK, gin.H{"total_count": 0, "user_items": []bson.M{}})
+			return
+		}
 		c.JSON(http.StatusOK, allusers[0])
 
 	}
@@ -410,7 +655,7 @@ func GetUser() gin.HandlerFunc {
 
 		var user models.User
 
-		err := userCollection.FindOne(ctx, bson.M{"user_id": userId}).Decode(&user)
+		err := userCollection.FindOne(ctx, helper.ScopeToTenant(c, bson.M{"user_id": userId})).Decode(&user)
 		defer cancel()
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -454,7 +699,7 @@ func EditUser() gin.HandlerFunc {
 			return
 		}
 
-		err := userCollection.FindOne(ctx, bson.M{"user_id": userId}).Decode(&user)
+		err := userCollection.FindOne(ctx, helper.ScopeToTenant(c, bson.M{"user_id": userId})).Decode(&user)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
@@ -474,7 +719,22 @@ func EditUser() gin.HandlerFunc {
 		}
 		if editUser.Role == nil {
 			editUser.Role = &user.Role
-		}
+		} else {
+			// roles are granted by the school's admin, users cannot pick their own
+			if !helper.CanManageSchool(c, user.School_id) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "only the school admin can change roles"})
+				return
+			}
+			if err := checkRolesExist(ctx, user.Branch_id, *editUser.Role); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		if editUser.Locale == nil {
+			editUser.Locale = &user.Locale
+		}
+		locale := config.EmailLocale(*editUser.Locale)
+		editUser.Locale = &locale
 
 		editUser.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 
@@ -486,6 +746,7 @@ func EditUser() gin.HandlerFunc {
 			{Key: "address", Value: editUser.Address},
 			{Key: "phone", Value: editUser.Phone},
 			{Key: "role", Value: editUser.Role},
+			{Key: "locale", Value: editUser.Locale},
 			{Key: "updated_at", Value: editUser.Updated_at},
 		}}}
 
@@ -500,3 +761,28 @@ func EditUser() gin.HandlerFunc {
 
 	}
 }
+
+// UnlockUser lifts a login lockout on an account
+func UnlockUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		var user models.User
+
+		err := userCollection.FindOne(ctx, helper.ScopeToTenant(c, bson.M{"user_id": c.Param("user_id")})).Decode(&user)
+		if err != nil || user.Email == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user doesnt exist"})
+			return
+		}
+
+		if err := helper.UnlockAccount(ctx, *user.Email); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "user was not unlocked"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"msg":     "user unlocked",
+		})
+	}
+}
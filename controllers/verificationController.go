@@ -0,0 +1,87 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	config "gambl/config"
+	helper "gambl/helpers"
+	"gambl/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// VerifyEmail confirms the email address of the account the verification link was sent to
+func VerifyEmail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		uid, err := helper.VerifyEmail(ctx, c.Param("token"))
+		if err == helper.ErrVerificationTokenInvalid {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "email was not verified"})
+			return
+		}
+
+		advanceReferral(ctx, uid, helper.ReferralPending, helper.ReferralJoined)
+
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"msg":     "email verified",
+		})
+	}
+}
+
+// ResendVerificationEmail sends a new verification link. The response is the same whether or not the email exists.
+func ResendVerificationEmail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		var payload models.ResendOtp
+		var user models.User
+
+		if err := c.BindJSON(&payload); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		validationErr := validateUser.Struct(payload)
+		if validationErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": validationErr.Error()})
+			return
+		}
+
+		err := userCollection.FindOne(ctx, bson.M{"email": payload.Email}).Decode(&user)
+		if err == nil && user.Email != nil && user.Is_Verified != nil && !*user.Is_Verified && !user.OtpVerified {
+			sendVerificationEmail(ctx, user.User_id, *user.Email, user.Locale)
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"msg":     "if this email needs verifying, a new link has been sent",
+		})
+	}
+}
+
+// sendVerificationEmail emails a verification link unless one was sent within the resend cooldown
+func sendVerificationEmail(ctx context.Context, uid string, email string, locale string) {
+	if err := helper.MarkVerificationSent(ctx, uid); err != nil {
+		log.Println(err)
+		return
+	}
+
+	token, err := helper.GenerateEmailVerificationToken(uid, email)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	config.SendPrecisionVerifyMail(email, token, locale)
+}
@@ -12,35 +12,41 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-// DBinstance func
+// DBinstance creates the client for MONGODB_URL without connecting it, so packages can open their collections
+// when they are loaded. Connect connects it when the server starts.
 func DBinstance() *mongo.Client {
 	err := godotenv.Load(".env")
 	if err != nil {
 		log.Println("Error loading .env file, proceeding with environment variables")
 	}
 
-	// Retrieve the MONGO_URL environment variable, or use a default if not set
-	MongoDb := os.Getenv("MONGODB_URL")
-	if MongoDb == "" {
-		// Use a default value or handle the case where MONGO_URL is not set
-		log.Fatal("MONGO_URL environment variable is not set")
+	clientOptions := options.Client()
+	if MongoDb := os.Getenv("MONGODB_URL"); MongoDb != "" {
+		clientOptions.ApplyURI(MongoDb)
 	}
 
-	client, err := mongo.NewClient(options.Client().ApplyURI(MongoDb))
+	client, err := mongo.NewClient(clientOptions)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	return client
+}
+
+// Connect connects the client to MONGODB_URL, which must be set. The server calls it before anything else.
+func Connect() {
+	if os.Getenv("MONGODB_URL") == "" {
+		log.Fatal("MONGO_URL environment variable is not set")
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 
 	defer cancel()
-	err = client.Connect(ctx)
+	err := Client.Connect(ctx)
 	if err != nil {
 		log.Fatal(err)
 	}
 	fmt.Println("Connected to MongoDB!")
-
-	return client
 }
 
 // Client Database instance
@@ -53,3 +59,28 @@ func OpenCollection(client *mongo.Client, collectionName string) *mongo.Collecti
 
 	return collection
 }
+
+// CreateIndexes creates the given indexes on a collection. Failures are logged rather than fatal so a missing index never stops the server
+func CreateIndexes(collection *mongo.Collection, indexes ...mongo.IndexModel) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := collection.Indexes().CreateMany(ctx, indexes); err != nil {
+		log.Printf("Error creating indexes on %s: %v", collection.Name(), err)
+	}
+}
+
+// IsDuplicateKeyError reports whether a write failed on a unique index
+func IsDuplicateKeyError(err error) bool {
+	switch e := err.(type) {
+	case mongo.WriteException:
+		for _, writeErr := range e.WriteErrors {
+			if writeErr.Code == 11000 {
+				return true
+			}
+		}
+	case mongo.CommandError:
+		return e.Code == 11000
+	}
+	return false
+}
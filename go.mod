@@ -11,6 +11,7 @@ require (
 	github.com/go-playground/validator/v10 v10.15.5
 	github.com/heroku/x v0.0.26
 	github.com/joho/godotenv v1.3.0
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
 	go.mongodb.org/mongo-driver v1.4.5
 	golang.org/x/crypto v0.26.0
 )
@@ -0,0 +1,267 @@
+package helper
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gambl/config"
+	"gambl/database"
+	"gambl/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var aiConversationCollection *mongo.Collection = database.OpenCollection(database.Client, "ai_conversations")
+
+var (
+	ErrConversationNotFound = errors.New("conversation not found")
+	ErrConversationFull     = errors.New("conversation has reached its message limit, please start a new one")
+)
+
+const defaultAISystemPrompt = "You are a helpful assistant for teachers and school staff. Answer clearly and concisely."
+
+// AIConversationSettings controls how much history is sent to the model
+type AIConversationSettings struct {
+	ContextTokens int
+	MaxMessages   int
+	Summarize     bool
+	SystemPrompt  string
+}
+
+func aiConversationSettings() AIConversationSettings {
+	systemPrompt := os.Getenv("AI_SYSTEM_PROMPT")
+	if systemPrompt == "" {
+		systemPrompt = defaultAISystemPrompt
+	}
+
+	return AIConversationSettings{
+		ContextTokens: envInt("AI_CONTEXT_MAX_TOKENS", 3000),
+		MaxMessages:   envInt("AI_CONVERSATION_MAX_MESSAGES", 200),
+		Summarize:     os.Getenv("AI_CONVERSATION_SUMMARIZE") != "false",
+		SystemPrompt:  systemPrompt,
+	}
+}
+
+// CreateConversation stores a new conversation
+func CreateConversation(ctx context.Context, conversation models.AIConversation) error {
+	_, err := aiConversationCollection.InsertOne(ctx, conversation)
+	return err
+}
+
+// GetConversation returns a conversation of the user with its messages
+func GetConversation(ctx context.Context, userId string, conversationId string) (models.AIConversation, error) {
+	var conversation models.AIConversation
+	err := aiConversationCollection.FindOne(ctx, bson.M{"conversation_id": conversationId, "user_id": userId}).Decode(&conversation)
+	if err == mongo.ErrNoDocuments {
+		return conversation, ErrConversationNotFound
+	}
+	return conversation, err
+}
+
+// ListConversations returns a page of the user's conversations without their messages, most recent first
+func ListConversations(ctx context.Context, userId string, skip int, limit int) ([]models.AIConversation, int64, error) {
+	filter := bson.M{"user_id": userId}
+
+	total, err := aiConversationCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().
+		SetProjection(bson.M{"messages": 0}).
+		SetSort(bson.D{{Key: "updated_at", Value: -1}}).
+		SetSkip(int64(skip)).
+		SetLimit(int64(limit))
+	cursor, err := aiConversationCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	conversations := []models.AIConversation{}
+	if err = cursor.All(ctx, &conversations); err != nil {
+		return nil, 0, err
+	}
+	return conversations, total, nil
+}
+
+// UpdateConversation sets fields of a conversation of the user
+func UpdateConversation(ctx context.Context, userId string, conversationId string, set bson.M) error {
+	set["updated_at"] = time.Now().UTC()
+	result, err := aiConversationCollection.UpdateOne(ctx, bson.M{"conversation_id": conversationId, "user_id": userId}, bson.M{"$set": set})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrConversationNotFound
+	}
+	return nil
+}
+
+// DeleteConversation deletes a conversation of the user
+func DeleteConversation(ctx context.Context, userId string, conversationId string) error {
+	result, err := aiConversationCollection.DeleteOne(ctx, bson.M{"conversation_id": conversationId, "user_id": userId})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrConversationNotFound
+	}
+	return nil
+}
+
+// CheckConversationRoom fails when the conversation cannot take another prompt and answer
+func CheckConversationRoom(conversation models.AIConversation) error {
+	if conversation.Message_count+2 > aiConversationSettings().MaxMessages {
+		return ErrConversationFull
+	}
+	return nil
+}
+
+// AppendConversationMessages adds a prompt and its answer to a conversation
+func AppendConversationMessages(ctx context.Context, conversation models.AIConversation, messages ...models.AIConversationMessage) error {
+	filter := bson.M{
+		"_id":           conversation.ID,
+		"message_count": bson.M{"$lte": aiConversationSettings().MaxMessages - len(messages)},
+	}
+	update := bson.M{
+		"$push": bson.M{"messages": bson.M{"$each": messages}},
+		"$inc":  bson.M{"message_count": len(messages)},
+		"$set":  bson.M{"updated_at": time.Now().UTC()},
+	}
+
+	result, err := aiConversationCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrConversationFull
+	}
+	return nil
+}
+
+// ConversationContext builds the messages sent to the model for a new prompt: the system prompt, the summary of
+// older messages, as many recent messages as fit in AI_CONTEXT_MAX_TOKENS and the prompt. Messages that no longer
+// fit are summarized into the conversation first, or simply left out when summarizing is off or fails, which it does
+// when the owner has used up a quota.
+func ConversationContext(ctx context.Context, owner AIUsageOwner, conversation *models.AIConversation, prompt string) []models.AIMessage {
+	settings := aiConversationSettings()
+
+	systemPrompt := conversation.System_prompt
+	if systemPrompt == "" {
+		systemPrompt = settings.SystemPrompt
+	}
+
+	start := conversation.Summarized_count
+	if start > len(conversation.Messages) {
+		start = len(conversation.Messages)
+	}
+	history := conversation.Messages[start:]
+
+	budget := settings.ContextTokens - EstimateTokens(systemPrompt) - EstimateTokens(conversation.Summary) - EstimateTokens(prompt)
+	keep := len(history)
+	for used := 0; keep > 0; keep-- {
+		used += EstimateTokens(history[keep-1].Content)
+		if used > budget {
+			break
+		}
+	}
+
+	if keep > 0 && settings.Summarize {
+		if err := summarizeConversation(ctx, owner, conversation, history[:keep]); err != nil {
+			log.Printf("Error summarizing conversation %s: %v", conversation.Conversation_id, err)
+		}
+	}
+
+	messages := []models.AIMessage{{Role: "system", Content: systemPrompt}}
+	if conversation.Summary != "" {
+		messages = append(messages, models.AIMessage{Role: "system", Content: "Summary of the earlier conversation:\n" + conversation.Summary})
+	}
+	for _, message := range history[keep:] {
+		messages = append(messages, models.AIMessage{Role: message.Role, Content: message.Content})
+	}
+	return append(messages, models.AIMessage{Role: "user", Content: prompt})
+}
+
+// summarizeConversation folds messages into the conversation summary. Its tokens are reserved on the owner's quotas
+// and count towards their usage, so it is not done once a quota is used up. The summary is only saved if no other
+// request summarized the conversation in the meantime.
+func summarizeConversation(ctx context.Context, owner AIUsageOwner, conversation *models.AIConversation, messages []models.AIConversationMessage) error {
+	maxTokens := 300
+	reservation, err := ReserveAIQuota(ctx, owner, int64(maxTokens))
+	if err != nil {
+		return err
+	}
+
+	var transcript strings.Builder
+	for _, message := range messages {
+		transcript.WriteString(message.Role + ": " + message.Content + "\n")
+	}
+	// the summary request has to fit in the context window too
+	text := lastBytes(transcript.String(), aiConversationSettings().ContextTokens*4)
+
+	instructions := "Summarize the conversation below in a few sentences, keeping names, facts, decisions and open questions."
+	if conversation.Summary != "" {
+		instructions += " Merge it with the summary of what came before:\n" + conversation.Summary
+	}
+
+	temperature := 0.2
+	response, err := config.ChatAI(ctx, models.AIModel{Max_tokens: &maxTokens, Temperature: &temperature}, []models.AIMessage{
+		{Role: "system", Content: instructions},
+		{Role: "user", Content: text},
+	})
+	if settleErr := reservation.Settle(ctx, response.Usage); settleErr != nil {
+		log.Printf("Error recording the AI usage of %s: %v", conversation.User_id, settleErr)
+	}
+	if err != nil {
+		return err
+	}
+
+	summarizedCount := conversation.Summarized_count + len(messages)
+	_, err = aiConversationCollection.UpdateOne(ctx,
+		bson.M{"_id": conversation.ID, "summarized_count": conversation.Summarized_count},
+		bson.M{"$set": bson.M{"summary": response.Content, "summarized_count": summarizedCount}},
+	)
+	if err != nil {
+		return err
+	}
+
+	conversation.Summary = response.Content
+	conversation.Summarized_count = summarizedCount
+	return nil
+}
+
+// lastBytes returns the end of a text, at most limit bytes long and starting on a whole character
+func lastBytes(text string, limit int) string {
+	if len(text) <= limit {
+		return text
+	}
+	start := len(text) - limit
+	for start < len(text) && !utf8.RuneStart(text[start]) {
+		start++
+	}
+	return text[start:]
+}
+
+// FirstBytes returns the start of a text, at most limit bytes long and ending on a whole character
+func FirstBytes(text string, limit int) string {
+	if len(text) <= limit {
+		return text
+	}
+	end := limit
+	for end > 0 && !utf8.RuneStart(text[end]) {
+		end--
+	}
+	return text[:end]
+}
+
+// EstimateTokens approximates the token count of a text, about four characters per token
+func EstimateTokens(text string) int {
+	return len(text)/4 + 4
+}
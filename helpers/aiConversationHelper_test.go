@@ -0,0 +1,66 @@
+package helper
+
+import (
+	"testing"
+	"unicode/utf8"
+)
+
+func TestLastBytes(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		limit int
+		want  string
+	}{
+		{name: "short text", text: "hello", limit: 10, want: "hello"},
+		{name: "exact length", text: "hello", limit: 5, want: "hello"},
+		{name: "ascii", text: "hello world", limit: 5, want: "world"},
+		{name: "cut on a rune boundary", text: "añb", limit: 3, want: "ñb"},
+		{name: "cut inside a two byte rune", text: "añb", limit: 2, want: "b"},
+		{name: "cut inside a four byte rune", text: "a😀b", limit: 4, want: "b"},
+		{name: "cut inside a three byte rune", text: "x日本", limit: 5, want: "本"},
+		{name: "zero limit", text: "abc", limit: 0, want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := lastBytes(tt.text, tt.limit)
+			if got != tt.want {
+				t.Errorf("lastBytes(%q, %d) = %q, want %q", tt.text, tt.limit, got, tt.want)
+			}
+			if !utf8.ValidString(got) || len(got) > tt.limit {
+				t.Errorf("lastBytes(%q, %d) = %q is not valid UTF-8 within the limit", tt.text, tt.limit, got)
+			}
+		})
+	}
+}
+
+func TestFirstBytes(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		limit int
+		want  string
+	}{
+		{name: "short text", text: "hello", limit: 10, want: "hello"},
+		{name: "exact length", text: "hello", limit: 5, want: "hello"},
+		{name: "ascii", text: "hello world", limit: 5, want: "hello"},
+		{name: "cut on a rune boundary", text: "añb", limit: 3, want: "añ"},
+		{name: "cut inside a two byte rune", text: "añb", limit: 2, want: "a"},
+		{name: "cut inside a four byte rune", text: "a😀b", limit: 4, want: "a"},
+		{name: "cut inside a three byte rune", text: "日本x", limit: 5, want: "日"},
+		{name: "zero limit", text: "abc", limit: 0, want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := FirstBytes(tt.text, tt.limit)
+			if got != tt.want {
+				t.Errorf("FirstBytes(%q, %d) = %q, want %q", tt.text, tt.limit, got, tt.want)
+			}
+			if !utf8.ValidString(got) || len(got) > tt.limit {
+				t.Errorf("FirstBytes(%q, %d) = %q is not valid UTF-8 within the limit", tt.text, tt.limit, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,376 @@
+package helper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gambl/database"
+	"gambl/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var aiDocumentCollection *mongo.Collection = database.OpenCollection(database.Client, "ai_documents")
+var aiDocumentChunkCollection *mongo.Collection = database.OpenCollection(database.Client, "ai_document_chunks")
+
+var ErrDocumentNotFound = errors.New("document not found")
+
+const askSystemPrompt = "You answer questions for the staff of a school using only the numbered sources provided, " +
+	"which come from the school's own documents. Cite the sources you use with their number in brackets, such as [1]. " +
+	"If the sources do not contain the answer, say so instead of guessing."
+
+// AIDocumentSettings controls how documents are split and searched
+type AIDocumentSettings struct {
+	MaxBytes      int64
+	MaxChunks     int
+	ChunkTokens   int
+	OverlapTokens int
+	TopK          int
+	VectorIndex   string
+	Dimensions    int
+	ScanLimit     int
+}
+
+// AIDocumentSettingsFromEnv reads AI_DOCUMENT_MAX_MB, AI_DOCUMENT_MAX_CHUNKS, AI_CHUNK_TOKENS, AI_CHUNK_OVERLAP_TOKENS,
+// AI_ASK_TOP_K, AI_VECTOR_SEARCH_INDEX, AI_EMBEDDING_DIMENSIONS and AI_VECTOR_SCAN_LIMIT
+func AIDocumentSettingsFromEnv() AIDocumentSettings {
+	return AIDocumentSettings{
+		MaxBytes:      int64(envInt("AI_DOCUMENT_MAX_MB", 10)) << 20,
+		MaxChunks:     envInt("AI_DOCUMENT_MAX_CHUNKS", 1000),
+		ChunkTokens:   envInt("AI_CHUNK_TOKENS", 300),
+		OverlapTokens: envInt("AI_CHUNK_OVERLAP_TOKENS", 50),
+		TopK:          envInt("AI_ASK_TOP_K", 5),
+		VectorIndex:   os.Getenv("AI_VECTOR_SEARCH_INDEX"),
+		Dimensions:    envInt("AI_EMBEDDING_DIMENSIONS", 1536),
+		ScanLimit:     envInt("AI_VECTOR_SCAN_LIMIT", 20000),
+	}
+}
+
+// CreateDocumentIndexes lets documents be listed per branch and their chunks be found. With AI_VECTOR_SEARCH_INDEX
+// set it also asks Atlas for the vector search index, which can be created from the Atlas UI instead.
+func CreateDocumentIndexes() {
+	database.CreateIndexes(aiDocumentCollection,
+		mongo.IndexModel{Keys: bson.D{{Key: "document_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		mongo.IndexModel{Keys: bson.D{{Key: "branch_id", Value: 1}, {Key: "created_at", Value: -1}}},
+	)
+	database.CreateIndexes(aiDocumentChunkCollection,
+		mongo.IndexModel{Keys: bson.D{{Key: "document_id", Value: 1}, {Key: "index", Value: 1}}},
+		mongo.IndexModel{Keys: bson.D{{Key: "branch_id", Value: 1}, {Key: "embedding_model", Value: 1}}},
+	)
+
+	settings := AIDocumentSettingsFromEnv()
+	if settings.VectorIndex == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err := aiDocumentChunkCollection.Database().RunCommand(ctx, bson.D{
+		{Key: "createSearchIndexes", Value: aiDocumentChunkCollection.Name()},
+		{Key: "indexes", Value: bson.A{bson.M{
+			"name": settings.VectorIndex,
+			"type": "vectorSearch",
+			"definition": bson.M{"fields": bson.A{
+				bson.M{"type": "vector", "path": "embedding", "numDimensions": settings.Dimensions, "similarity": "cosine"},
+				bson.M{"type": "filter", "path": "branch_id"},
+				bson.M{"type": "filter", "path": "embedding_model"},
+			}},
+		}}},
+	}).Err()
+	if err != nil {
+		log.Printf("Error creating the vector search index %s: %v", settings.VectorIndex, err)
+	}
+}
+
+var paragraphBreak = regexp.MustCompile(`\n[ \t\r]*\n`)
+
+// ChunkText splits text into passages of about maxTokens estimated tokens, cut between words and starting with the
+// last overlapTokens of the passage before so a sentence cut in two is whole in one of them. Paragraph breaks are kept.
+func ChunkText(text string, maxTokens int, overlapTokens int) []string {
+	type word struct {
+		text      string
+		paragraph bool
+	}
+
+	var words []word
+	for _, paragraph := range paragraphBreak.Split(strings.ReplaceAll(text, "\r\n", "\n"), -1) {
+		for i, field := range strings.Fields(paragraph) {
+			words = append(words, word{text: field, paragraph: i == 0})
+		}
+	}
+
+	render := func(words []word) string {
+		var chunk strings.Builder
+		for i, w := range words {
+			if i > 0 {
+				if w.paragraph {
+					chunk.WriteString("\n\n")
+				} else {
+					chunk.WriteString(" ")
+				}
+			}
+			chunk.WriteString(w.text)
+		}
+		return chunk.String()
+	}
+
+	maxChars, overlapChars := maxTokens*4, overlapTokens*4
+	if overlapChars >= maxChars {
+		overlapChars = maxChars / 4
+	}
+
+	var chunks []string
+	start := 0
+	for start < len(words) {
+		end, size := start, 0
+		for end < len(words) && (end == start || size+len(words[end].text)+1 <= maxChars) {
+			size += len(words[end].text) + 1
+			end++
+		}
+		chunks = append(chunks, render(words[start:end]))
+		if end == len(words) {
+			break
+		}
+
+		// step back over the overlap, always moving forward
+		next, overlap := end, 0
+		for next > start+1 && overlap+len(words[next-1].text)+1 <= overlapChars {
+			next--
+			overlap += len(words[next].text) + 1
+		}
+		start = next
+	}
+	return chunks
+}
+
+// SaveDocument stores a document with its chunks and their embeddings
+func SaveDocument(ctx context.Context, document models.AIDocument, chunks []string, embeddings [][]float64) error {
+	records := make([]interface{}, len(chunks))
+	for i, content := range chunks {
+		chunk := models.AIDocumentChunk{
+			ID:              primitive.NewObjectID(),
+			Document_id:     document.Document_id,
+			School_id:       document.School_id,
+			Branch_id:       document.Branch_id,
+			Title:           document.Title,
+			Index:           i,
+			Content:         content,
+			Embedding:       embeddings[i],
+			Embedding_model: document.Embedding_model,
+		}
+		chunk.Chunk_id = chunk.ID.Hex()
+		records[i] = chunk
+	}
+
+	if len(records) > 0 {
+		if _, err := aiDocumentChunkCollection.InsertMany(ctx, records); err != nil {
+			aiDocumentChunkCollection.DeleteMany(ctx, bson.M{"document_id": document.Document_id})
+			return err
+		}
+	}
+	if _, err := aiDocumentCollection.InsertOne(ctx, document); err != nil {
+		aiDocumentChunkCollection.DeleteMany(ctx, bson.M{"document_id": document.Document_id})
+		return err
+	}
+	return nil
+}
+
+// GetDocument returns a document of the branch
+func GetDocument(ctx context.Context, branchId string, documentId string) (models.AIDocument, error) {
+	var document models.AIDocument
+	err := aiDocumentCollection.FindOne(ctx, bson.M{"document_id": documentId, "branch_id": branchId}).Decode(&document)
+	if err == mongo.ErrNoDocuments {
+		return document, ErrDocumentNotFound
+	}
+	return document, err
+}
+
+// ListDocuments returns a page of the branch's documents, newest first
+func ListDocuments(ctx context.Context, branchId string, skip int, limit int) ([]models.AIDocument, int64, error) {
+	filter := bson.M{"branch_id": branchId}
+
+	total, err := aiDocumentCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetSkip(int64(skip)).
+		SetLimit(int64(limit))
+	cursor, err := aiDocumentCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	documents := []models.AIDocument{}
+	if err = cursor.All(ctx, &documents); err != nil {
+		return nil, 0, err
+	}
+	return documents, total, nil
+}
+
+// DeleteDocument deletes a document of the branch and its chunks
+func DeleteDocument(ctx context.Context, branchId string, documentId string) error {
+	result, err := aiDocumentCollection.DeleteOne(ctx, bson.M{"document_id": documentId, "branch_id": branchId})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrDocumentNotFound
+	}
+
+	_, err = aiDocumentChunkCollection.DeleteMany(ctx, bson.M{"document_id": documentId, "branch_id": branchId})
+	return err
+}
+
+// SearchDocumentChunks returns the k chunks of the branch closest to the query embedding, best first, among those
+// embedded with the same model. It uses the Atlas vector search index named by AI_VECTOR_SEARCH_INDEX, or without it
+// compares the query with every chunk of the branch, up to AI_VECTOR_SCAN_LIMIT of them.
+func SearchDocumentChunks(ctx context.Context, branchId string, embeddingModel string, query []float64, k int) ([]models.AICitation, error) {
+	settings := AIDocumentSettingsFromEnv()
+	if settings.VectorIndex != "" {
+		citations, err := vectorSearchChunks(ctx, settings.VectorIndex, branchId, embeddingModel, query, k)
+		if err == nil {
+			return citations, nil
+		}
+		log.Printf("Vector search on %s failed, comparing every chunk instead: %v", settings.VectorIndex, err)
+	}
+
+	cursor, err := aiDocumentChunkCollection.Find(ctx,
+		bson.M{"branch_id": branchId, "embedding_model": embeddingModel},
+		options.Find().SetLimit(int64(settings.ScanLimit)))
+	if err != nil {
+		return nil, err
+	}
+	return scanChunks(ctx, cursor, query, k)
+}
+
+type scoredChunk struct {
+	models.AIDocumentChunk `bson:",inline"`
+	Score                  float64
+}
+
+func vectorSearchChunks(ctx context.Context, index string, branchId string, embeddingModel string, query []float64, k int) ([]models.AICitation, error) {
+	cursor, err := aiDocumentChunkCollection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$vectorSearch", Value: bson.D{
+			{Key: "index", Value: index},
+			{Key: "path", Value: "embedding"},
+			{Key: "queryVector", Value: query},
+			{Key: "numCandidates", Value: k * 20},
+			{Key: "limit", Value: k},
+			{Key: "filter", Value: bson.M{"branch_id": branchId, "embedding_model": embeddingModel}},
+		}}},
+		{{Key: "$project", Value: bson.M{"embedding": 0, "score": bson.M{"$meta": "vectorSearchScore"}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var chunks []scoredChunk
+	if err = cursor.All(ctx, &chunks); err != nil {
+		return nil, err
+	}
+	for i := range chunks {
+		// Atlas scores cosine similarity as (1 + cosine) / 2, scores are reported as the cosine like the scan does
+		chunks[i].Score = 2*chunks[i].Score - 1
+	}
+	return citations(chunks), nil
+}
+
+// chunkCursor is the part of a mongo cursor that scanChunks reads
+type chunkCursor interface {
+	Next(ctx context.Context) bool
+	Decode(val interface{}) error
+	Err() error
+	Close(ctx context.Context) error
+}
+
+// scanChunks keeps the k chunks of the cursor closest to the query, best first
+func scanChunks(ctx context.Context, cursor chunkCursor, query []float64, k int) ([]models.AICitation, error) {
+	defer cursor.Close(ctx)
+
+	var best []scoredChunk
+	for cursor.Next(ctx) {
+		var chunk models.AIDocumentChunk
+		if err := cursor.Decode(&chunk); err != nil {
+			return nil, err
+		}
+		score := cosineSimilarity(query, chunk.Embedding)
+		if len(best) == k && score <= best[k-1].Score {
+			continue
+		}
+
+		chunk.Embedding = nil
+		position := sort.Search(len(best), func(i int) bool { return best[i].Score < score })
+		best = append(best, scoredChunk{})
+		copy(best[position+1:], best[position:])
+		best[position] = scoredChunk{AIDocumentChunk: chunk, Score: score}
+		if len(best) > k {
+			best = best[:k]
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+	return citations(best), nil
+}
+
+func citations(chunks []scoredChunk) []models.AICitation {
+	citations := make([]models.AICitation, len(chunks))
+	for i, chunk := range chunks {
+		citations[i] = models.AICitation{
+			Number:      i + 1,
+			Document_id: chunk.Document_id,
+			Title:       chunk.Title,
+			Chunk_id:    chunk.Chunk_id,
+			Index:       chunk.Index,
+			Score:       math.Round(chunk.Score*1000) / 1000,
+			Content:     chunk.Content,
+		}
+	}
+	return citations
+}
+
+// cosineSimilarity is 0 for vectors of different lengths, which come from different models
+func cosineSimilarity(a []float64, b []float64) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+
+	var dot, normA, normB float64
+	for i := range a {
+		dot += a[i] * b[i]
+		normA += a[i] * a[i]
+		normB += b[i] * b[i]
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+// AskMessages asks the model to answer the question from the numbered passages
+func AskMessages(question string, citations []models.AICitation) []models.AIMessage {
+	var sources strings.Builder
+	for _, citation := range citations {
+		fmt.Fprintf(&sources, "[%d] %s\n%s\n\n", citation.Number, citation.Title, citation.Content)
+	}
+
+	return []models.AIMessage{
+		{Role: "system", Content: askSystemPrompt},
+		{Role: "user", Content: "Sources:\n\n" + sources.String() + "Question: " + question},
+	}
+}
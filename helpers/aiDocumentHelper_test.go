@@ -0,0 +1,209 @@
+package helper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gambl/models"
+)
+
+func TestChunkText(t *testing.T) {
+	longWord := strings.Repeat("x", 30)
+
+	tests := []struct {
+		name          string
+		text          string
+		maxTokens     int
+		overlapTokens int
+		want          []string
+	}{
+		{name: "empty", text: " \n ", maxTokens: 10, overlapTokens: 2, want: nil},
+		{name: "one chunk", text: "The water cycle.", maxTokens: 10, overlapTokens: 2, want: []string{"The water cycle."}},
+		{name: "paragraphs are kept", text: "First part.\r\n\r\nSecond  part.", maxTokens: 100, overlapTokens: 10, want: []string{"First part.\n\nSecond part."}},
+		{
+			name:          "chunks start with the overlap",
+			text:          "one two three four five six seven",
+			maxTokens:     3,
+			overlapTokens: 1,
+			want:          []string{"one two", "two three", "four five", "six seven"},
+		},
+		{
+			name:          "a word longer than a chunk",
+			text:          "a " + longWord + " b",
+			maxTokens:     2,
+			overlapTokens: 1,
+			want:          []string{"a", longWord, "b"},
+		},
+		{
+			name:          "overlap as large as the chunk",
+			text:          "aaa bbb ccc ddd eee",
+			maxTokens:     2,
+			overlapTokens: 5,
+			want:          []string{"aaa bbb", "ccc ddd", "eee"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ChunkText(tt.text, tt.maxTokens, tt.overlapTokens)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ChunkText() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChunkTextMakesProgress(t *testing.T) {
+	var words []string
+	for i := 0; i < 500; i++ {
+		words = append(words, fmt.Sprintf("w%d", i))
+	}
+	text := strings.Join(words, " ")
+
+	for _, sizes := range [][2]int{{5, 0}, {5, 2}, {5, 5}, {5, 50}, {50, 10}, {1, 1}} {
+		chunks := ChunkText(text, sizes[0], sizes[1])
+		if len(chunks) > len(words) {
+			t.Fatalf("ChunkText(%d, %d) made %d chunks of %d words", sizes[0], sizes[1], len(chunks), len(words))
+		}
+
+		// every chunk starts after the start of the previous one and the last one ends the text
+		previous := -1
+		for i, chunk := range chunks {
+			first := strings.Fields(chunk)[0]
+			var index int
+			fmt.Sscanf(first, "w%d", &index)
+			if index <= previous {
+				t.Fatalf("ChunkText(%d, %d) chunk %d starts at word %d, after %d", sizes[0], sizes[1], i, index, previous)
+			}
+			previous = index
+		}
+		if !strings.HasSuffix(chunks[len(chunks)-1], "w499") {
+			t.Errorf("ChunkText(%d, %d) lost the end of the text", sizes[0], sizes[1])
+		}
+	}
+}
+
+func TestCosineSimilarity(t *testing.T) {
+	tests := []struct {
+		name string
+		a    []float64
+		b    []float64
+		want float64
+	}{
+		{name: "same", a: []float64{1, 2, 3}, b: []float64{1, 2, 3}, want: 1},
+		{name: "scaled", a: []float64{1, 2, 3}, b: []float64{2, 4, 6}, want: 1},
+		{name: "opposite", a: []float64{1, 0}, b: []float64{-1, 0}, want: -1},
+		{name: "orthogonal", a: []float64{1, 0}, b: []float64{0, 1}, want: 0},
+		{name: "diagonal", a: []float64{1, 0}, b: []float64{1, 1}, want: math.Sqrt2 / 2},
+		{name: "different lengths", a: []float64{1, 0}, b: []float64{1, 0, 0}, want: 0},
+		{name: "empty", a: []float64{}, b: []float64{}, want: 0},
+		{name: "zero vector", a: []float64{0, 0}, b: []float64{1, 1}, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cosineSimilarity(tt.a, tt.b); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("cosineSimilarity() = %f, want %f", got, tt.want)
+			}
+		})
+	}
+}
+
+// fakeChunkCursor returns chunks like a mongo cursor and reports err once they run out
+type fakeChunkCursor struct {
+	chunks    []models.AIDocumentChunk
+	position  int
+	decodeErr error
+	err       error
+	closed    bool
+}
+
+func (c *fakeChunkCursor) Next(ctx context.Context) bool {
+	if c.position >= len(c.chunks) {
+		return false
+	}
+	c.position++
+	return true
+}
+
+func (c *fakeChunkCursor) Decode(val interface{}) error {
+	if c.decodeErr != nil {
+		return c.decodeErr
+	}
+	*val.(*models.AIDocumentChunk) = c.chunks[c.position-1]
+	return nil
+}
+
+func (c *fakeChunkCursor) Err() error {
+	return c.err
+}
+
+func (c *fakeChunkCursor) Close(ctx context.Context) error {
+	c.closed = true
+	return nil
+}
+
+func TestScanChunks(t *testing.T) {
+	chunks := []models.AIDocumentChunk{
+		{Chunk_id: "orthogonal", Embedding: []float64{0, 1}},
+		{Chunk_id: "diagonal", Embedding: []float64{1, 1}},
+		{Chunk_id: "same", Embedding: []float64{1, 0}},
+		{Chunk_id: "opposite", Embedding: []float64{-1, 0}},
+		{Chunk_id: "close", Embedding: []float64{3, 1}},
+		{Chunk_id: "other model", Embedding: []float64{1, 0, 0}},
+		{Chunk_id: "same again", Embedding: []float64{2, 0}},
+	}
+	query := []float64{1, 0}
+	failure := errors.New("cursor failed")
+
+	tests := []struct {
+		name      string
+		k         int
+		decodeErr error
+		err       error
+		want      []string
+		wantErr   error
+	}{
+		{name: "top k best first, ties in scan order", k: 3, want: []string{"same", "same again", "close"}},
+		{name: "k of one", k: 1, want: []string{"same"}},
+		{name: "k larger than the chunks", k: 10, want: []string{"same", "same again", "close", "diagonal", "orthogonal", "other model", "opposite"}},
+		{name: "cursor error", k: 3, err: failure, wantErr: failure},
+		{name: "decode error", k: 3, decodeErr: failure, wantErr: failure},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cursor := &fakeChunkCursor{chunks: chunks, decodeErr: tt.decodeErr, err: tt.err}
+
+			citations, err := scanChunks(context.Background(), cursor, query, tt.k)
+			if !cursor.closed {
+				t.Error("the cursor was not closed")
+			}
+			if err != tt.wantErr {
+				t.Fatalf("scanChunks() error = %v, want %v", err, tt.wantErr)
+			}
+
+			var got []string
+			for i, citation := range citations {
+				got = append(got, citation.Chunk_id)
+				if citation.Number != i+1 {
+					t.Errorf("citation %s is numbered %d, want %d", citation.Chunk_id, citation.Number, i+1)
+				}
+				if i > 0 && citation.Score > citations[i-1].Score {
+					t.Errorf("citation %s scores %f, more than the one before", citation.Chunk_id, citation.Score)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("scanChunks() = %q, want %q", got, tt.want)
+			}
+			if len(citations) > 2 && citations[2].Score != 0.949 {
+				t.Errorf("score of %s = %f, want it rounded to 0.949", citations[2].Chunk_id, citations[2].Score)
+			}
+		})
+	}
+}
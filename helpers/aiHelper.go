@@ -0,0 +1,157 @@
+package helper
+
+import (
+	"context"
+	"log"
+	"time"
+
+	config "gambl/config"
+	"gambl/database"
+	"gambl/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var aiChatCollection *mongo.Collection = database.OpenCollection(database.Client, "ai_chats")
+
+// Statuses of a recorded AI chat. An INVALID chat is a structured answer that did not match its schema.
+const (
+	AIChatCompleted = "COMPLETED"
+	AIChatCancelled = "CANCELLED"
+	AIChatFailed    = "FAILED"
+	AIChatInvalid   = "INVALID"
+)
+
+// CreateAIIndexes lets chats and conversations be listed per user, and chats per branch
+func CreateAIIndexes() {
+	database.CreateIndexes(aiChatCollection,
+		mongo.IndexModel{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+		mongo.IndexModel{Keys: bson.D{{Key: "branch_id", Value: 1}, {Key: "created_at", Value: -1}}},
+	)
+	database.CreateIndexes(aiConversationCollection,
+		mongo.IndexModel{Keys: bson.D{{Key: "conversation_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		mongo.IndexModel{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "updated_at", Value: -1}}},
+	)
+}
+
+// RecordAIChat stores the prompt of the caller and the answer, complete or not, and adds its tokens to the usage
+// counters. A stream that ended without reporting its usage is counted from the prompt and the text it sent. It runs
+// on its own context so an answer interrupted by the client is still recorded, and failures are only logged.
+func RecordAIChat(c *gin.Context, conversationId string, prompt string, response models.AIChatResponse, status string, streamed bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	chat := models.AIChat{
+		ID:              primitive.NewObjectID(),
+		Conversation_id: conversationId,
+		User_id:         c.GetString("uid"),
+		School_id:       c.GetString("school_id"),
+		Branch_id:       c.GetString("branch_id"),
+		Model:           response.Model,
+		Prompt:          prompt,
+		Content:         response.Content,
+		Finish_reason:   response.Finish_reason,
+		Status:          status,
+		Streamed:        streamed,
+		Usage:           response.Usage,
+		Created_at:      time.Now().UTC(),
+	}
+	chat.Chat_id = chat.ID.Hex()
+	if streamed && chat.Usage.Total_tokens == 0 {
+		chat.Usage = estimateAIUsage(prompt, response.Content)
+	}
+
+	if _, err := aiChatCollection.InsertOne(ctx, chat); err != nil {
+		log.Printf("Error recording the AI chat of %s: %v", chat.User_id, err)
+	}
+
+	RecordAIRequestUsage(c, chat.Usage)
+}
+
+// estimateAIUsage approximates the usage of a request the provider did not report
+func estimateAIUsage(prompt string, content string) models.AIUsage {
+	usage := models.AIUsage{Prompt_tokens: EstimateTokens(prompt)}
+	if content != "" {
+		usage.Completion_tokens = EstimateTokens(content)
+	}
+	usage.Total_tokens = usage.Prompt_tokens + usage.Completion_tokens
+	return usage
+}
+
+const aiReservationKey = "ai_reservation"
+
+// ReserveAIRequest reserves tokens on the quotas of the caller and their branch for the next model request, in
+// place of what is still reserved for them. RecordAIRequestUsage settles it with the tokens the request used.
+func ReserveAIRequest(c *gin.Context, tokens int) error {
+	ReleaseAIRequest(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	reservation, err := ReserveAIQuota(ctx, AIUsageOwnerFromContext(c), int64(tokens))
+	if err != nil {
+		return err
+	}
+	c.Set(aiReservationKey, reservation)
+	return nil
+}
+
+// ReserveAIChatRequest reserves what the request can use before it is sent: its messages and its max_tokens
+func ReserveAIChatRequest(c *gin.Context, request config.ChatRequest) error {
+	return ReserveAIRequest(c, EstimateChatRequestTokens(request))
+}
+
+// EstimateChatRequestTokens approximates the most tokens a request can use, its messages and the longest answer
+func EstimateChatRequestTokens(request config.ChatRequest) int {
+	tokens := request.MaxTokens
+	for _, message := range request.Messages {
+		tokens += EstimateTokens(message.Content)
+	}
+	return tokens
+}
+
+// ReleaseAIRequest gives back the tokens still reserved for the caller, when the request ends without using them
+func ReleaseAIRequest(c *gin.Context) {
+	reservation := takeAIReservation(c)
+	if reservation == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := reservation.Release(ctx); err != nil {
+		log.Printf("Error releasing the AI quota of %s: %v", c.GetString("uid"), err)
+	}
+}
+
+// RecordAIRequestUsage settles the caller's reservation with the tokens a model request used, or adds them to the
+// usage counters when nothing was reserved. Failures are only logged.
+func RecordAIRequestUsage(c *gin.Context, usage models.AIUsage) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var err error
+	if reservation := takeAIReservation(c); reservation != nil {
+		err = reservation.Settle(ctx, usage)
+	} else {
+		err = RecordAIUsage(ctx, AIUsageOwnerFromContext(c), usage)
+	}
+	if err != nil {
+		log.Printf("Error recording the AI usage of %s: %v", c.GetString("uid"), err)
+	}
+}
+
+func takeAIReservation(c *gin.Context) *AIReservation {
+	value, ok := c.Get(aiReservationKey)
+	if !ok {
+		return nil
+	}
+	c.Set(aiReservationKey, nil)
+	reservation, _ := value.(*AIReservation)
+	return reservation
+}
@@ -0,0 +1,382 @@
+package helper
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"gambl/database"
+	"gambl/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var aiUsageCollection *mongo.Collection = database.OpenCollection(database.Client, "ai_usage")
+
+// Scopes and periods of AI usage counters
+const (
+	AIUsageUser   = "user"
+	AIUsageBranch = "branch"
+	AIUsageDay    = "day"
+	AIUsageMonth  = "month"
+)
+
+// AIQuota is a token budget per day and per month, zero is unlimited
+type AIQuota struct {
+	Daily   int64 `json:"daily"`
+	Monthly int64 `json:"monthly"`
+}
+
+// AIQuotaConfig is read from the AI_QUOTAS JSON. A user gets the most generous quota among their user type and
+// roles, or the default when none is listed. Branches get their own quota or the branch one.
+type AIQuotaConfig struct {
+	Default    AIQuota            `json:"default"`
+	User_types map[string]AIQuota `json:"user_types"`
+	Roles      map[string]AIQuota `json:"roles"`
+	Branch     AIQuota            `json:"branch"`
+	Branches   map[string]AIQuota `json:"branches"`
+}
+
+var aiQuotas = loadAIQuotas()
+
+func loadAIQuotas() AIQuotaConfig {
+	var quotas AIQuotaConfig
+	if value := os.Getenv("AI_QUOTAS"); value != "" {
+		if err := json.Unmarshal([]byte(value), &quotas); err != nil {
+			log.Fatalf("AI_QUOTAS is not valid JSON: %v", err)
+		}
+	}
+	return quotas
+}
+
+// AIUsageOwner is who an AI request is accounted to, their user type and roles pick their quota
+type AIUsageOwner struct {
+	User_id   string
+	School_id string
+	Branch_id string
+	User_type string
+	Roles     []string
+}
+
+// AIUsageOwnerFromContext accounts a request to the caller and their home branch. The branch a request acts in is
+// picked with a header, so it does not decide whose quota pays.
+func AIUsageOwnerFromContext(c *gin.Context) AIUsageOwner {
+	return AIUsageOwner{
+		User_id:   c.GetString("uid"),
+		School_id: c.GetString("school_id"),
+		Branch_id: c.GetString("home_branch_id"),
+		User_type: c.GetString("user_type"),
+		Roles:     c.GetStringSlice("roles"),
+	}
+}
+
+// AIQuotaStatus is the usage of a scope in the current period against its limit, a zero Limit is unlimited
+type AIQuotaStatus struct {
+	Scope    string    `json:"scope"`
+	Period   string    `json:"period"`
+	Used     int64     `json:"used"`
+	Limit    int64     `json:"limit"`
+	Reset_at time.Time `json:"reset_at"`
+}
+
+// AIQuotaExceededError is returned when a quota has no tokens left
+type AIQuotaExceededError struct {
+	AIQuotaStatus
+}
+
+func (e *AIQuotaExceededError) Error() string {
+	return fmt.Sprintf("the %s %s AI quota of %d tokens is used up until %s", e.Scope, e.Period, e.Limit, e.Reset_at.Format(time.RFC3339))
+}
+
+// CreateAIUsageIndexes keeps one counter per scope and period, and lets mongo drop old counters
+func CreateAIUsageIndexes() {
+	database.CreateIndexes(aiUsageCollection,
+		mongo.IndexModel{
+			Keys:    bson.D{{Key: "scope", Value: 1}, {Key: "scope_id", Value: 1}, {Key: "period", Value: 1}, {Key: "period_start", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		mongo.IndexModel{Keys: bson.D{{Key: "period", Value: 1}, {Key: "period_start", Value: 1}, {Key: "school_id", Value: 1}, {Key: "total_tokens", Value: -1}}},
+		mongo.IndexModel{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	)
+}
+
+// AIPeriodStart returns the start of the UTC day or month containing t
+func AIPeriodStart(t time.Time, period string) time.Time {
+	t = t.UTC()
+	if period == AIUsageMonth {
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func aiPeriodEnd(start time.Time, period string) time.Time {
+	if period == AIUsageMonth {
+		return start.AddDate(0, 1, 0)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+// RecordAIUsage adds the tokens of a request to the day and month counters of the user and their branch
+func RecordAIUsage(ctx context.Context, owner AIUsageOwner, usage models.AIUsage) error {
+	_, err := aiUsageCollection.BulkWrite(ctx, aiUsageWrites(owner, usage, time.Now().UTC()), options.BulkWrite().SetOrdered(false))
+	return err
+}
+
+func aiUsageWrites(owner AIUsageOwner, usage models.AIUsage, now time.Time) []mongo.WriteModel {
+	var writes []mongo.WriteModel
+	for _, counter := range aiUsageCounters(owner, now) {
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(counter.filter).
+			SetUpdate(bson.M{
+				"$inc": bson.M{
+					"prompt_tokens":     usage.Prompt_tokens,
+					"completion_tokens": usage.Completion_tokens,
+					"total_tokens":      usage.Total_tokens,
+					"requests":          1,
+				},
+				"$set": counter.set,
+			}).
+			SetUpsert(true))
+	}
+	return writes
+}
+
+// aiUsageCounter is a counter of the owner in the current period with the quota it is held to
+type aiUsageCounter struct {
+	status AIQuotaStatus
+	filter bson.M
+	set    bson.M
+}
+
+// aiUsageCounters returns the day and month counters of the user and their branch
+func aiUsageCounters(owner AIUsageOwner, now time.Time) []aiUsageCounter {
+	userQuota := aiUserQuota(owner.User_type, owner.Roles)
+	branchQuota := aiQuotas.Branch
+	if quota, ok := aiQuotas.Branches[owner.Branch_id]; ok {
+		branchQuota = quota
+	}
+
+	var counters []aiUsageCounter
+	for _, period := range []string{AIUsageDay, AIUsageMonth} {
+		start := AIPeriodStart(now, period)
+		reset := aiPeriodEnd(start, period)
+		// counters are kept for a year after their period for the reports
+		set := bson.M{"school_id": owner.School_id, "branch_id": owner.Branch_id, "expires_at": reset.AddDate(1, 0, 0)}
+
+		counters = append(counters, aiUsageCounter{
+			status: AIQuotaStatus{Scope: AIUsageUser, Period: period, Limit: userQuota.limit(period), Reset_at: reset},
+			filter: bson.M{"scope": AIUsageUser, "scope_id": owner.User_id, "period": period, "period_start": start},
+			set:    set,
+		})
+		if owner.Branch_id != "" {
+			counters = append(counters, aiUsageCounter{
+				status: AIQuotaStatus{Scope: AIUsageBranch, Period: period, Limit: branchQuota.limit(period), Reset_at: reset},
+				filter: bson.M{"scope": AIUsageBranch, "scope_id": owner.Branch_id, "period": period, "period_start": start},
+				set:    set,
+			})
+		}
+	}
+	return counters
+}
+
+// AIQuotaStatuses returns the usage of the user and their branch in the current day and month against their quotas
+func AIQuotaStatuses(ctx context.Context, owner AIUsageOwner) ([]AIQuotaStatus, error) {
+	var statuses []AIQuotaStatus
+	var conditions []bson.M
+	for _, counter := range aiUsageCounters(owner, time.Now().UTC()) {
+		statuses = append(statuses, counter.status)
+		conditions = append(conditions, counter.filter)
+	}
+
+	cursor, err := aiUsageCollection.Find(ctx, bson.M{"$or": conditions})
+	if err != nil {
+		return nil, err
+	}
+	var counters []models.AIUsageCounter
+	if err := cursor.All(ctx, &counters); err != nil {
+		return nil, err
+	}
+
+	for _, counter := range counters {
+		for i := range statuses {
+			if statuses[i].Scope == counter.Scope && statuses[i].Period == counter.Period {
+				statuses[i].Used = counter.Total_tokens
+			}
+		}
+	}
+	return statuses, nil
+}
+
+// AIReservation is the tokens held on the quota counters of an owner while a request runs, until it is settled
+// with the tokens the request used or released
+type AIReservation struct {
+	owner    AIUsageOwner
+	tokens   int64
+	counters []bson.M
+}
+
+// ReserveAIQuota adds tokens to every limited counter of the user and their branch, each in one conditional update,
+// so concurrent requests cannot all pass a check and then go over together. When a quota has fewer tokens left it
+// fails with an AIQuotaExceededError and holds nothing.
+func ReserveAIQuota(ctx context.Context, owner AIUsageOwner, tokens int64) (*AIReservation, error) {
+	reservation := &AIReservation{owner: owner, tokens: tokens}
+
+	counters := aiUsageCounters(owner, time.Now().UTC())
+	// month counters first, the error names the quota that resets last, which is the one the caller has to wait for
+	for i := len(counters) - 1; i >= 0; i-- {
+		counter := counters[i]
+		if counter.status.Limit == 0 {
+			continue
+		}
+		if err := reserveAIUsageCounter(ctx, counter, tokens); err != nil {
+			if releaseErr := reservation.Release(ctx); releaseErr != nil {
+				log.Printf("Error releasing the AI quota of %s: %v", owner.User_id, releaseErr)
+			}
+			return nil, err
+		}
+		reservation.counters = append(reservation.counters, counter.filter)
+	}
+	return reservation, nil
+}
+
+// reserveAIUsageCounter adds tokens to a counter as long as it stays within its limit. A counter that would go over
+// does not match, so the upsert tries to create it and fails on the unique index. The first requests of a period
+// race to create the counter the same way, so the update is tried once more before the quota counts as used up.
+func reserveAIUsageCounter(ctx context.Context, counter aiUsageCounter, tokens int64) error {
+	if tokens <= counter.status.Limit {
+		filter := bson.M{"total_tokens": bson.M{"$lte": counter.status.Limit - tokens}}
+		for key, value := range counter.filter {
+			filter[key] = value
+		}
+		update := bson.M{"$inc": bson.M{"total_tokens": tokens}, "$set": counter.set}
+
+		for attempt := 0; attempt < 2; attempt++ {
+			_, err := aiUsageCollection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+			if !database.IsDuplicateKeyError(err) {
+				return err
+			}
+		}
+	}
+
+	exceeded := &AIQuotaExceededError{counter.status}
+	var current models.AIUsageCounter
+	if err := aiUsageCollection.FindOne(ctx, counter.filter).Decode(&current); err == nil {
+		exceeded.Used = current.Total_tokens
+	}
+	return exceeded
+}
+
+// Settle replaces the reserved tokens with the usage of the request
+func (r *AIReservation) Settle(ctx context.Context, usage models.AIUsage) error {
+	writes := append(r.releaseWrites(), aiUsageWrites(r.owner, usage, time.Now().UTC())...)
+	r.counters = nil
+
+	_, err := aiUsageCollection.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+	return err
+}
+
+// Release gives the reserved tokens back, for a request that never reached the model
+func (r *AIReservation) Release(ctx context.Context) error {
+	writes := r.releaseWrites()
+	r.counters = nil
+	if len(writes) == 0 {
+		return nil
+	}
+
+	_, err := aiUsageCollection.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+	return err
+}
+
+// releaseWrites takes the tokens off the counters they were reserved on, which may belong to a period that has
+// ended since
+func (r *AIReservation) releaseWrites() []mongo.WriteModel {
+	var writes []mongo.WriteModel
+	for _, filter := range r.counters {
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(filter).
+			SetUpdate(bson.M{"$inc": bson.M{"total_tokens": -r.tokens}}))
+	}
+	return writes
+}
+
+// AIUsageReport returns the users of a school, or of every school when schoolId is empty, by tokens used in a
+// period, with the totals of their branches
+func AIUsageReport(ctx context.Context, schoolId string, period string, start time.Time, skip int, limit int) (users []models.AIUsageCounter, total int64, branches []models.AIUsageCounter, err error) {
+	filter := bson.M{"scope": AIUsageUser, "period": period, "period_start": start}
+	if schoolId != "" {
+		filter["school_id"] = schoolId
+	}
+
+	total, err = aiUsageCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		return
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "total_tokens", Value: -1}}).
+		SetSkip(int64(skip)).
+		SetLimit(int64(limit))
+	cursor, err := aiUsageCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return
+	}
+	users = []models.AIUsageCounter{}
+	if err = cursor.All(ctx, &users); err != nil {
+		return
+	}
+
+	filter["scope"] = AIUsageBranch
+	cursor, err = aiUsageCollection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "total_tokens", Value: -1}}))
+	if err != nil {
+		return
+	}
+	branches = []models.AIUsageCounter{}
+	err = cursor.All(ctx, &branches)
+	return
+}
+
+// aiUserQuota picks the most generous quota among the user type and roles, or the default when none is listed
+func aiUserQuota(userType string, roles []string) AIQuota {
+	var matches []AIQuota
+	if quota, ok := aiQuotas.User_types[userType]; ok {
+		matches = append(matches, quota)
+	}
+	for _, role := range roles {
+		if quota, ok := aiQuotas.Roles[role]; ok {
+			matches = append(matches, quota)
+		}
+	}
+	if len(matches) == 0 {
+		return aiQuotas.Default
+	}
+
+	quota := matches[0]
+	for _, match := range matches[1:] {
+		quota.Daily = moreGenerous(quota.Daily, match.Daily)
+		quota.Monthly = moreGenerous(quota.Monthly, match.Monthly)
+	}
+	return quota
+}
+
+func moreGenerous(a int64, b int64) int64 {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func (q AIQuota) limit(period string) int64 {
+	if period == AIUsageMonth {
+		return q.Monthly
+	}
+	return q.Daily
+}
@@ -0,0 +1,178 @@
+package helper
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	config "gambl/config"
+	"gambl/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func useAIQuotas(t *testing.T, quotas AIQuotaConfig) {
+	previous := aiQuotas
+	aiQuotas = quotas
+	t.Cleanup(func() { aiQuotas = previous })
+}
+
+func TestEstimateAIUsage(t *testing.T) {
+	tests := []struct {
+		name    string
+		prompt  string
+		content string
+		want    models.AIUsage
+	}{
+		{name: "prompt and partial answer", prompt: "What is photosynthesis?", content: "Plants make food", want: models.AIUsage{Prompt_tokens: 9, Completion_tokens: 8, Total_tokens: 17}},
+		{name: "nothing streamed", prompt: "What is photosynthesis?", content: "", want: models.AIUsage{Prompt_tokens: 9, Total_tokens: 9}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := estimateAIUsage(tt.prompt, tt.content); got != tt.want {
+				t.Errorf("estimateAIUsage() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAIUsageCounters(t *testing.T) {
+	useAIQuotas(t, AIQuotaConfig{
+		Default:    AIQuota{Daily: 1000, Monthly: 20000},
+		User_types: map[string]AIQuota{"TEACHER": {Daily: 5000}},
+		Branch:     AIQuota{Monthly: 100000},
+		Branches:   map[string]AIQuota{"b2": {Daily: 50000}},
+	})
+	now := time.Date(2024, 3, 15, 10, 0, 0, 0, time.UTC)
+	day := time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC)
+	month := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		owner  AIUsageOwner
+		limits map[string]int64
+	}{
+		{
+			name:   "default user and branch quota",
+			owner:  AIUsageOwner{User_id: "u1", Branch_id: "b1"},
+			limits: map[string]int64{"user day": 1000, "user month": 20000, "branch day": 0, "branch month": 100000},
+		},
+		{
+			name:   "user type and own branch quota",
+			owner:  AIUsageOwner{User_id: "u1", Branch_id: "b2", User_type: "TEACHER"},
+			limits: map[string]int64{"user day": 5000, "user month": 0, "branch day": 50000, "branch month": 0},
+		},
+		{
+			name:   "no branch",
+			owner:  AIUsageOwner{User_id: "u1"},
+			limits: map[string]int64{"user day": 1000, "user month": 20000},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counters := aiUsageCounters(tt.owner, now)
+			if len(counters) != len(tt.limits) {
+				t.Fatalf("%d counters, want %d", len(counters), len(tt.limits))
+			}
+
+			for _, counter := range counters {
+				key := counter.status.Scope + " " + counter.status.Period
+				if counter.status.Limit != tt.limits[key] {
+					t.Errorf("%s limit = %d, want %d", key, counter.status.Limit, tt.limits[key])
+				}
+
+				scopeId, start, reset := tt.owner.User_id, day, day.AddDate(0, 0, 1)
+				if counter.status.Scope == AIUsageBranch {
+					scopeId = tt.owner.Branch_id
+				}
+				if counter.status.Period == AIUsageMonth {
+					start, reset = month, month.AddDate(0, 1, 0)
+				}
+				want := bson.M{"scope": counter.status.Scope, "scope_id": scopeId, "period": counter.status.Period, "period_start": start}
+				if len(counter.filter) != len(want) || counter.filter["scope_id"] != scopeId || counter.filter["period_start"] != start {
+					t.Errorf("%s filter = %v, want %v", key, counter.filter, want)
+				}
+				if !counter.status.Reset_at.Equal(reset) || counter.set["expires_at"] != reset.AddDate(1, 0, 0) {
+					t.Errorf("%s resets at %v and expires at %v", key, counter.status.Reset_at, counter.set["expires_at"])
+				}
+			}
+		})
+	}
+}
+
+func TestAIReservationReleaseWrites(t *testing.T) {
+	filters := []bson.M{
+		{"scope": AIUsageUser, "scope_id": "u1", "period": AIUsageDay},
+		{"scope": AIUsageBranch, "scope_id": "b1", "period": AIUsageDay},
+	}
+	reservation := &AIReservation{owner: AIUsageOwner{User_id: "u1"}, tokens: 512, counters: filters}
+
+	writes := reservation.releaseWrites()
+	if len(writes) != len(filters) {
+		t.Fatalf("%d release writes, want %d", len(writes), len(filters))
+	}
+	for i, write := range writes {
+		update := write.(*mongo.UpdateOneModel)
+		if update.Upsert != nil && *update.Upsert {
+			t.Errorf("release %d creates counters", i)
+		}
+		if inc := update.Update.(bson.M)["$inc"].(bson.M); inc["total_tokens"] != int64(-512) {
+			t.Errorf("release %d increments by %v, want -512", i, inc["total_tokens"])
+		}
+	}
+
+	// a reservation without limited quotas has nothing to give back
+	if err := (&AIReservation{tokens: 512}).Release(context.Background()); err != nil {
+		t.Errorf("Release() error = %v", err)
+	}
+}
+
+func TestTakeAIReservation(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	if takeAIReservation(c) != nil {
+		t.Error("a request without a reservation returned one")
+	}
+
+	reservation := &AIReservation{tokens: 512}
+	c.Set(aiReservationKey, reservation)
+	if got := takeAIReservation(c); got != reservation {
+		t.Errorf("takeAIReservation() = %v, want the reservation", got)
+	}
+	if got := takeAIReservation(c); got != nil {
+		t.Errorf("the reservation was taken twice, got %v", got)
+	}
+}
+
+func TestAIUsageOwnerFromContext(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Set("uid", "u1")
+	c.Set("school_id", "s1")
+	c.Set("home_branch_id", "b1")
+	c.Set("branch_id", "b2")
+
+	// the branch picked with X-Branch-Id does not pay for the request
+	if owner := AIUsageOwnerFromContext(c); owner.Branch_id != "b1" || owner.User_id != "u1" || owner.School_id != "s1" {
+		t.Errorf("AIUsageOwnerFromContext() = %+v, want user u1 of school s1 in home branch b1", owner)
+	}
+}
+
+func TestEstimateChatRequestTokens(t *testing.T) {
+	request := config.ChatRequest{
+		Messages: []models.AIMessage{
+			{Role: "system", Content: strings.Repeat("s", 400)},
+			{Role: "user", Content: "What is photosynthesis?"},
+		},
+		MaxTokens: 512,
+	}
+
+	// 104 for the system prompt, 9 for the question and the longest answer
+	if got := EstimateChatRequestTokens(request); got != 625 {
+		t.Errorf("EstimateChatRequestTokens() = %d, want 625", got)
+	}
+}
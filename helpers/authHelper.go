@@ -3,12 +3,10 @@ package helper
 import (
 	"errors"
 	"gambl/database"
-	"gambl/models"
 
 	// "net/http"
 
 	"github.com/gin-gonic/gin"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
@@ -27,36 +25,18 @@ func CheckUserType(c *gin.Context, role string) (err error) {
 	return err
 }
 
+// RoleTypeCheck checks that the role grants the permission in the branch
 func RoleTypeCheck(c *gin.Context, role string, branch_id string, resource string) (err error) {
-	// userType := c.GetString("user_type")
-	err = nil
-	var roleDTO models.RolesDTO
-	err = rolesCollection.FindOne(c, bson.M{"branch_id": branch_id, "role_name": role}).Decode(&roleDTO)
-
+	allowed, err := RolesHavePermission(c, branch_id, []string{role}, resource)
 	if err != nil {
 		return err
 	}
 
-	listOfPermissions := roleDTO.Permissions
-
-	var isPresent bool
-
-	isPresent = false
-
-	for _, item := range listOfPermissions {
-
-		if resource == item {
-			isPresent = true
-		}
-	}
-
-	println(isPresent)
-
-	if !isPresent {
+	if !allowed {
 		return errors.New("current role not allowed")
 	}
 
-	return err
+	return nil
 }
 
 // MatchUserTypeToUid only allows the user to access their data and no other data. Only the admin can access all user data
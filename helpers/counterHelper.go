@@ -0,0 +1,38 @@
+package helper
+
+import (
+	"context"
+	"fmt"
+
+	"gambl/database"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var counterCollection *mongo.Collection = database.OpenCollection(database.Client, "counters")
+
+// NextSequence atomically increments the named counter and returns its new value, starting at 1
+func NextSequence(ctx context.Context, name string) (int64, error) {
+	var counter struct {
+		Seq int64 `bson:"seq"`
+	}
+
+	err := counterCollection.FindOneAndUpdate(ctx,
+		bson.M{"_id": name},
+		bson.M{"$inc": bson.M{"seq": 1}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&counter)
+
+	return counter.Seq, err
+}
+
+// GenerateStaffID returns the next staff id of a school, numbered from STF-00001 per school
+func GenerateStaffID(ctx context.Context, schoolId string) (string, error) {
+	seq, err := NextSequence(ctx, "staff_id:"+schoolId)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("STF-%05d", seq), nil
+}
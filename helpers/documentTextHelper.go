@@ -0,0 +1,307 @@
+package helper
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"log"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Types of uploaded documents
+const (
+	PDFDocument      = "PDF"
+	TextDocument     = "TEXT"
+	MarkdownDocument = "MARKDOWN"
+)
+
+var (
+	ErrUnsupportedDocument = errors.New("only PDF, text and markdown documents are supported")
+	ErrDocumentHasNoText   = errors.New("no text could be read from the document, scanned PDFs and PDFs whose fonts have no text mapping are not supported")
+	ErrDocumentUnreadable  = errors.New("the document could not be read, it may be damaged")
+)
+
+// DocumentType tells the type of an upload from its extension, or its content when the extension is unknown
+func DocumentType(filename string, content []byte) (string, error) {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".pdf":
+		return PDFDocument, nil
+	case ".md", ".markdown":
+		return MarkdownDocument, nil
+	case ".txt", ".text":
+		return TextDocument, nil
+	}
+
+	if bytes.HasPrefix(content, []byte("%PDF-")) {
+		return PDFDocument, nil
+	}
+	if utf8.Valid(content) {
+		return TextDocument, nil
+	}
+	return "", ErrUnsupportedDocument
+}
+
+// ExtractDocumentText returns the text of a document. Text and markdown are kept as they are, PDFs go through
+// ExtractPDFText.
+func ExtractDocumentText(documentType string, content []byte) (text string, err error) {
+	// a malformed upload must not take the server down with it
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			log.Printf("Error reading a %s document: %v", documentType, recovered)
+			text, err = "", ErrDocumentUnreadable
+		}
+	}()
+
+	switch documentType {
+	case PDFDocument:
+		text = ExtractPDFText(content)
+		if !readableText(text) {
+			return "", ErrDocumentHasNoText
+		}
+	case TextDocument, MarkdownDocument:
+		content = bytes.TrimPrefix(content, []byte("\xef\xbb\xbf"))
+		if !utf8.Valid(content) {
+			return "", errors.New("text documents must be UTF-8 encoded")
+		}
+		text = string(content)
+	default:
+		return "", ErrUnsupportedDocument
+	}
+
+	if strings.TrimSpace(text) == "" {
+		return "", ErrDocumentHasNoText
+	}
+	return text, nil
+}
+
+// ExtractPDFText reads the strings shown by the text operators of a PDF's content streams, page by page and then
+// the streams outside the page tree such as forms, inflating the Flate compressed ones. Strings shown in a font with
+// a ToUnicode map, such as the Type0 fonts of most word processors and ligatures of simple fonts, are read through
+// it, others as Latin-1. Fonts with their own encodings and no map come out garbled and scanned pages have no text at
+// all, readableText tells them apart.
+func ExtractPDFText(content []byte) string {
+	document := readPDF(content)
+	var text strings.Builder
+
+	done := map[*pdfObject]bool{}
+	for _, page := range document.pages() {
+		for _, number := range page.contents {
+			object := document.objects[number]
+			if !object.isStream() || done[object] {
+				continue
+			}
+			done[object] = true
+			pdfContentText(object.stream(), page.fonts, &text)
+		}
+	}
+
+	for _, object := range document.order {
+		if done[object] || !pdfTextStream(object) {
+			continue
+		}
+		pdfContentText(object.stream(), document.fonts(pdfDict(object.value)["Resources"]), &text)
+	}
+	return text.String()
+}
+
+// pdfTextStream reports whether a stream may hold text operators, rather than an image, a font, a CMap or objects
+func pdfTextStream(object *pdfObject) bool {
+	switch pdfName(pdfDict(object.value)["Type"]) {
+	case "ObjStm", "XRef", "Metadata", "CMap":
+		return false
+	}
+	if bytes.Contains(object.value, []byte("/Image")) || bytes.Contains(object.value, []byte("/FontFile")) || bytes.Contains(object.value, []byte("/Length1")) {
+		return false
+	}
+	return !bytes.Contains(object.stream(), []byte("begincmap"))
+}
+
+// pdfContentText writes the strings of the text objects (BT to ET) of a content stream, read with the ToUnicode map
+// of the font selected by Tf when it has one
+func pdfContentText(data []byte, fonts map[string]*pdfCMap, text *strings.Builder) {
+	inText := false
+	var font *pdfCMap
+	var name string
+	var operands []string
+
+	for i := 0; i < len(data); {
+		ch := data[i]
+		switch {
+		case ch == '%':
+			for i < len(data) && data[i] != '\n' && data[i] != '\r' {
+				i++
+			}
+		case ch == '/':
+			end := pdfSkipValue(data, i)
+			name = string(data[i+1 : end])
+			i = end
+		case ch == '(':
+			value, next := pdfLiteralString(data, i)
+			operands = append(operands, pdfShownText(value, font))
+			i = next
+		case ch == '<' && i+1 < len(data) && data[i+1] != '<':
+			end := bytes.IndexByte(data[i:], '>')
+			if end < 0 {
+				return
+			}
+			operands = append(operands, pdfShownText(pdfHexString(data[i+1:i+end]), font))
+			i += end + 1
+		case ch == '[' || ch == ']':
+			i++
+		case ch == '-' || ch == '.' || (ch >= '0' && ch <= '9'):
+			start := i
+			for i < len(data) && (data[i] == '-' || data[i] == '.' || (data[i] >= '0' && data[i] <= '9')) {
+				i++
+			}
+			// a wide negative kerning inside a TJ array separates words
+			if len(operands) > 0 && data[start] == '-' && i-start >= 4 {
+				operands = append(operands, " ")
+			}
+		case unicode.IsLetter(rune(ch)) || ch == '\'' || ch == '"' || ch == '*':
+			start := i
+			for i < len(data) && (unicode.IsLetter(rune(data[i])) || data[i] == '\'' || data[i] == '"' || data[i] == '*') {
+				i++
+			}
+			switch string(data[start:i]) {
+			case "BT":
+				inText = true
+			case "ET":
+				inText = false
+				text.WriteString("\n")
+			case "Tf":
+				font = fonts[name]
+			case "Tj", "TJ":
+				if inText {
+					text.WriteString(strings.Join(operands, ""))
+				}
+			case "'", "\"":
+				if inText {
+					text.WriteString("\n" + strings.Join(operands, ""))
+				}
+			case "Td", "TD", "T*", "Tm":
+				if inText {
+					text.WriteString("\n")
+				}
+			}
+			operands = operands[:0]
+		default:
+			i++
+		}
+	}
+}
+
+// pdfShownText decodes a string shown in a font
+func pdfShownText(value []byte, font *pdfCMap) string {
+	if font != nil {
+		return font.decode(value)
+	}
+	return pdfDecodeString(value)
+}
+
+// pdfLiteralString reads the bytes of the (string) starting at start and returns the index after it
+func pdfLiteralString(data []byte, start int) ([]byte, int) {
+	var value []byte
+	depth := 0
+	i := start
+	for ; i < len(data); i++ {
+		ch := data[i]
+		switch ch {
+		case '\\':
+			i++
+			if i >= len(data) {
+				break
+			}
+			switch data[i] {
+			case 'n':
+				value = append(value, '\n')
+			case 'r':
+				value = append(value, '\r')
+			case 't':
+				value = append(value, '\t')
+			case 'b', 'f':
+			case '\r', '\n':
+				// an escaped line break continues the string
+			default:
+				if data[i] >= '0' && data[i] <= '7' {
+					code := 0
+					for n := 0; n < 3 && i < len(data) && data[i] >= '0' && data[i] <= '7'; n++ {
+						code = code*8 + int(data[i]-'0')
+						i++
+					}
+					i--
+					value = append(value, byte(code))
+				} else {
+					value = append(value, data[i])
+				}
+			}
+			continue
+		case '(':
+			depth++
+			if depth == 1 {
+				continue
+			}
+		case ')':
+			depth--
+			if depth == 0 {
+				return value, i + 1
+			}
+		}
+		value = append(value, ch)
+	}
+	return value, i
+}
+
+func pdfHexString(data []byte) []byte {
+	digits := bytes.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, data)
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	value, err := hex.DecodeString(string(digits))
+	if err != nil {
+		return nil
+	}
+	return value
+}
+
+// pdfDecodeString reads UTF-16 strings marked with a byte order mark, and others as Latin-1 which matches the
+// printable characters of the standard PDF encodings
+func pdfDecodeString(value []byte) string {
+	if len(value) >= 2 && value[0] == 0xfe && value[1] == 0xff {
+		units := make([]uint16, 0, len(value)/2)
+		for i := 2; i+1 < len(value); i += 2 {
+			units = append(units, uint16(value[i])<<8|uint16(value[i+1]))
+		}
+		return string(utf16.Decode(units))
+	}
+
+	runes := make([]rune, len(value))
+	for i, b := range value {
+		runes[i] = rune(b)
+	}
+	return string(runes)
+}
+
+// readableText reports whether extracted text is mostly letters, digits, spaces and punctuation rather than the
+// glyph codes of fonts with their own encodings
+func readableText(text string) bool {
+	total, readable := 0, 0
+	for _, r := range text {
+		if unicode.IsSpace(r) {
+			continue
+		}
+		total++
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsPunct(r) || unicode.IsSymbol(r) {
+			readable++
+		}
+	}
+	return total > 0 && readable*10 >= total*9
+}
@@ -0,0 +1,318 @@
+package helper
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// testStream is a stream object of testPDF, compressed with Flate when compress is set
+type testStream struct {
+	dict     string
+	data     string
+	compress bool
+}
+
+// testPDF writes the objects, numbered from 1, as a PDF. Object 1 is expected to be the catalog.
+func testPDF(objects ...interface{}) []byte {
+	var pdf bytes.Buffer
+	pdf.WriteString("%PDF-1.7\n%\xe2\xe3\xcf\xd3\n")
+	for i, object := range objects {
+		fmt.Fprintf(&pdf, "%d 0 obj\n", i+1)
+		switch o := object.(type) {
+		case string:
+			pdf.WriteString(o)
+		case testStream:
+			data, dict := []byte(o.data), o.dict
+			if o.compress {
+				var compressed bytes.Buffer
+				writer := zlib.NewWriter(&compressed)
+				writer.Write(data)
+				writer.Close()
+				data, dict = compressed.Bytes(), dict+" /Filter /FlateDecode"
+			}
+			fmt.Fprintf(&pdf, "<<%s /Length %d>>\nstream\n%s\nendstream", dict, len(data), data)
+		}
+		pdf.WriteString("\nendobj\n")
+	}
+	pdf.WriteString("trailer\n<< /Root 1 0 R >>\n%%EOF\n")
+	return pdf.Bytes()
+}
+
+// testObjectStream packs objects, numbered from first, in an object stream
+func testObjectStream(first int, objects ...string) testStream {
+	var header, body strings.Builder
+	for i, object := range objects {
+		fmt.Fprintf(&header, "%d %d ", first+i, body.Len())
+		body.WriteString(object + "\n")
+	}
+	return testStream{
+		dict:     fmt.Sprintf(" /Type /ObjStm /N %d /First %d", len(objects), header.Len()),
+		data:     header.String() + body.String(),
+		compress: true,
+	}
+}
+
+// identityHex writes text as the glyph ids of testCMap, the way a Type0 font with Identity-H shows it
+func identityHex(text string) string {
+	var glyphs strings.Builder
+	glyphs.WriteString("<")
+	for _, r := range text {
+		switch {
+		case r >= 'A' && r <= 'Z':
+			fmt.Fprintf(&glyphs, "%04X", 0x24+r-'A')
+		case r >= 'a' && r <= 'z':
+			fmt.Fprintf(&glyphs, "%04X", 0x44+r-'a')
+		case r == ' ':
+			glyphs.WriteString("0003")
+		case r == 'é':
+			glyphs.WriteString("0011")
+		}
+	}
+	return glyphs.String() + ">"
+}
+
+const testCMap = `/CIDInit /ProcSet findresource begin
+12 dict begin
+begincmap
+/CIDSystemInfo << /Registry (Adobe) /Ordering (UCS) /Supplement 0 >> def
+/CMapName /Adobe-Identity-UCS def
+/CMapType 2 def
+1 begincodespacerange
+<0000> <FFFF>
+endcodespacerange
+2 beginbfchar
+<0003> <0020>
+<0011> <00E9>
+endbfchar
+2 beginbfrange
+<0024> <003D> <0041>
+<0044> <005D> <0061>
+endbfrange
+endcmap
+CMapName currentdict /CMap defineresource pop
+end
+end`
+
+const testLigatureCMap = `begincmap
+1 begincodespacerange <00> <FF> endcodespacerange
+1 beginbfchar <02> <00660069> endbfchar
+1 beginbfrange <03> <04> [<0066006C> <00660066>] endbfrange
+endcmap`
+
+const (
+	testCatalog    = "<< /Type /Catalog /Pages 2 0 R >>"
+	testHelvetica  = "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>"
+	testType0Font  = "<< /Type /Font /Subtype /Type0 /BaseFont /AAAAAA+Calibri /Encoding /Identity-H /DescendantFonts [7 0 R] /ToUnicode 6 0 R >>"
+	testCIDFont    = "<< /Type /Font /Subtype /CIDFontType2 /BaseFont /AAAAAA+Calibri /CIDToGIDMap /Identity >>"
+	testOnePage    = "<< /Type /Pages /Kids [3 0 R] /Count 1 >>"
+	testPageFontF1 = "<< /Type /Page /Parent 2 0 R /Resources << /Font << /F1 5 0 R >> >> /Contents 4 0 R >>"
+)
+
+func TestExtractPDFText(t *testing.T) {
+	tests := []struct {
+		name string
+		pdf  []byte
+		want string
+	}{
+		{
+			name: "standard font",
+			pdf: testPDF(testCatalog, testOnePage, testPageFontF1,
+				testStream{data: "BT /F1 12 Tf 72 700 Td (Hello, world!) Tj ET"},
+				testHelvetica),
+			want: "Hello, world!",
+		},
+		{
+			name: "compressed stream with kerning, escapes and hex strings",
+			pdf: testPDF(testCatalog, testOnePage, testPageFontF1,
+				testStream{data: "BT /F1 12 Tf [(Water) -250 (cycle)] TJ 0 -14 Td [(Ev) 30 (aporation \\(step 1\\))] TJ T* <FEFF00E9007400E9> Tj ET", compress: true},
+				testHelvetica),
+			want: "Water cycle Evaporation (step 1) été",
+		},
+		{
+			name: "type0 font with a ToUnicode map",
+			pdf: testPDF(testCatalog, testOnePage, testPageFontF1,
+				testStream{data: "BT /F1 11 Tf 72 700 Td " + identityHex("Rentrée scolaire") + " Tj ET", compress: true},
+				testType0Font,
+				testStream{data: testCMap, compress: true},
+				testCIDFont),
+			want: "Rentrée scolaire",
+		},
+		{
+			name: "ligatures of a simple font",
+			pdf: testPDF(testCatalog, testOnePage, testPageFontF1,
+				testStream{data: "BT /F1 12 Tf (\\002nd the \\003oor and the o\\004ice) Tj ET"},
+				"<< /Type /Font /Subtype /Type1 /BaseFont /Times-Roman /ToUnicode 6 0 R >>",
+				testStream{data: testLigatureCMap}),
+			want: "find the floor and the office",
+		},
+		{
+			name: "pages in tree order with their own fonts",
+			pdf: testPDF(testCatalog,
+				"<< /Type /Pages /Kids [4 0 R 3 0 R] /Count 2 >>",
+				"<< /Type /Page /Parent 2 0 R /Resources << /Font << /F1 7 0 R >> >> /Contents 8 0 R >>",
+				"<< /Type /Page /Parent 2 0 R /Resources 11 0 R /Contents [9 0 R 10 0 R] >>",
+				testType0Font,
+				testStream{data: testCMap},
+				testHelvetica,
+				testStream{data: "BT /F1 12 Tf (Second page) Tj ET"},
+				testStream{data: "BT /F1 12 Tf " + identityHex("First") + " Tj ET"},
+				testStream{data: "BT /F1 12 Tf " + identityHex(" page") + " Tj ET"},
+				"<< /Font << /F1 5 0 R >> >>"),
+			want: "First page Second page",
+		},
+		{
+			name: "fonts and pages in an object stream",
+			pdf: testPDF("<< /Type /Catalog /Pages 5 0 R >>",
+				testObjectStream(5,
+					"<< /Type /Pages /Kids [6 0 R] /Count 1 /Resources << /Font << /F1 7 0 R >> >> >>",
+					"<< /Type /Page /Parent 5 0 R /Contents 3 0 R >>",
+					"<< /Type /Font /Subtype /Type0 /Encoding /Identity-H /ToUnicode 4 0 R >>"),
+				testStream{data: "BT /F1 12 Tf " + identityHex("Packed objects") + " Tj ET", compress: true},
+				testStream{data: testCMap, compress: true}),
+			want: "Packed objects",
+		},
+		{
+			name: "no page tree",
+			pdf:  testPDF("<< /Producer (scanner) >>", testStream{data: "BT (Loose stream) Tj ET"}),
+			want: "Loose stream",
+		},
+		{
+			name: "scanned page",
+			pdf: testPDF(testCatalog, testOnePage,
+				"<< /Type /Page /Parent 2 0 R /Resources << /XObject << /Im1 5 0 R >> >> /Contents 4 0 R >>",
+				testStream{data: "q 612 0 0 792 0 0 cm /Im1 Do Q"},
+				testStream{dict: " /Type /XObject /Subtype /Image /Width 2 /Height 1 /ColorSpace /DeviceGray /BitsPerComponent 8", data: "BT\xff"}),
+			want: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := strings.Join(strings.Fields(ExtractPDFText(tt.pdf)), " ")
+			if got != tt.want {
+				t.Errorf("ExtractPDFText() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractDocumentText(t *testing.T) {
+	tests := []struct {
+		name         string
+		documentType string
+		content      []byte
+		want         string
+		wantErr      error
+	}{
+		{
+			name:         "readable PDF",
+			documentType: PDFDocument,
+			content: testPDF(testCatalog, testOnePage, testPageFontF1,
+				testStream{data: "BT /F1 12 Tf " + identityHex("Bonne année") + " Tj ET"},
+				testType0Font, testStream{data: testCMap}, testCIDFont),
+			want: "Bonne année\n",
+		},
+		{
+			name:         "type0 font without a ToUnicode map",
+			documentType: PDFDocument,
+			content: testPDF(testCatalog, testOnePage, testPageFontF1,
+				testStream{data: "BT /F1 12 Tf " + identityHex("Bonne annee") + " Tj ET"},
+				"<< /Type /Font /Subtype /Type0 /Encoding /Identity-H >>"),
+			wantErr: ErrDocumentHasNoText,
+		},
+		{name: "markdown with a byte order mark", documentType: MarkdownDocument, content: []byte("\xef\xbb\xbf# Notes"), want: "# Notes"},
+		{name: "blank text", documentType: TextDocument, content: []byte(" \n\t"), wantErr: ErrDocumentHasNoText},
+		{name: "other type", documentType: "DOCX", content: []byte("text"), wantErr: ErrUnsupportedDocument},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExtractDocumentText(tt.documentType, tt.content)
+			if err != tt.wantErr || got != tt.want {
+				t.Errorf("ExtractDocumentText() = %q, %v, want %q, %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestExtractPDFTextMalformed(t *testing.T) {
+	page := func(extra ...interface{}) []byte {
+		objects := []interface{}{testCatalog, testOnePage, testPageFontF1,
+			testStream{data: "BT /F1 12 Tf (Still read) Tj ET"}, testHelvetica}
+		return testPDF(append(objects, extra...)...)
+	}
+	hugeRange := "begincmap\n1 begincodespacerange <0000> <FFFF> endcodespacerange\n1000 beginbfrange\n" +
+		strings.Repeat("<0000> <FFFF> <0041>\n", 1000) + "endbfrange\nendcmap"
+
+	tests := []struct {
+		name string
+		pdf  []byte
+		want string
+	}{
+		{
+			name: "negative offset in an object stream",
+			pdf: page(testStream{
+				dict:     " /Type /ObjStm /N 1 /First 9",
+				data:     "8 -100   << /Type /Font >>",
+				compress: true,
+			}),
+			want: "Still read",
+		},
+		{
+			name: "arrays nested past the limit",
+			pdf:  page("<< /Nested " + strings.Repeat("[", 1<<20) + " >>"),
+			want: "Still read",
+		},
+		{
+			name: "CMap ranges past the limit",
+			pdf: testPDF(testCatalog, testOnePage, testPageFontF1,
+				testStream{data: "BT /F1 12 Tf <0000> Tj ET"},
+				"<< /Type /Font /Subtype /Type0 /Encoding /Identity-H /ToUnicode 6 0 R >>",
+				testStream{data: hugeRange, compress: true}),
+			want: "A",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := strings.Join(strings.Fields(ExtractPDFText(tt.pdf)), " ")
+			if got != tt.want {
+				t.Errorf("ExtractPDFText() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPDFSkipValueNesting(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want int
+	}{
+		{name: "nested array and dictionary", data: "[1 << /A [2 (x]) <41>] >> /B] rest", want: 29},
+		{name: "deepest allowed", data: strings.Repeat("[", pdfMaxNesting) + strings.Repeat("]", pdfMaxNesting) + " rest", want: 2 * pdfMaxNesting},
+		{name: "too deep", data: strings.Repeat("[", pdfMaxNesting+1) + strings.Repeat("]", pdfMaxNesting+1) + " rest", want: 2*pdfMaxNesting + 7},
+		{name: "unclosed", data: "<< /A [1 2", want: 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pdfSkipValue([]byte(tt.data), 0); got != tt.want {
+				t.Errorf("pdfSkipValue() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePDFCMapLimit(t *testing.T) {
+	cmap := parsePDFCMap([]byte("1 beginbfrange <0000> <FFFF> <0041> endbfrange 1 beginbfchar <0001> <0042> endbfchar"), 100)
+	if len(cmap.chars) != 100 || cmap.entries != 0 {
+		t.Errorf("parsePDFCMap() read %d mappings with %d left, want 100 and 0", len(cmap.chars), cmap.entries)
+	}
+	if got := cmap.decode([]byte{0, 1}); got != "B" {
+		t.Errorf("decode(0001) = %q, want the range mapping kept over the bfchar past the limit", got)
+	}
+}
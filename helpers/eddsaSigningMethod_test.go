@@ -0,0 +1,55 @@
+package helper
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+func TestEdDSASigningMethod(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPublic, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signature, err := EdDSASigningMethod.Sign("header.payload", private)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+
+	tests := []struct {
+		name          string
+		signingString string
+		key           interface{}
+		wantErr       bool
+	}{
+		{"valid", "header.payload", public, false},
+		{"tampered payload", "header.payload2", public, true},
+		{"other key", "header.payload", otherPublic, true},
+		{"wrong key type", "header.payload", []byte("secret"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := EdDSASigningMethod.Verify(tt.signingString, signature, tt.key)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Verify error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := EdDSASigningMethod.Sign("header.payload", public); err != jwt.ErrInvalidKeyType {
+		t.Fatalf("Sign with a public key: got %v, want ErrInvalidKeyType", err)
+	}
+}
+
+func TestEdDSASigningMethodIsRegistered(t *testing.T) {
+	if jwt.GetSigningMethod("EdDSA") != EdDSASigningMethod {
+		t.Fatal("EdDSA is not registered with jwt-go")
+	}
+}
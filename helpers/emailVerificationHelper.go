@@ -0,0 +1,98 @@
+package helper
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gambl/models"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const EmailVerificationTokenType = "email_verification"
+
+var (
+	ErrVerificationTokenInvalid = errors.New("verification link is invalid or has expired")
+	ErrEmailNotVerified         = errors.New("please verify your email address to login")
+)
+
+// EmailVerificationTTL is how long a verification link stays valid, from EMAIL_VERIFICATION_TTL_HOURS
+func EmailVerificationTTL() time.Duration {
+	return time.Duration(envInt("EMAIL_VERIFICATION_TTL_HOURS", 48)) * time.Hour
+}
+
+// EmailVerificationGracePeriod is how long a new account can login without verifying its email,
+// from EMAIL_VERIFICATION_GRACE_HOURS
+func EmailVerificationGracePeriod() time.Duration {
+	return time.Duration(envInt("EMAIL_VERIFICATION_GRACE_HOURS", 72)) * time.Hour
+}
+
+// GenerateEmailVerificationToken signs the token put in the verification link. It is bound to the email address
+// so it stops working if the address changes.
+func GenerateEmailVerificationToken(uid string, email string) (string, error) {
+	claims := &SignedDetails{
+		Email:      email,
+		Uid:        uid,
+		Token_type: EmailVerificationTokenType,
+		StandardClaims: jwt.StandardClaims{
+			Id:        primitive.NewObjectID().Hex(),
+			IssuedAt:  time.Now().Local().Unix(),
+			ExpiresAt: time.Now().Local().Add(EmailVerificationTTL()).Unix(),
+		},
+	}
+
+	return signToken(claims)
+}
+
+// VerifyEmail checks a verification token and marks the address of its user as verified
+func VerifyEmail(ctx context.Context, token string) (string, error) {
+	claims, msg := ValidateToken(token)
+	if msg != "" || claims.Token_type != EmailVerificationTokenType {
+		return "", ErrVerificationTokenInvalid
+	}
+
+	result, err := userCollection.UpdateOne(ctx,
+		bson.M{"user_id": claims.Uid, "email": claims.Email},
+		bson.M{"$set": bson.M{"is_verified": true, "otpVerified": true, "updated_at": time.Now()}},
+	)
+	if err != nil {
+		return "", err
+	}
+	if result.MatchedCount == 0 {
+		return "", ErrVerificationTokenInvalid
+	}
+
+	return claims.Uid, nil
+}
+
+// MustVerifyEmail reports whether the user has to verify their email before they can login again: the address
+// was confirmed neither by link nor by OTP and the grace period is over. Accounts created before verification
+// was enforced are let through.
+func MustVerifyEmail(user models.User) bool {
+	if user.Is_Verified == nil || *user.Is_Verified || user.OtpVerified {
+		return false
+	}
+	return time.Since(user.Created_at) >= EmailVerificationGracePeriod()
+}
+
+// MarkVerificationSent records that a verification link was just sent, it fails with an OTPCooldownError
+// when the previous one was sent less than the OTP resend cooldown ago
+func MarkVerificationSent(ctx context.Context, userId string) error {
+	now := time.Now()
+	cooldown := otpSettings().Cooldown
+
+	result, err := userCollection.UpdateOne(ctx,
+		bson.M{"user_id": userId, "verification_sent_at": bson.M{"$not": bson.M{"$gt": now.Add(-cooldown)}}},
+		bson.M{"$set": bson.M{"verification_sent_at": now}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.ModifiedCount == 0 {
+		return &OTPCooldownError{Retry_after: cooldown}
+	}
+	return nil
+}
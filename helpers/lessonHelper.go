@@ -0,0 +1,265 @@
+package helper
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"gambl/config"
+	"gambl/database"
+	"gambl/models"
+
+	"github.com/go-playground/validator/v10"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var aiDraftCollection *mongo.Collection = database.OpenCollection(database.Client, "ai_drafts")
+
+var validateContent = validator.New()
+
+// Kinds and status of AI drafts
+const (
+	LessonPlanDraft = "LESSON_PLAN"
+	QuizDraft       = "QUIZ"
+	DraftStatus     = "DRAFT"
+)
+
+var (
+	ErrDraftNotFound   = errors.New("draft not found")
+	ErrAIInvalidOutput = errors.New("the AI assistant did not return a valid result, please try again")
+)
+
+const lessonSystemPrompt = "You are an experienced teacher who writes clear, age-appropriate teaching material. " +
+	"Reply only with a JSON document matching the requested schema."
+
+// CreateDraftIndexes lets drafts be found by id and listed per owner
+func CreateDraftIndexes() {
+	database.CreateIndexes(aiDraftCollection,
+		mongo.IndexModel{Keys: bson.D{{Key: "draft_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		mongo.IndexModel{Keys: bson.D{{Key: "owner_id", Value: 1}, {Key: "updated_at", Value: -1}}},
+	)
+}
+
+// AIStructuredAttempts is how many times the model is asked again after returning a malformed document, from AI_STRUCTURED_MAX_ATTEMPTS
+func AIStructuredAttempts() int {
+	return envInt("AI_STRUCTURED_MAX_ATTEMPTS", 3)
+}
+
+// LessonPlanSchema is the JSON schema the model fills in for a lesson plan
+func LessonPlanSchema() config.JSONSchema {
+	return config.JSONSchema{Name: "lesson_plan", Schema: objectSchema(map[string]interface{}{
+		"title":      stringSchema(),
+		"objectives": arraySchema(stringSchema(), 1, 0),
+		"materials":  arraySchema(stringSchema(), 0, 0),
+		"activities": arraySchema(objectSchema(map[string]interface{}{
+			"title":            stringSchema(),
+			"description":      stringSchema(),
+			"duration_minutes": map[string]interface{}{"type": "integer"},
+		}), 1, 0),
+		"assessment": stringSchema(),
+		"homework":   stringSchema(),
+	})}
+}
+
+// QuizSchema is the JSON schema the model fills in for a quiz of count questions
+func QuizSchema(count int) config.JSONSchema {
+	return config.JSONSchema{Name: "quiz", Schema: objectSchema(map[string]interface{}{
+		"title": stringSchema(),
+		"questions": arraySchema(objectSchema(map[string]interface{}{
+			"question":     stringSchema(),
+			"options":      arraySchema(stringSchema(), 4, 4),
+			"answer_index": map[string]interface{}{"type": "integer"},
+			"explanation":  stringSchema(),
+		}), count, count),
+	})}
+}
+
+// objectSchema requires every property and forbids others, as structured outputs expect
+func objectSchema(properties map[string]interface{}) map[string]interface{} {
+	required := make([]string, 0, len(properties))
+	for name := range properties {
+		required = append(required, name)
+	}
+	sort.Strings(required)
+	return map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"required":             required,
+		"additionalProperties": false,
+	}
+}
+
+func stringSchema() map[string]interface{} {
+	return map[string]interface{}{"type": "string"}
+}
+
+func arraySchema(items map[string]interface{}, minItems int, maxItems int) map[string]interface{} {
+	schema := map[string]interface{}{"type": "array", "items": items}
+	if minItems > 0 {
+		schema["minItems"] = minItems
+	}
+	if maxItems > 0 {
+		schema["maxItems"] = maxItems
+	}
+	return schema
+}
+
+// LessonPlanMessages asks the model for a lesson plan
+func LessonPlanMessages(request models.GenerateLessonPlan) []models.AIMessage {
+	duration := request.Duration_minutes
+	if duration == 0 {
+		duration = 40
+	}
+
+	prompt := fmt.Sprintf("Write a lesson plan for a %d minute %s lesson for the class %q on the topic %q. "+
+		"Give the learning objectives, the materials needed, the activities in order with their duration adding up to the lesson, "+
+		"how learning will be assessed and the homework (an empty string if none).",
+		duration, request.Subject, request.Class, request.Topic)
+	if request.Notes != "" {
+		prompt += "\nTeacher's notes: " + request.Notes
+	}
+
+	return []models.AIMessage{{Role: "system", Content: lessonSystemPrompt}, {Role: "user", Content: prompt}}
+}
+
+// QuizMessages asks the model for a quiz, of 10 questions unless the request says otherwise
+func QuizMessages(request models.GenerateQuiz) []models.AIMessage {
+	difficulty := request.Difficulty
+	if difficulty == "" {
+		difficulty = "medium"
+	}
+
+	prompt := fmt.Sprintf("Write a %s multiple-choice quiz of exactly %d questions in %s for the class %q on the topic %q. "+
+		"Every question has exactly four options, answer_index is the 0-based index of the correct option "+
+		"and the explanation says why it is correct.",
+		difficulty, QuizQuestionCount(request), request.Subject, request.Class, request.Topic)
+	if request.Notes != "" {
+		prompt += "\nTeacher's notes: " + request.Notes
+	}
+
+	return []models.AIMessage{{Role: "system", Content: lessonSystemPrompt}, {Role: "user", Content: prompt}}
+}
+
+// QuizQuestionCount is the number of questions a quiz request asks for
+func QuizQuestionCount(request models.GenerateQuiz) int {
+	if request.Question_count == 0 {
+		return 10
+	}
+	return request.Question_count
+}
+
+// ParseLessonPlan decodes and validates a lesson plan returned by the model
+func ParseLessonPlan(content string) (models.LessonPlan, error) {
+	var plan models.LessonPlan
+	if err := json.Unmarshal([]byte(stripCodeFence(content)), &plan); err != nil {
+		return plan, fmt.Errorf("the answer is not valid JSON: %v", err)
+	}
+	return plan, validateContent.Struct(plan)
+}
+
+// ParseQuiz decodes and validates a quiz of count questions returned by the model
+func ParseQuiz(content string, count int) (models.Quiz, error) {
+	var quiz models.Quiz
+	if err := json.Unmarshal([]byte(stripCodeFence(content)), &quiz); err != nil {
+		return quiz, fmt.Errorf("the answer is not valid JSON: %v", err)
+	}
+	if err := validateContent.Struct(quiz); err != nil {
+		return quiz, err
+	}
+	if len(quiz.Questions) != count {
+		return quiz, fmt.Errorf("the quiz has %d questions instead of %d", len(quiz.Questions), count)
+	}
+	return quiz, nil
+}
+
+// ValidateDraftContent checks content edited by a teacher
+func ValidateDraftContent(content interface{}) error {
+	return validateContent.Struct(content)
+}
+
+// stripCodeFence removes the markdown code fence some models wrap JSON in
+func stripCodeFence(content string) string {
+	content = strings.TrimSpace(content)
+	if !strings.HasPrefix(content, "```") {
+		return content
+	}
+	content = strings.TrimPrefix(content, "```")
+	content = strings.TrimPrefix(content, "json")
+	return strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(content), "```"))
+}
+
+// CreateDraft stores a generated draft
+func CreateDraft(ctx context.Context, draft models.AIDraft) error {
+	_, err := aiDraftCollection.InsertOne(ctx, draft)
+	return err
+}
+
+// GetDraft returns a draft of the owner
+func GetDraft(ctx context.Context, ownerId string, draftId string) (models.AIDraft, error) {
+	var draft models.AIDraft
+	err := aiDraftCollection.FindOne(ctx, bson.M{"draft_id": draftId, "owner_id": ownerId}).Decode(&draft)
+	if err == mongo.ErrNoDocuments {
+		return draft, ErrDraftNotFound
+	}
+	return draft, err
+}
+
+// ListDrafts returns a page of the owner's drafts, of one kind if given, without their content
+func ListDrafts(ctx context.Context, ownerId string, kind string, skip int, limit int) ([]models.AIDraft, int64, error) {
+	filter := bson.M{"owner_id": ownerId}
+	if kind != "" {
+		filter["kind"] = kind
+	}
+
+	total, err := aiDraftCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().
+		SetProjection(bson.M{"lesson_plan": 0, "quiz": 0}).
+		SetSort(bson.D{{Key: "updated_at", Value: -1}}).
+		SetSkip(int64(skip)).
+		SetLimit(int64(limit))
+	cursor, err := aiDraftCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	drafts := []models.AIDraft{}
+	if err = cursor.All(ctx, &drafts); err != nil {
+		return nil, 0, err
+	}
+	return drafts, total, nil
+}
+
+// UpdateDraft sets fields of a draft of the owner
+func UpdateDraft(ctx context.Context, ownerId string, draftId string, set bson.M) error {
+	set["updated_at"] = time.Now().UTC()
+	result, err := aiDraftCollection.UpdateOne(ctx, bson.M{"draft_id": draftId, "owner_id": ownerId}, bson.M{"$set": set})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrDraftNotFound
+	}
+	return nil
+}
+
+// DeleteDraft deletes a draft of the owner
+func DeleteDraft(ctx context.Context, ownerId string, draftId string) error {
+	result, err := aiDraftCollection.DeleteOne(ctx, bson.M{"draft_id": draftId, "owner_id": ownerId})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrDraftNotFound
+	}
+	return nil
+}
@@ -0,0 +1,245 @@
+package helper
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	"gambl/database"
+	"gambl/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var loginAttemptCollection *mongo.Collection = database.OpenCollection(database.Client, "login_attempts")
+
+// failures older than this are forgotten
+const loginFailureWindow = 24 * time.Hour
+
+// how long a login attempt holds the account when its request dies before releasing it
+const loginReservationLease = 30 * time.Second
+
+// LoginThrottledError is returned while an account or client has to wait before trying again
+type LoginThrottledError struct {
+	Retry_after time.Duration
+	Locked      bool
+}
+
+func (e *LoginThrottledError) Error() string {
+	if e.Locked {
+		return "too many failed login attempts, the account is temporarily locked"
+	}
+	return fmt.Sprintf("too many failed login attempts, please wait %d seconds", int(math.Ceil(e.Retry_after.Seconds())))
+}
+
+type loginAttemptSettings struct {
+	maxAccountFailures int
+	maxIPFailures      int
+	backoffAfter       int
+	backoffBase        time.Duration
+	backoffMax         time.Duration
+	lockout            time.Duration
+}
+
+func loginSettings() loginAttemptSettings {
+	return loginAttemptSettings{
+		maxAccountFailures: envInt("LOGIN_MAX_FAILURES", 5),
+		maxIPFailures:      envInt("LOGIN_IP_MAX_FAILURES", 50),
+		backoffAfter:       envInt("LOGIN_BACKOFF_AFTER", 3),
+		backoffBase:        time.Duration(envInt("LOGIN_BACKOFF_BASE_SECONDS", 1)) * time.Second,
+		backoffMax:         5 * time.Minute,
+		lockout:            time.Duration(envInt("LOGIN_LOCKOUT_MINUTES", 15)) * time.Minute,
+	}
+}
+
+func accountKey(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+// CreateLoginAttemptIndexes keeps one counter per key and lets mongo forget old failures
+func CreateLoginAttemptIndexes() {
+	database.CreateIndexes(loginAttemptCollection,
+		mongo.IndexModel{Keys: bson.D{{Key: "key", Value: 1}}, Options: options.Index().SetUnique(true)},
+		mongo.IndexModel{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	)
+}
+
+// LoginReservation holds the account's single login attempt in progress until it is released
+type LoginReservation struct {
+	key string
+	id  string
+}
+
+// ReserveLoginAttempt returns a *LoginThrottledError when the account or the client address is backing off or
+// locked. Otherwise it reserves the attempt on the account before the password is compared: the reservation is
+// refused while the account is backing off, locked or busy with another attempt, so parallel guesses cannot all
+// pass before their failures are recorded. Release it once the failure or success is recorded.
+func ReserveLoginAttempt(ctx context.Context, email string, ip string) (*LoginReservation, error) {
+	if err := checkLoginAllowed(ctx, email, ip); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	reservation := &LoginReservation{key: accountKey(email), id: primitive.NewObjectID().Hex()}
+	_, err := loginAttemptCollection.UpdateOne(ctx,
+		loginReservationFilter(reservation.key, now),
+		bson.M{
+			"$setOnInsert": bson.M{"_id": primitive.NewObjectID(), "expires_at": now.Add(loginFailureWindow)},
+			"$set":         bson.M{"reserved_by": reservation.id, "reserved_until": now.Add(loginReservationLease)},
+		},
+		options.Update().SetUpsert(true),
+	)
+	if database.IsDuplicateKeyError(err) {
+		// the account's counter exists but is not free: backing off since the check above, or busy
+		if err := checkLoginAllowed(ctx, email, ip); err != nil {
+			return nil, err
+		}
+		return nil, &LoginThrottledError{Retry_after: time.Second}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return reservation, nil
+}
+
+// loginReservationFilter matches the counter of the key when no attempt is backing off, locked or in progress on it
+func loginReservationFilter(key string, now time.Time) bson.M {
+	free := bson.M{"$not": bson.M{"$gt": now}}
+	return bson.M{
+		"key":             key,
+		"locked_until":    free,
+		"next_attempt_at": free,
+		"reserved_until":  free,
+	}
+}
+
+// Release frees the account for its next attempt. A reservation that was not released, by a request that died,
+// runs out after loginReservationLease.
+func (r *LoginReservation) Release(ctx context.Context) {
+	_, err := loginAttemptCollection.UpdateOne(ctx,
+		bson.M{"key": r.key, "reserved_by": r.id},
+		bson.M{"$unset": bson.M{"reserved_by": "", "reserved_until": ""}},
+	)
+	if err != nil {
+		log.Printf("Error releasing the login attempt of %s: %v", r.key, err)
+	}
+}
+
+// checkLoginAllowed returns a *LoginThrottledError when the account or the client address is backing off or locked
+func checkLoginAllowed(ctx context.Context, email string, ip string) error {
+	cursor, err := loginAttemptCollection.Find(ctx, bson.M{"key": bson.M{"$in": []string{accountKey(email), ipKey(ip)}}})
+	if err != nil {
+		return err
+	}
+
+	var attempts []models.LoginAttempt
+	if err = cursor.All(ctx, &attempts); err != nil {
+		return err
+	}
+
+	if throttled := loginThrottle(attempts, time.Now()); throttled != nil {
+		return throttled
+	}
+	return nil
+}
+
+// loginThrottle returns the longest wait the counters impose, a lockout taking precedence over a backoff
+func loginThrottle(attempts []models.LoginAttempt, now time.Time) *LoginThrottledError {
+	var throttled *LoginThrottledError
+	for _, attempt := range attempts {
+		if attempt.Locked_until.After(now) {
+			retryAfter := attempt.Locked_until.Sub(now)
+			if throttled == nil || !throttled.Locked || retryAfter > throttled.Retry_after {
+				throttled = &LoginThrottledError{Retry_after: retryAfter, Locked: true}
+			}
+		} else if attempt.Next_attempt_at.After(now) && (throttled == nil || !throttled.Locked) {
+			retryAfter := attempt.Next_attempt_at.Sub(now)
+			if throttled == nil || retryAfter > throttled.Retry_after {
+				throttled = &LoginThrottledError{Retry_after: retryAfter}
+			}
+		}
+	}
+	return throttled
+}
+
+// RecordLoginFailure counts a failed login for the account and the client address. accountLocked is true
+// only for the failure that locked the account, so the owner is notified once per lockout.
+func RecordLoginFailure(ctx context.Context, email string, ip string) (accountLocked bool, lockedUntil time.Time, err error) {
+	settings := loginSettings()
+
+	accountLocked, lockedUntil, err = recordFailure(ctx, accountKey(email), settings.maxAccountFailures, settings)
+	if err != nil {
+		return
+	}
+
+	_, _, err = recordFailure(ctx, ipKey(ip), settings.maxIPFailures, settings)
+
+	return
+}
+
+func recordFailure(ctx context.Context, key string, maxFailures int, settings loginAttemptSettings) (locked bool, lockedUntil time.Time, err error) {
+	now := time.Now()
+
+	var attempt models.LoginAttempt
+	err = loginAttemptCollection.FindOneAndUpdate(ctx,
+		bson.M{"key": key},
+		bson.M{
+			"$setOnInsert": bson.M{"_id": primitive.NewObjectID()},
+			"$inc":         bson.M{"failures": 1},
+			"$set":         bson.M{"last_failure_at": now},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&attempt)
+	if err != nil {
+		return false, time.Time{}, err
+	}
+
+	update := bson.M{"expires_at": now.Add(loginFailureWindow)}
+
+	// exponential backoff between attempts once the first few failures are used up
+	if attempt.Failures >= settings.backoffAfter {
+		exponent := attempt.Failures - settings.backoffAfter
+		if exponent > 20 {
+			exponent = 20
+		}
+		delay := settings.backoffBase * time.Duration(1<<uint(exponent))
+		if delay > settings.backoffMax {
+			delay = settings.backoffMax
+		}
+		update["next_attempt_at"] = now.Add(delay)
+	}
+
+	if attempt.Failures >= maxFailures {
+		lockedUntil = now.Add(settings.lockout)
+		update["locked_until"] = lockedUntil
+		update["failures"] = 0
+		update["expires_at"] = lockedUntil.Add(loginFailureWindow)
+		locked = true
+	}
+
+	_, err = loginAttemptCollection.UpdateOne(ctx, bson.M{"_id": attempt.ID}, bson.M{"$set": update})
+
+	return locked, lockedUntil, err
+}
+
+// RecordLoginSuccess clears the failures of the account. Failures of the client address are kept.
+func RecordLoginSuccess(ctx context.Context, email string) error {
+	_, err := loginAttemptCollection.DeleteOne(ctx, bson.M{"key": accountKey(email)})
+	return err
+}
+
+// UnlockAccount lifts a lockout and clears the failure count of the account
+func UnlockAccount(ctx context.Context, email string) error {
+	_, err := loginAttemptCollection.DeleteOne(ctx, bson.M{"key": accountKey(email)})
+	return err
+}
@@ -0,0 +1,60 @@
+package helper
+
+import (
+	"testing"
+	"time"
+
+	"gambl/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestLoginThrottle(t *testing.T) {
+	now := time.Date(2024, 3, 15, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		attempts []models.LoginAttempt
+		want     *LoginThrottledError
+	}{
+		{name: "no counters"},
+		{name: "backoff over", attempts: []models.LoginAttempt{{Next_attempt_at: now.Add(-time.Second), Locked_until: now.Add(-time.Minute)}}},
+		{name: "backing off", attempts: []models.LoginAttempt{{Next_attempt_at: now.Add(4 * time.Second)}}, want: &LoginThrottledError{Retry_after: 4 * time.Second}},
+		{
+			name:     "longest backoff",
+			attempts: []models.LoginAttempt{{Next_attempt_at: now.Add(4 * time.Second)}, {Next_attempt_at: now.Add(8 * time.Second)}},
+			want:     &LoginThrottledError{Retry_after: 8 * time.Second},
+		},
+		{
+			name:     "a lockout wins over a longer backoff",
+			attempts: []models.LoginAttempt{{Next_attempt_at: now.Add(time.Hour)}, {Locked_until: now.Add(time.Minute)}},
+			want:     &LoginThrottledError{Retry_after: time.Minute, Locked: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := loginThrottle(tt.attempts, now)
+			if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Errorf("loginThrottle() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoginReservationFilter(t *testing.T) {
+	now := time.Date(2024, 3, 15, 10, 0, 0, 0, time.UTC)
+
+	filter := loginReservationFilter(accountKey(" Ada@School.org "), now)
+	if filter["key"] != "email:ada@school.org" {
+		t.Errorf("key = %v, want the normalized account key", filter["key"])
+	}
+	// a field that is missing or in the past leaves the account free
+	for _, field := range []string{"locked_until", "next_attempt_at", "reserved_until"} {
+		condition, _ := filter[field].(bson.M)
+		not, _ := condition["$not"].(bson.M)
+		if not["$gt"] != now {
+			t.Errorf("%s = %v, want it not after now", field, filter[field])
+		}
+	}
+}
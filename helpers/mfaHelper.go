@@ -0,0 +1,276 @@
+package helper
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"gambl/database"
+	"gambl/models"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var mfaFactorCollection *mongo.Collection = database.OpenCollection(database.Client, "mfa_factors")
+
+// MfaPendingTokenTTL is how long a user has to enter their second factor after a correct password
+const MfaPendingTokenTTL = 5 * time.Minute
+
+const recoveryCodeCount = 10
+
+var (
+	ErrMfaNotEnrolled     = errors.New("two-factor authentication is not set up")
+	ErrMfaAlreadyEnabled  = errors.New("two-factor authentication is already enabled")
+	ErrMfaInvalidCode     = errors.New("invalid two-factor code")
+	ErrMfaRequiredByRole  = errors.New("two-factor authentication is required for this account")
+	ErrMfaNoPendingFactor = errors.New("start the enrollment before enabling two-factor authentication")
+)
+
+// MfaIssuer is the account issuer shown in authenticator apps
+func MfaIssuer() string {
+	if issuer := os.Getenv("MFA_ISSUER"); issuer != "" {
+		return issuer
+	}
+	return "LearniumAI"
+}
+
+// MfaRequired applies the MFA policy: accounts whose user_type is in MFA_REQUIRED_USER_TYPES (ADMIN by default)
+// or that hold a role listed in MFA_REQUIRED_ROLES must use a second factor
+func MfaRequired(user models.User) bool {
+	userTypes := os.Getenv("MFA_REQUIRED_USER_TYPES")
+	if userTypes == "" {
+		userTypes = "ADMIN"
+	}
+
+	if user.User_type != nil && listContains(userTypes, *user.User_type) {
+		return true
+	}
+
+	roles := os.Getenv("MFA_REQUIRED_ROLES")
+	for _, role := range user.Role {
+		if listContains(roles, role) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func listContains(list string, value string) bool {
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" && item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateMfaIndexes keeps a single factor per user
+func CreateMfaIndexes() {
+	database.CreateIndexes(mfaFactorCollection,
+		mongo.IndexModel{Keys: bson.D{{Key: "user_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+	)
+}
+
+// GenerateMfaPendingToken mints the short-lived token returned by login when a second factor is still needed.
+// It is only accepted by the MFA login and enrollment endpoints.
+func GenerateMfaPendingToken(email string, userType string, uid string) (string, error) {
+	claims := &SignedDetails{
+		Email:      email,
+		Uid:        uid,
+		User_type:  userType,
+		Token_type: MfaPendingTokenType,
+		StandardClaims: jwt.StandardClaims{
+			Id:        primitive.NewObjectID().Hex(),
+			IssuedAt:  time.Now().Local().Unix(),
+			ExpiresAt: time.Now().Local().Add(MfaPendingTokenTTL).Unix(),
+		},
+	}
+
+	return signToken(claims)
+}
+
+// GetMfaFactor returns the user's factor, the boolean is false when the user never started an enrollment
+func GetMfaFactor(ctx context.Context, userId string) (models.MfaFactor, bool, error) {
+	var factor models.MfaFactor
+	err := mfaFactorCollection.FindOne(ctx, bson.M{"user_id": userId}).Decode(&factor)
+	if err == mongo.ErrNoDocuments {
+		return factor, false, nil
+	}
+	if err != nil {
+		return factor, false, err
+	}
+	return factor, true, nil
+}
+
+// MfaEnabled reports whether the user has a confirmed second factor
+func MfaEnabled(ctx context.Context, userId string) (bool, error) {
+	factor, found, err := GetMfaFactor(ctx, userId)
+	return found && factor.Enabled, err
+}
+
+// StartMfaEnrollment generates a new secret for the user. It only becomes active once EnableMfa confirms a code.
+func StartMfaEnrollment(ctx context.Context, userId string) (string, error) {
+	factor, found, err := GetMfaFactor(ctx, userId)
+	if err != nil {
+		return "", err
+	}
+	if found && factor.Enabled {
+		return "", ErrMfaAlreadyEnabled
+	}
+
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	_, err = mfaFactorCollection.UpdateOne(ctx,
+		bson.M{"user_id": userId},
+		bson.M{
+			"$setOnInsert": bson.M{"_id": primitive.NewObjectID(), "created_at": now},
+			"$set":         bson.M{"secret": secret, "enabled": false, "recovery_codes": []string{}, "last_used_step": 0, "updated_at": now},
+		},
+		options.Update().SetUpsert(true),
+	)
+
+	return secret, err
+}
+
+// EnableMfa confirms the pending secret with a code from the authenticator app and returns fresh recovery codes
+func EnableMfa(ctx context.Context, userId string, code string) ([]string, error) {
+	factor, found, err := GetMfaFactor(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	if !found || factor.Secret == "" {
+		return nil, ErrMfaNoPendingFactor
+	}
+	if factor.Enabled {
+		return nil, ErrMfaAlreadyEnabled
+	}
+
+	step, ok := ValidateTOTP(factor.Secret, code, time.Now())
+	if !ok {
+		return nil, ErrMfaInvalidCode
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = mfaFactorCollection.UpdateOne(ctx,
+		bson.M{"_id": factor.ID, "enabled": false},
+		bson.M{"$set": bson.M{"enabled": true, "recovery_codes": hashes, "last_used_step": step, "updated_at": time.Now()}},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// VerifyMfa checks either a TOTP code or a recovery code. A TOTP step is accepted once, a recovery code is used up.
+func VerifyMfa(ctx context.Context, userId string, code string, recoveryCode string) error {
+	factor, found, err := GetMfaFactor(ctx, userId)
+	if err != nil {
+		return err
+	}
+	if !found || !factor.Enabled {
+		return ErrMfaNotEnrolled
+	}
+
+	if recoveryCode != "" {
+		hash := HashToken(normalizeRecoveryCode(recoveryCode))
+		result, err := mfaFactorCollection.UpdateOne(ctx,
+			bson.M{"_id": factor.ID, "recovery_codes": hash},
+			bson.M{"$pull": bson.M{"recovery_codes": hash}, "$set": bson.M{"updated_at": time.Now()}},
+		)
+		if err != nil {
+			return err
+		}
+		if result.ModifiedCount == 0 {
+			return ErrMfaInvalidCode
+		}
+		return nil
+	}
+
+	step, ok := ValidateTOTP(factor.Secret, code, time.Now())
+	if !ok {
+		return ErrMfaInvalidCode
+	}
+
+	result, err := mfaFactorCollection.UpdateOne(ctx,
+		bson.M{"_id": factor.ID, "last_used_step": bson.M{"$lt": step}},
+		bson.M{"$set": bson.M{"last_used_step": step, "updated_at": time.Now()}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.ModifiedCount == 0 {
+		return ErrMfaInvalidCode
+	}
+
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces every recovery code of an enabled factor
+func RegenerateRecoveryCodes(ctx context.Context, userId string) ([]string, error) {
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := mfaFactorCollection.UpdateOne(ctx,
+		bson.M{"user_id": userId, "enabled": true},
+		bson.M{"$set": bson.M{"recovery_codes": hashes, "updated_at": time.Now()}},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if result.MatchedCount == 0 {
+		return nil, ErrMfaNotEnrolled
+	}
+
+	return codes, nil
+}
+
+// DisableMfa removes the user's factor
+func DisableMfa(ctx context.Context, userId string) error {
+	_, err := mfaFactorCollection.DeleteOne(ctx, bson.M{"user_id": userId})
+	return err
+}
+
+func generateRecoveryCodes() (codes []string, hashes []string, err error) {
+	const alphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+	for i := 0; i < recoveryCodeCount; i++ {
+		var code strings.Builder
+		for j := 0; j < 10; j++ {
+			n, err := rand.Int(rand.Reader, big.NewInt(int64(len(alphabet))))
+			if err != nil {
+				return nil, nil, err
+			}
+			code.WriteByte(alphabet[n.Int64()])
+		}
+		formatted := fmt.Sprintf("%s-%s", code.String()[:5], code.String()[5:])
+		codes = append(codes, formatted)
+		hashes = append(hashes, HashToken(normalizeRecoveryCode(formatted)))
+	}
+
+	return codes, hashes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
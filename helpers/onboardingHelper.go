@@ -0,0 +1,226 @@
+package helper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"gambl/database"
+	"gambl/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var onboardingStatusCollection *mongo.Collection = database.OpenCollection(database.Client, "onboarded_user_status")
+
+const (
+	OnboardingStepSchool   = "school"
+	OnboardingStepSession  = "session"
+	OnboardingStepTeam     = "team"
+	OnboardingStepSubjects = "subjects"
+	OnboardingStepClass    = "class"
+)
+
+// onboardingStepFields maps each step to the OnboardedUserStatus field that records it
+var onboardingStepFields = map[string]string{
+	OnboardingStepSchool:   "isschoolcompleted",
+	OnboardingStepSession:  "issessioncompleted",
+	OnboardingStepTeam:     "isteamcompleted",
+	OnboardingStepSubjects: "issubjectscompleted",
+	OnboardingStepClass:    "isclasscompleted",
+}
+
+// domainOnboardingSteps are completed by the endpoint that does the work, creating a school or a role, and cannot
+// be marked done by hand
+var domainOnboardingSteps = map[string]bool{
+	OnboardingStepSchool: true,
+	OnboardingStepTeam:   true,
+}
+
+var (
+	ErrUnknownOnboardingStep = errors.New("unknown onboarding step")
+	ErrOnboardingStepManaged = errors.New("this step is completed by doing it, not by marking it done")
+)
+
+// OnboardingOrderError is returned when a step is completed before the steps that come before it
+type OnboardingOrderError struct {
+	Next string
+}
+
+func (e *OnboardingOrderError) Error() string {
+	return fmt.Sprintf("complete the %s step first", e.Next)
+}
+
+// onboarded remembers until when users who finished onboarding are not looked up again by the middleware. Entries
+// expire so a status reset in the database is picked up, CreateOnboardingStatus forgets the user right away.
+var onboarded sync.Map
+
+const onboardedCacheTTL = time.Minute
+
+// OnboardingSteps returns the required steps in the order they must be completed, from ONBOARDING_STEPS.
+// Steps left out of the list are not required.
+func OnboardingSteps() []string {
+	list := os.Getenv("ONBOARDING_STEPS")
+	if list == "" {
+		list = "school,session,team,subjects,class"
+	}
+
+	var steps []string
+	for _, step := range strings.Split(list, ",") {
+		step = strings.TrimSpace(step)
+		if _, ok := onboardingStepFields[step]; ok {
+			steps = append(steps, step)
+		}
+	}
+	return steps
+}
+
+// OnboardingRequired reports whether accounts of the user type are held to the onboarding steps,
+// from ONBOARDING_REQUIRED_USER_TYPES (ADMIN by default)
+func OnboardingRequired(userType string) bool {
+	userTypes := os.Getenv("ONBOARDING_REQUIRED_USER_TYPES")
+	if userTypes == "" {
+		userTypes = AdminUserType
+	}
+	return listContains(userTypes, userType)
+}
+
+// CreateOnboardingIndexes keeps a single status per user
+func CreateOnboardingIndexes() {
+	database.CreateIndexes(onboardingStatusCollection,
+		mongo.IndexModel{Keys: bson.D{{Key: "user_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+	)
+}
+
+// CreateOnboardingStatus starts tracking the onboarding of a new user
+func CreateOnboardingStatus(ctx context.Context, userId string) error {
+	onboarded.Delete(userId)
+
+	now := time.Now()
+	_, err := onboardingStatusCollection.UpdateOne(ctx,
+		bson.M{"user_id": userId},
+		bson.M{"$setOnInsert": bson.M{
+			"_id":                 primitive.NewObjectID(),
+			"user_id":             userId,
+			"isschoolcompleted":   false,
+			"issessioncompleted":  false,
+			"isteamcompleted":     false,
+			"issubjectscompleted": false,
+			"isclasscompleted":    false,
+			"created_at":          now,
+			"updated_at":          now,
+		}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// GetOnboardingStatus returns the user's status, the boolean is false for accounts created before onboarding was tracked
+func GetOnboardingStatus(ctx context.Context, userId string) (models.OnboardedUserStatus, bool, error) {
+	var status models.OnboardedUserStatus
+	err := onboardingStatusCollection.FindOne(ctx, bson.M{"user_id": userId}).Decode(&status)
+	if err == mongo.ErrNoDocuments {
+		return status, false, nil
+	}
+	if err != nil {
+		return status, false, err
+	}
+	return status, true, nil
+}
+
+// OnboardingStepCompleted reports whether the status records the step
+func OnboardingStepCompleted(status models.OnboardedUserStatus, step string) bool {
+	switch step {
+	case OnboardingStepSchool:
+		return status.IsSchoolCompleted
+	case OnboardingStepSession:
+		return status.IsSessionCompleted
+	case OnboardingStepTeam:
+		return status.IsTeamCompleted
+	case OnboardingStepSubjects:
+		return status.IsSubjectsCompleted
+	case OnboardingStepClass:
+		return status.IsClassCompleted
+	}
+	return false
+}
+
+// NextOnboardingStep returns the first required step that is not completed, or "" once onboarding is done
+func NextOnboardingStep(status models.OnboardedUserStatus) string {
+	for _, step := range OnboardingSteps() {
+		if !OnboardingStepCompleted(status, step) {
+			return step
+		}
+	}
+	return ""
+}
+
+// CheckOnboardingOrder fails when the step cannot be marked done by hand, or when a required step comes before it
+// and is not completed yet
+func CheckOnboardingOrder(status models.OnboardedUserStatus, step string) error {
+	if _, ok := onboardingStepFields[step]; !ok {
+		return ErrUnknownOnboardingStep
+	}
+	if domainOnboardingSteps[step] {
+		return ErrOnboardingStepManaged
+	}
+
+	for _, required := range OnboardingSteps() {
+		if required == step {
+			return nil
+		}
+		if !OnboardingStepCompleted(status, required) {
+			return &OnboardingOrderError{Next: required}
+		}
+	}
+	return nil
+}
+
+// MarkOnboardingStep records a step as completed. Domain endpoints call it when the user does the work of the step,
+// whatever the order. Users without a tracked status are left alone.
+func MarkOnboardingStep(ctx context.Context, userId string, step string) error {
+	field, ok := onboardingStepFields[step]
+	if !ok {
+		return ErrUnknownOnboardingStep
+	}
+
+	_, err := onboardingStatusCollection.UpdateOne(ctx,
+		bson.M{"user_id": userId},
+		bson.M{"$set": bson.M{field: true, "updated_at": time.Now()}},
+	)
+	return err
+}
+
+// IsOnboarded reports whether the user has completed every required step.
+// Users whose onboarding was never tracked are considered onboarded.
+func IsOnboarded(ctx context.Context, userId string) (bool, string, error) {
+	if until, ok := onboarded.Load(userId); ok {
+		if time.Now().Before(until.(time.Time)) {
+			return true, "", nil
+		}
+		onboarded.Delete(userId)
+	}
+
+	status, found, err := GetOnboardingStatus(ctx, userId)
+	if err != nil {
+		return false, "", err
+	}
+
+	next := ""
+	if found {
+		next = NextOnboardingStep(status)
+	}
+	if next != "" {
+		return false, next, nil
+	}
+
+	onboarded.Store(userId, time.Now().Add(onboardedCacheTTL))
+	return true, "", nil
+}
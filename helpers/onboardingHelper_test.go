@@ -0,0 +1,43 @@
+package helper
+
+import (
+	"errors"
+	"testing"
+
+	"gambl/models"
+)
+
+func TestCheckOnboardingOrder(t *testing.T) {
+	t.Setenv("ONBOARDING_STEPS", "school,session,team,subjects,class")
+
+	tests := []struct {
+		name     string
+		status   models.OnboardedUserStatus
+		step     string
+		wantErr  error
+		wantNext string
+	}{
+		{name: "unknown step", step: "payroll", wantErr: ErrUnknownOnboardingStep},
+		{name: "school is completed by creating a school", step: OnboardingStepSchool, wantErr: ErrOnboardingStepManaged},
+		{name: "team is completed by creating a role", status: models.OnboardedUserStatus{IsSchoolCompleted: true, IsSessionCompleted: true}, step: OnboardingStepTeam, wantErr: ErrOnboardingStepManaged},
+		{name: "next step", status: models.OnboardedUserStatus{IsSchoolCompleted: true}, step: OnboardingStepSession},
+		{name: "earlier step missing", step: OnboardingStepSession, wantNext: OnboardingStepSchool},
+		{name: "team missing", status: models.OnboardedUserStatus{IsSchoolCompleted: true, IsSessionCompleted: true}, step: OnboardingStepSubjects, wantNext: OnboardingStepTeam},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckOnboardingOrder(tt.status, tt.step)
+
+			var orderErr *OnboardingOrderError
+			switch {
+			case tt.wantNext != "":
+				if !errors.As(err, &orderErr) || orderErr.Next != tt.wantNext {
+					t.Errorf("CheckOnboardingOrder() = %v, want the %s step first", err, tt.wantNext)
+				}
+			case err != tt.wantErr:
+				t.Errorf("CheckOnboardingOrder() = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
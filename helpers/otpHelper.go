@@ -0,0 +1,222 @@
+package helper
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	config "gambl/config"
+	"gambl/database"
+	"gambl/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var otpChallengeCollection *mongo.Collection = database.OpenCollection(database.Client, "otp_challenges")
+
+const (
+	OTPPurposeSignup = "SIGNUP"
+	OTPPurposeLogin  = "LOGIN"
+	OTPPurposeReset  = "RESET"
+)
+
+var (
+	ErrOTPInvalid = errors.New("invalid OTP!")
+	ErrOTPExpired = errors.New("OTP has expired, please request a new one")
+	ErrOTPLocked  = errors.New("too many attempts, please try again later")
+)
+
+// OTPCooldownError is returned when a new OTP is requested before the resend cooldown has passed
+type OTPCooldownError struct {
+	Retry_after time.Duration
+}
+
+func (e *OTPCooldownError) Error() string {
+	return fmt.Sprintf("please wait %d seconds before requesting a new OTP", int(e.Retry_after.Seconds()+0.5))
+}
+
+// OTPSettings control how codes are generated and how many guesses they allow
+type OTPSettings struct {
+	Length      int
+	TTL         time.Duration
+	MaxAttempts int
+	Cooldown    time.Duration
+	Lockout     time.Duration
+}
+
+func envInt(name string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || value < 1 {
+		return fallback
+	}
+	return value
+}
+
+func otpSettings() OTPSettings {
+	return OTPSettings{
+		Length:      envInt("OTP_LENGTH", 6),
+		TTL:         time.Duration(envInt("OTP_TTL_MINUTES", 10)) * time.Minute,
+		MaxAttempts: envInt("OTP_MAX_ATTEMPTS", 5),
+		Cooldown:    time.Duration(envInt("OTP_RESEND_COOLDOWN_SECONDS", 60)) * time.Second,
+		Lockout:     time.Duration(envInt("OTP_LOCKOUT_MINUTES", 15)) * time.Minute,
+	}
+}
+
+// CreateOTPIndexes keeps one challenge per user and purpose and lets mongo drop stale challenges
+func CreateOTPIndexes() {
+	database.CreateIndexes(otpChallengeCollection,
+		mongo.IndexModel{Keys: bson.D{{Key: "purpose", Value: 1}, {Key: "user_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		mongo.IndexModel{Keys: bson.D{{Key: "purge_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	)
+}
+
+// IssueOTP generates a new code for the user and purpose, replacing any previous one, and returns it in plain text
+// so it can be sent. It refuses while the challenge is locked or the resend cooldown has not passed.
+func IssueOTP(ctx context.Context, purpose string, userId string) (code string, err error) {
+	settings := otpSettings()
+	now := time.Now()
+
+	var existing models.OtpChallenge
+	err = otpChallengeCollection.FindOne(ctx, bson.M{"purpose": purpose, "user_id": userId}).Decode(&existing)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return "", err
+	}
+	if err == nil {
+		if existing.Locked_until.After(now) {
+			return "", ErrOTPLocked
+		}
+		if retryAfter := existing.Issued_at.Add(settings.Cooldown).Sub(now); retryAfter > 0 {
+			return "", &OTPCooldownError{Retry_after: retryAfter}
+		}
+	}
+
+	code = config.GenerateOTP(settings.Length)
+	salt := primitive.NewObjectID().Hex()
+	expiresAt := now.Add(settings.TTL)
+
+	id := primitive.NewObjectID()
+
+	_, err = otpChallengeCollection.UpdateOne(ctx,
+		bson.M{"purpose": purpose, "user_id": userId},
+		bson.M{"$setOnInsert": bson.M{
+			"_id":          id,
+			"challenge_id": id.Hex(),
+		}, "$set": bson.M{
+			"code_hash":    hashOTP(salt, code),
+			"salt":         salt,
+			"attempts":     0,
+			"consumed":     false,
+			"issued_at":    now,
+			"expires_at":   expiresAt,
+			"locked_until": time.Time{},
+			"purge_at":     expiresAt,
+		}},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return "", err
+	}
+
+	return code, nil
+}
+
+// VerifyOTP checks a code against the user's challenge for the purpose and consumes it on success.
+// Every guess claims one of the attempts before the code is compared, so concurrent guesses cannot go past
+// the limit. Reaching it locks the challenge, and no new code can be issued, until the lockout ends.
+func VerifyOTP(ctx context.Context, purpose string, userId string, code string) error {
+	settings := otpSettings()
+	now := time.Now()
+
+	var challenge models.OtpChallenge
+	err := otpChallengeCollection.FindOneAndUpdate(ctx,
+		otpClaimFilter(purpose, userId, settings.MaxAttempts, now),
+		bson.M{"$inc": bson.M{"attempts": 1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&challenge)
+	if err == mongo.ErrNoDocuments {
+		return unclaimedOTPError(ctx, purpose, userId, now)
+	}
+	if err != nil {
+		return err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(challenge.Code_hash), []byte(hashOTP(challenge.Salt, code))) != 1 {
+		if challenge.Attempts < settings.MaxAttempts {
+			return ErrOTPInvalid
+		}
+		lockedUntil := now.Add(settings.Lockout)
+		_, err := otpChallengeCollection.UpdateOne(ctx,
+			bson.M{"_id": challenge.ID},
+			bson.M{"$set": bson.M{"locked_until": lockedUntil, "purge_at": lockedUntil}},
+		)
+		if err != nil {
+			return err
+		}
+		return ErrOTPLocked
+	}
+
+	// only one request can consume the code
+	result, err := otpChallengeCollection.UpdateOne(ctx,
+		bson.M{"_id": challenge.ID, "consumed": false, "code_hash": challenge.Code_hash},
+		bson.M{"$set": bson.M{"consumed": true}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.ModifiedCount == 0 {
+		return ErrOTPInvalid
+	}
+
+	return nil
+}
+
+// otpClaimFilter matches the user's challenge for the purpose only while it can still be guessed: not locked,
+// consumed or expired, and with attempts left
+func otpClaimFilter(purpose string, userId string, maxAttempts int, now time.Time) bson.M {
+	return bson.M{
+		"purpose":      purpose,
+		"user_id":      userId,
+		"consumed":     false,
+		"expires_at":   bson.M{"$gt": now},
+		"attempts":     bson.M{"$lt": maxAttempts},
+		"locked_until": bson.M{"$lte": now},
+	}
+}
+
+// unclaimedOTPError tells why no attempt could be claimed on the user's challenge
+func unclaimedOTPError(ctx context.Context, purpose string, userId string, now time.Time) error {
+	var challenge models.OtpChallenge
+	err := otpChallengeCollection.FindOne(ctx, bson.M{"purpose": purpose, "user_id": userId}).Decode(&challenge)
+	if err == mongo.ErrNoDocuments {
+		return ErrOTPInvalid
+	}
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case challenge.Locked_until.After(now):
+		return ErrOTPLocked
+	case challenge.Consumed:
+		return ErrOTPInvalid
+	}
+	// the code expired, or its attempts ran out and the lockout has passed: a new one has to be requested
+	return ErrOTPExpired
+}
+
+// hashOTP peppers the code with SECRET_KEY so a leaked collection cannot be brute forced offline
+func hashOTP(salt string, code string) string {
+	mac := hmac.New(sha256.New, []byte(SECRET_KEY))
+	mac.Write([]byte(salt + ":" + code))
+	return hex.EncodeToString(mac.Sum(nil))
+}
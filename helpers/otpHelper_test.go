@@ -0,0 +1,37 @@
+package helper
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestOTPClaimFilter(t *testing.T) {
+	now := time.Date(2024, 3, 15, 10, 0, 0, 0, time.UTC)
+
+	filter := otpClaimFilter(OTPPurposeLogin, "u1", 5, now)
+	want := bson.M{
+		"purpose":      OTPPurposeLogin,
+		"user_id":      "u1",
+		"consumed":     false,
+		"expires_at":   bson.M{"$gt": now},
+		"attempts":     bson.M{"$lt": 5},
+		"locked_until": bson.M{"$lte": now},
+	}
+	if len(filter) != len(want) {
+		t.Fatalf("otpClaimFilter() = %v, want %v", filter, want)
+	}
+	for key, value := range want {
+		if condition, ok := value.(bson.M); ok {
+			got, _ := filter[key].(bson.M)
+			for operator, operand := range condition {
+				if got[operator] != operand {
+					t.Errorf("%s %s = %v, want %v", key, operator, got[operator], operand)
+				}
+			}
+		} else if filter[key] != value {
+			t.Errorf("%s = %v, want %v", key, filter[key], value)
+		}
+	}
+}
@@ -0,0 +1,576 @@
+package helper
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// pdfObject is an indirect object of a PDF: its value, the dictionary for a stream, and the raw data of its stream
+type pdfObject struct {
+	value     []byte
+	hasStream bool
+	raw       []byte
+	decoded   bool
+	data      []byte
+}
+
+// pdfDocument is the objects of a PDF, read without its cross-reference table so damaged files still give their text
+type pdfDocument struct {
+	objects map[int]*pdfObject
+	// order lists the objects with a stream as they appear in the file
+	order []*pdfObject
+	cmaps map[int]*pdfCMap
+	// cmapEntries is how many more mappings the CMaps of the document may read
+	cmapEntries int
+}
+
+// pdfPage is a page with the ToUnicode maps of its fonts by resource name, nil for fonts without one
+type pdfPage struct {
+	contents []int
+	fonts    map[string]*pdfCMap
+}
+
+// Limits on what a PDF can make the reader do, uploads are untrusted. Deeper values are malformed, and mappings past
+// the limits are ignored.
+const (
+	pdfMaxNesting      = 256
+	pdfMaxCMapEntries  = 1 << 16
+	pdfMaxCMapsEntries = 1 << 18
+	pdfMaxPageDepth    = 64
+)
+
+var (
+	pdfObjectHeader = regexp.MustCompile(`(\d+)\s+\d+\s+obj\b`)
+	pdfRefPattern   = regexp.MustCompile(`(\d+)\s+\d+\s+R\b`)
+	pdfRefAtStart   = regexp.MustCompile(`^\d+\s+\d+\s+R\b`)
+)
+
+// readPDF reads the objects of a PDF in file order, and those packed in object streams. A later definition of an
+// object, from an incremental update, replaces the earlier one.
+func readPDF(content []byte) *pdfDocument {
+	document := &pdfDocument{objects: map[int]*pdfObject{}, cmaps: map[int]*pdfCMap{}, cmapEntries: pdfMaxCMapsEntries}
+
+	for pos := 0; pos < len(content); {
+		header := pdfObjectHeader.FindSubmatchIndex(content[pos:])
+		if header == nil {
+			break
+		}
+		number, _ := strconv.Atoi(string(content[pos+header[2] : pos+header[3]]))
+		body := content[pos+header[1]:]
+
+		start := pdfSkipSpace(body, 0)
+		end := pdfSkipObject(body, start)
+		object := &pdfObject{value: body[start:end]}
+		next := end
+
+		if after := pdfSkipSpace(body, end); bytes.HasPrefix(body[after:], []byte("stream")) {
+			data := body[after+len("stream"):]
+			data = bytes.TrimPrefix(data, []byte("\r"))
+			data = bytes.TrimPrefix(data, []byte("\n"))
+			length := bytes.Index(data, []byte("endstream"))
+			if length < 0 {
+				length = len(data)
+			}
+			object.hasStream = true
+			object.raw = data[:length]
+			next = len(body) - len(data) + length
+			document.order = append(document.order, object)
+		}
+
+		document.objects[number] = object
+		pos += header[1] + next
+	}
+
+	for _, object := range document.order {
+		if object.hasStream && pdfName(pdfDict(object.value)["Type"]) == "ObjStm" {
+			document.unpackObjectStream(object)
+		}
+	}
+	return document
+}
+
+// unpackObjectStream adds the objects packed in an object stream, which start with pairs of object number and offset
+func (d *pdfDocument) unpackObjectStream(stream *pdfObject) {
+	dict := pdfDict(stream.value)
+	data := stream.stream()
+	first, err := strconv.Atoi(string(d.resolve(dict["First"])))
+	if err != nil || first < 0 || first > len(data) {
+		return
+	}
+
+	fields := bytes.Fields(data[:first])
+	for i := 0; i+1 < len(fields); i += 2 {
+		number, err1 := strconv.Atoi(string(fields[i]))
+		offset, err2 := strconv.Atoi(string(fields[i+1]))
+		if err1 != nil || err2 != nil || offset < 0 || first+offset < first || first+offset >= len(data) {
+			continue
+		}
+		if _, ok := d.objects[number]; ok {
+			continue
+		}
+		value := data[first+offset:]
+		start := pdfSkipSpace(value, 0)
+		d.objects[number] = &pdfObject{value: value[start:pdfSkipObject(value, start)]}
+	}
+}
+
+// stream returns the data of the stream, inflated when it is Flate compressed, or nil when it uses another filter
+func (o *pdfObject) stream() []byte {
+	if o.decoded {
+		return o.data
+	}
+	o.decoded = true
+
+	filter := pdfDict(o.value)["Filter"]
+	switch {
+	case len(filter) == 0:
+		o.data = o.raw
+	case bytes.Equal(bytes.Trim(filter, "[] \t\r\n"), []byte("/FlateDecode")):
+		reader, err := zlib.NewReader(bytes.NewReader(o.raw))
+		if err != nil {
+			return nil
+		}
+		// a truncated stream still gives the data read so far
+		o.data, _ = io.ReadAll(io.LimitReader(reader, 16<<20))
+		reader.Close()
+	}
+	return o.data
+}
+
+// resolve returns the value of the object a reference points to, or the value itself
+func (d *pdfDocument) resolve(value []byte) []byte {
+	if number, ok := pdfRef(value); ok {
+		if object := d.objects[number]; object != nil {
+			return object.value
+		}
+		return nil
+	}
+	return value
+}
+
+// pages walks the page tree from the catalog, pages inherit the resources of the nodes above them
+func (d *pdfDocument) pages() []pdfPage {
+	var pages []pdfPage
+	for _, object := range d.objects {
+		dict := pdfDict(object.value)
+		if pdfName(dict["Type"]) != "Catalog" {
+			continue
+		}
+		if root, ok := pdfRef(dict["Pages"]); ok {
+			d.walkPages(root, nil, 0, map[int]bool{}, &pages)
+			break
+		}
+	}
+	return pages
+}
+
+func (d *pdfDocument) walkPages(number int, resources []byte, depth int, seen map[int]bool, pages *[]pdfPage) {
+	if seen[number] || d.objects[number] == nil || depth > pdfMaxPageDepth {
+		return
+	}
+	seen[number] = true
+
+	node := pdfDict(d.objects[number].value)
+	if value, ok := node["Resources"]; ok {
+		resources = value
+	}
+
+	if kids, ok := node["Kids"]; ok {
+		for _, kid := range pdfRefs(d.resolve(kids)) {
+			d.walkPages(kid, resources, depth+1, seen, pages)
+		}
+		return
+	}
+
+	// a stream, or an array of streams written directly or through a reference
+	contents := node["Contents"]
+	if number, ok := pdfRef(contents); ok && !d.objects[number].isStream() {
+		contents = d.resolve(contents)
+	}
+	*pages = append(*pages, pdfPage{contents: pdfRefs(contents), fonts: d.fonts(resources)})
+}
+
+func (o *pdfObject) isStream() bool {
+	return o != nil && o.hasStream
+}
+
+// fonts returns the ToUnicode maps of the fonts of a resource dictionary by resource name
+func (d *pdfDocument) fonts(resources []byte) map[string]*pdfCMap {
+	fonts := map[string]*pdfCMap{}
+	for name, font := range pdfDict(d.resolve(pdfDict(d.resolve(resources))["Font"])) {
+		fonts[name] = d.toUnicode(font)
+	}
+	return fonts
+}
+
+func (d *pdfDocument) toUnicode(font []byte) *pdfCMap {
+	number, ok := pdfRef(pdfDict(d.resolve(font))["ToUnicode"])
+	if !ok {
+		return nil
+	}
+	if cmap, ok := d.cmaps[number]; ok {
+		return cmap
+	}
+
+	var cmap *pdfCMap
+	if object := d.objects[number]; object.isStream() {
+		limit := pdfMaxCMapEntries
+		if d.cmapEntries < limit {
+			limit = d.cmapEntries
+		}
+		cmap = parsePDFCMap(object.stream(), limit)
+		d.cmapEntries -= limit - cmap.entries
+	}
+	d.cmaps[number] = cmap
+	return cmap
+}
+
+// pdfCMap maps the character codes of a font to text. Codes are one to four bytes long, the code space ranges tell
+// how long the code at the start of a string is.
+type pdfCMap struct {
+	spaces []pdfCodeSpace
+	chars  map[string]string
+	// codeLength is used when the map has no code space ranges, it is the length of its codes
+	codeLength int
+	// entries is how many more mappings may be read
+	entries int
+}
+
+type pdfCodeSpace struct {
+	low  []byte
+	high []byte
+}
+
+// parsePDFCMap reads the code space ranges and the bfchar and bfrange mappings of a ToUnicode CMap, up to limit
+// mappings. A bfrange can map 65,536 codes, so parsing stops at the limit rather than skipping what is over it.
+func parsePDFCMap(data []byte, limit int) *pdfCMap {
+	cmap := &pdfCMap{chars: map[string]string{}, codeLength: 1, entries: limit}
+
+	type operand struct {
+		value []byte
+		array [][]byte
+	}
+	var operands []operand
+
+	for i := pdfSkipSpace(data, 0); i < len(data) && cmap.entries > 0; i = pdfSkipSpace(data, i) {
+		switch ch := data[i]; {
+		case ch == '<' && i+1 < len(data) && data[i+1] != '<':
+			end := pdfSkipValue(data, i)
+			operands = append(operands, operand{value: pdfHexString(data[i+1 : end-1])})
+			i = end
+		case ch == '[':
+			end := pdfSkipValue(data, i)
+			var array [][]byte
+			for j := pdfSkipSpace(data, i+1); j < end-1; j = pdfSkipSpace(data, j) {
+				next := pdfSkipValue(data, j)
+				if data[j] == '<' {
+					array = append(array, pdfHexString(data[j+1:next-1]))
+				}
+				j = next
+			}
+			operands = append(operands, operand{array: array})
+			i = end
+		case ch == '<' || ch == '>':
+			// the dictionaries of the CMap header
+			i += 2
+		default:
+			end := pdfSkipValue(data, i)
+			keyword := string(data[i:end])
+			i = end
+
+			switch {
+			case strings.HasPrefix(keyword, "begin"):
+				operands = operands[:0]
+			case keyword == "endcodespacerange":
+				for j := 0; j+1 < len(operands); j += 2 {
+					low, high := operands[j].value, operands[j+1].value
+					if len(low) > 0 && len(low) <= 4 && len(low) == len(high) {
+						cmap.spaces = append(cmap.spaces, pdfCodeSpace{low: low, high: high})
+					}
+				}
+			case keyword == "endbfchar":
+				for j := 0; j+1 < len(operands) && cmap.entries > 0; j += 2 {
+					cmap.add(operands[j].value, pdfUTF16(operands[j+1].value))
+				}
+			case keyword == "endbfrange":
+				for j := 0; j+2 < len(operands) && cmap.entries > 0; j += 3 {
+					low, high := operands[j].value, operands[j+1].value
+					if len(low) == 0 || len(low) > 4 || len(low) != len(high) {
+						continue
+					}
+					first, last := pdfCode(low), pdfCode(high)
+					if last < first || last-first > 0xffff {
+						continue
+					}
+					for code := first; code <= last && cmap.entries > 0; code++ {
+						offset := code - first
+						target := operands[j+2]
+						switch {
+						case target.array != nil && int(offset) < len(target.array):
+							cmap.add(pdfCodeBytes(code, len(low)), pdfUTF16(target.array[offset]))
+						case target.array == nil:
+							cmap.add(pdfCodeBytes(code, len(low)), pdfUTF16(pdfIncrement(target.value, offset)))
+						}
+					}
+				}
+			}
+		}
+	}
+	return cmap
+}
+
+func (m *pdfCMap) add(code []byte, text string) {
+	if len(code) == 0 || m.entries <= 0 {
+		return
+	}
+	m.entries--
+	if len(m.chars) == 0 {
+		m.codeLength = len(code)
+	}
+	m.chars[string(code)] = text
+}
+
+// decode reads the codes of a string shown in the font. Codes without a mapping are dropped, except single byte
+// codes which are read as Latin-1 like the strings of fonts without a ToUnicode map.
+func (m *pdfCMap) decode(value []byte) string {
+	var text strings.Builder
+	for i := 0; i < len(value); {
+		n := m.length(value[i:])
+		code := value[i : i+n]
+		i += n
+
+		if s, ok := m.chars[string(code)]; ok {
+			text.WriteString(s)
+		} else if n == 1 {
+			text.WriteRune(rune(code[0]))
+		}
+	}
+	return text.String()
+}
+
+// length returns the length of the code at the start of value
+func (m *pdfCMap) length(value []byte) int {
+	for _, space := range m.spaces {
+		n := len(space.low)
+		if n > len(value) {
+			continue
+		}
+		inside := true
+		for j := 0; j < n; j++ {
+			if value[j] < space.low[j] || value[j] > space.high[j] {
+				inside = false
+				break
+			}
+		}
+		if inside {
+			return n
+		}
+	}
+
+	n := m.codeLength
+	if len(m.spaces) > 0 {
+		// a code outside every range takes one byte
+		n = 1
+	}
+	if n > len(value) {
+		n = len(value)
+	}
+	return n
+}
+
+func pdfCode(value []byte) uint32 {
+	var code uint32
+	for _, b := range value {
+		code = code<<8 | uint32(b)
+	}
+	return code
+}
+
+func pdfCodeBytes(code uint32, length int) []byte {
+	value := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		value[i] = byte(code)
+		code >>= 8
+	}
+	return value
+}
+
+// pdfIncrement adds offset to the last UTF-16 unit of the target of a bfrange
+func pdfIncrement(target []byte, offset uint32) []byte {
+	value := append([]byte(nil), target...)
+	if len(value) < 2 {
+		if len(value) == 1 {
+			value[0] += byte(offset)
+		}
+		return value
+	}
+	last := uint32(value[len(value)-2])<<8 | uint32(value[len(value)-1])
+	last += offset
+	value[len(value)-2], value[len(value)-1] = byte(last>>8), byte(last)
+	return value
+}
+
+// pdfUTF16 decodes the UTF-16BE text a code maps to
+func pdfUTF16(value []byte) string {
+	if len(value)%2 == 1 {
+		return pdfDecodeString(value)
+	}
+	units := make([]uint16, len(value)/2)
+	for i := range units {
+		units[i] = uint16(value[2*i])<<8 | uint16(value[2*i+1])
+	}
+	return string(utf16.Decode(units))
+}
+
+// pdfDict returns the entries of a dictionary by key, without the slash. Values are kept as they are written.
+func pdfDict(value []byte) map[string][]byte {
+	entries := map[string][]byte{}
+	if !bytes.HasPrefix(value, []byte("<<")) {
+		return entries
+	}
+
+	for i := pdfSkipSpace(value, 2); i < len(value) && value[i] == '/'; i = pdfSkipSpace(value, i) {
+		keyEnd := pdfSkipValue(value, i)
+		start := pdfSkipSpace(value, keyEnd)
+		end := pdfSkipObject(value, start)
+		entries[string(value[i+1:keyEnd])] = value[start:end]
+		i = end
+	}
+	return entries
+}
+
+func pdfName(value []byte) string {
+	return strings.TrimPrefix(string(value), "/")
+}
+
+func pdfRef(value []byte) (int, bool) {
+	match := pdfRefPattern.FindSubmatch(value)
+	if match == nil || len(match[0]) != len(bytes.TrimSpace(value)) {
+		return 0, false
+	}
+	number, err := strconv.Atoi(string(match[1]))
+	return number, err == nil
+}
+
+// pdfRefs returns the objects referenced by an array, or by a single reference
+func pdfRefs(value []byte) []int {
+	var numbers []int
+	for _, match := range pdfRefPattern.FindAllSubmatch(value, -1) {
+		if number, err := strconv.Atoi(string(match[1])); err == nil {
+			numbers = append(numbers, number)
+		}
+	}
+	return numbers
+}
+
+// pdfSkipSpace returns the index of the next token, after white space and comments
+func pdfSkipSpace(data []byte, i int) int {
+	for i < len(data) {
+		switch data[i] {
+		case ' ', '\t', '\r', '\n', '\f', 0:
+			i++
+		case '%':
+			for i < len(data) && data[i] != '\n' && data[i] != '\r' {
+				i++
+			}
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+// pdfSkipObject returns the index after the value starting at i, a reference counting as one value
+func pdfSkipObject(data []byte, i int) int {
+	window := data[i:]
+	if len(window) > 64 {
+		window = window[:64]
+	}
+	if match := pdfRefAtStart.FindIndex(window); match != nil {
+		return i + match[1]
+	}
+	return pdfSkipValue(data, i)
+}
+
+// pdfSkipValue returns the index after the dictionary, array, string, name or token starting at i. Nested arrays
+// and dictionaries are followed without recursion, and a value nested deeper than pdfMaxNesting is malformed: the
+// rest of the data is skipped.
+func pdfSkipValue(data []byte, i int) int {
+	// closers holds the end of each array and dictionary the value is inside, ']' or '>'
+	var closers []byte
+	for i < len(data) {
+		if len(closers) > 0 {
+			i = pdfSkipSpace(data, i)
+			if i >= len(data) {
+				break
+			}
+			closer := closers[len(closers)-1]
+			if (closer == ']' && data[i] == ']') || (closer == '>' && bytes.HasPrefix(data[i:], []byte(">>"))) {
+				closers = closers[:len(closers)-1]
+				i++
+				if closer == '>' {
+					i++
+				}
+				if len(closers) == 0 {
+					return i
+				}
+				continue
+			}
+		}
+
+		switch {
+		case bytes.HasPrefix(data[i:], []byte("<<")) || data[i] == '[':
+			if len(closers) == pdfMaxNesting {
+				return len(data)
+			}
+			if data[i] == '[' {
+				closers = append(closers, ']')
+				i++
+			} else {
+				closers = append(closers, '>')
+				i += 2
+			}
+			continue
+		case data[i] == '<':
+			if end := bytes.IndexByte(data[i:], '>'); end >= 0 {
+				i += end + 1
+			} else {
+				i = len(data)
+			}
+		case data[i] == '(':
+			_, i = pdfLiteralString(data, i)
+		case data[i] == '/':
+			i++
+			for i < len(data) && !pdfDelimiter(data[i]) {
+				i++
+			}
+		default:
+			start := i
+			for i < len(data) && !pdfDelimiter(data[i]) {
+				i++
+			}
+			if i == start {
+				// a stray delimiter
+				i++
+			}
+		}
+
+		if len(closers) == 0 {
+			return i
+		}
+	}
+	return len(data)
+}
+
+func pdfDelimiter(ch byte) bool {
+	switch ch {
+	case ' ', '\t', '\r', '\n', '\f', 0, '(', ')', '<', '>', '[', ']', '{', '}', '/', '%':
+		return true
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+package helper
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Permission is an action a role can be granted, in "resource:action" form
+type Permission struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+var permissionCatalog = struct {
+	sync.RWMutex
+	permissions map[string]string
+}{permissions: map[string]string{}}
+
+func init() {
+	RegisterPermission("users:read", "List and view user accounts")
+	RegisterPermission("users:write", "Edit, unlock and reset user accounts")
+	RegisterPermission("roles:read", "List and view roles")
+	RegisterPermission("roles:write", "Create, update and delete roles")
+	RegisterPermission("staff_indicators_charts:read", "View staff indicator charts")
+	RegisterPermission("students:read", "List student accounts")
+	RegisterPermission("students:write", "Create student accounts and reset their passwords")
+	RegisterPermission("referrals:read", "View the referral report")
+	RegisterPermission("referrals:write", "Reward referrals")
+	RegisterPermission("ai_usage:read", "View the AI usage report")
+	RegisterPermission("ai_documents:write", "Upload and delete the documents the AI assistant answers from")
+}
+
+// RegisterPermission adds a permission to the catalog roles are validated against
+func RegisterPermission(name string, description string) {
+	permissionCatalog.Lock()
+	defer permissionCatalog.Unlock()
+	permissionCatalog.permissions[name] = description
+}
+
+// Permissions lists the catalog sorted by name
+func Permissions() []Permission {
+	permissionCatalog.RLock()
+	defer permissionCatalog.RUnlock()
+
+	permissions := make([]Permission, 0, len(permissionCatalog.permissions))
+	for name, description := range permissionCatalog.permissions {
+		permissions = append(permissions, Permission{Name: name, Description: description})
+	}
+	sort.Slice(permissions, func(i, j int) bool { return permissions[i].Name < permissions[j].Name })
+
+	return permissions
+}
+
+// ValidatePermissions fails with the list of permissions that are not in the catalog
+func ValidatePermissions(permissions []string) error {
+	permissionCatalog.RLock()
+	defer permissionCatalog.RUnlock()
+
+	var unknown []string
+	for _, permission := range permissions {
+		if _, ok := permissionCatalog.permissions[permission]; !ok {
+			unknown = append(unknown, permission)
+		}
+	}
+
+	if len(unknown) > 0 {
+		return fmt.Errorf("unknown permissions: %s", strings.Join(unknown, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,316 @@
+package helper
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+
+	"gambl/database"
+	"gambl/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var referralCollection *mongo.Collection = database.OpenCollection(database.Client, "user_referrers")
+
+// Referral statuses, a referral only moves forward through them
+const (
+	ReferralPending   = "PENDING"
+	ReferralJoined    = "JOINED"
+	ReferralOnboarded = "ONBOARDED"
+	ReferralRewarded  = "REWARDED"
+)
+
+const referralCodeLength = 8
+
+var (
+	ErrReferralCodeInvalid  = errors.New("invalid referral code")
+	ErrSelfReferral         = errors.New("you cannot refer yourself")
+	ErrAlreadyReferred      = errors.New("this user has already been referred")
+	ErrReferralNotFound     = errors.New("referral doesnt exist")
+	ErrReferralNotOnboarded = errors.New("only onboarded referrals can be rewarded")
+)
+
+// ReferralStats counts a referrer's referrals by status
+type ReferralStats struct {
+	Total     int64 `json:"total"`
+	Pending   int64 `json:"pending"`
+	Joined    int64 `json:"joined"`
+	Onboarded int64 `json:"onboarded"`
+	Rewarded  int64 `json:"rewarded"`
+}
+
+// CreateReferralIndexes makes referral codes unique and lets a user be referred only once
+func CreateReferralIndexes() {
+	database.CreateIndexes(userCollection,
+		mongo.IndexModel{
+			Keys:    bson.D{{Key: "referral_code", Value: 1}},
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"referral_code": bson.M{"$type": "string"}}),
+		},
+	)
+	database.CreateIndexes(referralCollection,
+		mongo.IndexModel{Keys: bson.D{{Key: "refereeid", Value: 1}}, Options: options.Index().SetUnique(true)},
+		mongo.IndexModel{Keys: bson.D{{Key: "referrerid", Value: 1}, {Key: "status", Value: 1}}},
+		mongo.IndexModel{Keys: bson.D{{Key: "school_id", Value: 1}, {Key: "created_at", Value: -1}}},
+	)
+}
+
+// ReferralCode returns the user's referral code, generating it the first time
+func ReferralCode(ctx context.Context, userId string) (string, error) {
+	var user models.User
+	if err := userCollection.FindOne(ctx, bson.M{"user_id": userId}).Decode(&user); err != nil {
+		return "", err
+	}
+	if user.Referral_code != "" {
+		return user.Referral_code, nil
+	}
+
+	// a collision with another user's code fails on the unique index, so try again with a new code
+	for attempt := 0; attempt < 5; attempt++ {
+		code, err := generateReferralCode()
+		if err != nil {
+			return "", err
+		}
+
+		_, err = userCollection.UpdateOne(ctx,
+			bson.M{"user_id": userId, "referral_code": bson.M{"$in": []interface{}{nil, ""}}},
+			bson.M{"$set": bson.M{"referral_code": code}},
+		)
+		if database.IsDuplicateKeyError(err) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+
+		// a concurrent request may have set the code first
+		if err := userCollection.FindOne(ctx, bson.M{"user_id": userId}).Decode(&user); err != nil {
+			return "", err
+		}
+		return user.Referral_code, nil
+	}
+
+	return "", errors.New("could not generate a unique referral code")
+}
+
+// FindReferrer returns the user owning a referral code
+func FindReferrer(ctx context.Context, code string) (models.User, error) {
+	var referrer models.User
+	err := userCollection.FindOne(ctx, bson.M{"referral_code": normalizeReferralCode(code)}).Decode(&referrer)
+	if err == mongo.ErrNoDocuments {
+		return referrer, ErrReferralCodeInvalid
+	}
+	return referrer, err
+}
+
+// RecordReferral records that the referee signed up with the referrer's code
+func RecordReferral(ctx context.Context, referrer models.User, refereeId string, refereeEmail string, refereeType string) error {
+	if referrer.User_id == refereeId || (referrer.Email != nil && strings.EqualFold(*referrer.Email, refereeEmail)) {
+		return ErrSelfReferral
+	}
+
+	referrerType := ""
+	if referrer.User_type != nil {
+		referrerType = *referrer.User_type
+	}
+
+	now := time.Now()
+	referral := models.UserReferrer{
+		ID:            primitive.NewObjectID(),
+		RefereeId:     refereeId,
+		Referee_email: refereeEmail,
+		ReferrerId:    referrer.User_id,
+		Referee_type:  referralUserType(refereeType),
+		Referrer_type: referralUserType(referrerType),
+		Status:        ReferralPending,
+		School_id:     referrer.School_id,
+		Created_at:    now,
+		Updated_at:    now,
+	}
+	referral.User_Referrer_Id = referral.ID.Hex()
+
+	_, err := referralCollection.InsertOne(ctx, referral)
+	if database.IsDuplicateKeyError(err) {
+		return ErrAlreadyReferred
+	}
+	return err
+}
+
+// AdvanceReferral moves the referee's referral from one status to the next. Referees without a referral,
+// or whose referral is not in the from status, are left alone.
+func AdvanceReferral(ctx context.Context, refereeId string, from string, to string) error {
+	_, err := referralCollection.UpdateOne(ctx,
+		bson.M{"refereeid": refereeId, "status": from},
+		bson.M{"$set": bson.M{"status": to, "updated_at": time.Now()}},
+	)
+	return err
+}
+
+// RewardReferral marks an onboarded referral as rewarded. Admins of a school can only reward its referrals.
+func RewardReferral(ctx context.Context, referralId string, schoolId string) (models.UserReferrer, error) {
+	var referral models.UserReferrer
+
+	filter := bson.M{"user_referrer_id": referralId}
+	if schoolId != "" {
+		filter["school_id"] = schoolId
+	}
+
+	err := referralCollection.FindOne(ctx, filter).Decode(&referral)
+	if err == mongo.ErrNoDocuments {
+		return referral, ErrReferralNotFound
+	}
+	if err != nil {
+		return referral, err
+	}
+
+	result, err := referralCollection.UpdateOne(ctx,
+		bson.M{"_id": referral.ID, "status": ReferralOnboarded},
+		bson.M{"$set": bson.M{"status": ReferralRewarded, "updated_at": time.Now()}},
+	)
+	if err != nil {
+		return referral, err
+	}
+	if result.ModifiedCount == 0 {
+		return referral, ErrReferralNotOnboarded
+	}
+
+	referral.Status = ReferralRewarded
+	return referral, nil
+}
+
+// GetReferralStats counts the referrals made by a user
+func GetReferralStats(ctx context.Context, referrerId string) (ReferralStats, error) {
+	var stats ReferralStats
+
+	cursor, err := referralCollection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"referrerid": referrerId}}},
+		{{Key: "$group", Value: bson.M{"_id": "$status", "count": bson.M{"$sum": 1}}}},
+	})
+	if err != nil {
+		return stats, err
+	}
+
+	var groups []struct {
+		Status string `bson:"_id"`
+		Count  int64  `bson:"count"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return stats, err
+	}
+
+	for _, group := range groups {
+		stats.Total += group.Count
+		switch group.Status {
+		case ReferralPending:
+			stats.Pending = group.Count
+		case ReferralJoined:
+			stats.Joined = group.Count
+		case ReferralOnboarded:
+			stats.Onboarded = group.Count
+		case ReferralRewarded:
+			stats.Rewarded = group.Count
+		}
+	}
+
+	return stats, nil
+}
+
+// ReferralReport counts referrals by status for each referrer, most referrals first. An empty schoolId reports on every school.
+func ReferralReport(ctx context.Context, schoolId string, from time.Time, to time.Time, skip int, limit int) ([]bson.M, int64, error) {
+	match := bson.M{}
+	if schoolId != "" {
+		match["school_id"] = schoolId
+	}
+	created := bson.M{}
+	if !from.IsZero() {
+		created["$gte"] = from
+	}
+	if !to.IsZero() {
+		created["$lt"] = to
+	}
+	if len(created) > 0 {
+		match["created_at"] = created
+	}
+
+	countStatus := func(status string) bson.M {
+		return bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$status", status}}, 1, 0}}}
+	}
+
+	cursor, err := referralCollection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$group", Value: bson.M{
+			"_id":       "$referrerid",
+			"total":     bson.M{"$sum": 1},
+			"pending":   countStatus(ReferralPending),
+			"joined":    countStatus(ReferralJoined),
+			"onboarded": countStatus(ReferralOnboarded),
+			"rewarded":  countStatus(ReferralRewarded),
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "total", Value: -1}, {Key: "_id", Value: 1}}}},
+		{{Key: "$facet", Value: bson.M{
+			"items": bson.A{bson.M{"$skip": skip}, bson.M{"$limit": limit}},
+			"count": bson.A{bson.M{"$count": "total"}},
+		}}},
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var result []struct {
+		Items []bson.M `bson:"items"`
+		Count []struct {
+			Total int64 `bson:"total"`
+		} `bson:"count"`
+	}
+	if err := cursor.All(ctx, &result); err != nil {
+		return nil, 0, err
+	}
+
+	items := []bson.M{}
+	var total int64
+	if len(result) > 0 {
+		for _, item := range result[0].Items {
+			item["referrer_id"] = item["_id"]
+			delete(item, "_id")
+			items = append(items, item)
+		}
+		if len(result[0].Count) > 0 {
+			total = result[0].Count[0].Total
+		}
+	}
+
+	return items, total, nil
+}
+
+// referralUserType maps a user type to the types a referral records
+func referralUserType(userType string) string {
+	switch userType {
+	case "TEACHER", "NON_TEACHER", "STUDENT":
+		return userType
+	}
+	return "USER"
+}
+
+func generateReferralCode() (string, error) {
+	const alphabet = "ABCDEFGHJKMNPQRSTUVWXYZ23456789"
+
+	var code strings.Builder
+	for i := 0; i < referralCodeLength; i++ {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(alphabet))))
+		if err != nil {
+			return "", err
+		}
+		code.WriteByte(alphabet[n.Int64()])
+	}
+	return code.String(), nil
+}
+
+func normalizeReferralCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
@@ -0,0 +1,209 @@
+package helper
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"gambl/database"
+	"gambl/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var refreshTokenCollection *mongo.Collection = database.OpenCollection(database.Client, "refresh_tokens")
+
+var (
+	ErrRefreshTokenInvalid = errors.New("refresh token is invalid or expired")
+	ErrRefreshTokenReused  = errors.New("refresh token has already been used, please login again")
+)
+
+// CreateRefreshTokenIndexes makes token lookups unique and lets mongo drop expired refresh tokens
+func CreateRefreshTokenIndexes() {
+	database.CreateIndexes(refreshTokenCollection,
+		mongo.IndexModel{Keys: bson.D{{Key: "token_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+		mongo.IndexModel{Keys: bson.D{{Key: "family_id", Value: 1}}},
+		mongo.IndexModel{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "device_id", Value: 1}}},
+		mongo.IndexModel{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	)
+}
+
+// HashToken returns the hex encoded sha256 of a token, which is what gets stored in the DB
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// DeviceID identifies the device a request comes from by the X-Device-Id header. A request without one gets a new
+// id, which is sent back in the X-Device-Id response header and must be sent with the device's later requests.
+func DeviceID(c *gin.Context) string {
+	if deviceId := c.GetString("device_id"); deviceId != "" {
+		return deviceId
+	}
+
+	deviceId := strings.TrimSpace(c.Request.Header.Get("X-Device-Id"))
+	if deviceId == "" || len(deviceId) > 128 {
+		deviceId = NewDeviceID()
+	}
+
+	c.Set("device_id", deviceId)
+	c.Header("X-Device-Id", deviceId)
+	return deviceId
+}
+
+// NewDeviceID returns a random device id
+func NewDeviceID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return primitive.NewObjectID().Hex()
+	}
+	return hex.EncodeToString(id)
+}
+
+// IssueTokens generates a token pair and stores the refresh token as the start of a new family for the device.
+// Any family previously issued to the same device is revoked.
+func IssueTokens(ctx context.Context, subject TokenSubject, deviceId string) (token string, refreshToken string, err error) {
+	token, refreshToken, err = GenerateAllTokens(subject)
+	if err != nil {
+		return
+	}
+
+	err = RevokeDeviceRefreshTokens(ctx, subject.Uid, deviceId)
+	if err != nil {
+		return
+	}
+
+	err = storeRefreshToken(ctx, primitive.NewObjectID(), refreshToken, subject.Uid, deviceId, primitive.NewObjectID().Hex())
+
+	return
+}
+
+// RotateRefreshToken exchanges a refresh token for a new token pair in the same family.
+// Presenting a token that was already rotated revokes the whole family.
+func RotateRefreshToken(ctx context.Context, presentedToken string) (token string, refreshToken string, err error) {
+	claims, msg := ValidateToken(presentedToken)
+	if msg != "" || claims.Token_type != RefreshTokenType {
+		return "", "", ErrRefreshTokenInvalid
+	}
+
+	var stored models.RefreshToken
+	err = refreshTokenCollection.FindOne(ctx, bson.M{"token_hash": HashToken(presentedToken)}).Decode(&stored)
+	if err != nil {
+		return "", "", ErrRefreshTokenInvalid
+	}
+
+	if stored.Revoked {
+		if stored.Replaced_by != "" {
+			RevokeRefreshTokenFamily(ctx, stored.Family_id)
+			return "", "", ErrRefreshTokenReused
+		}
+		return "", "", ErrRefreshTokenInvalid
+	}
+
+	if stored.Expires_at.Before(time.Now()) {
+		return "", "", ErrRefreshTokenInvalid
+	}
+
+	var user models.User
+	err = userCollection.FindOne(ctx, bson.M{"user_id": stored.User_id}).Decode(&user)
+	if err != nil || user.Email == nil || user.User_type == nil {
+		return "", "", ErrRefreshTokenInvalid
+	}
+
+	// the grace period to verify the email ends refreshes as well as logins
+	if MustVerifyEmail(user) {
+		return "", "", ErrEmailNotVerified
+	}
+
+	// mark the presented token as replaced before minting the new one, so two concurrent
+	// exchanges of the same token cannot both succeed
+	replacementId := primitive.NewObjectID()
+	result, err := refreshTokenCollection.UpdateOne(ctx,
+		bson.M{"_id": stored.ID, "revoked": false},
+		bson.M{"$set": bson.M{"revoked": true, "replaced_by": replacementId.Hex(), "updated_at": time.Now()}},
+	)
+	if err != nil {
+		return "", "", err
+	}
+	if result.ModifiedCount == 0 {
+		RevokeRefreshTokenFamily(ctx, stored.Family_id)
+		return "", "", ErrRefreshTokenReused
+	}
+
+	// the new pair picks up any change to the user's type or roles since the last one
+	token, refreshToken, err = GenerateAllTokens(SubjectFromUser(user))
+	if err != nil {
+		return "", "", err
+	}
+
+	err = storeRefreshToken(ctx, replacementId, refreshToken, stored.User_id, stored.Device_id, stored.Family_id)
+
+	return token, refreshToken, err
+}
+
+// RevokeRefreshTokenFamily revokes every refresh token descended from the same login
+func RevokeRefreshTokenFamily(ctx context.Context, familyId string) error {
+	_, err := refreshTokenCollection.UpdateMany(ctx,
+		bson.M{"family_id": familyId, "revoked": false},
+		bson.M{"$set": bson.M{"revoked": true, "updated_at": time.Now()}},
+	)
+	return err
+}
+
+// RevokeDeviceRefreshTokens revokes the refresh tokens a user holds on a single device
+func RevokeDeviceRefreshTokens(ctx context.Context, userId string, deviceId string) error {
+	_, err := refreshTokenCollection.UpdateMany(ctx,
+		bson.M{"user_id": userId, "device_id": deviceId, "revoked": false},
+		bson.M{"$set": bson.M{"revoked": true, "updated_at": time.Now()}},
+	)
+	return err
+}
+
+// RevokeUserRefreshTokens revokes every refresh token a user holds on every device
+func RevokeUserRefreshTokens(ctx context.Context, userId string) error {
+	_, err := refreshTokenCollection.UpdateMany(ctx,
+		bson.M{"user_id": userId, "revoked": false},
+		bson.M{"$set": bson.M{"revoked": true, "updated_at": time.Now()}},
+	)
+	return err
+}
+
+// RevokeRefreshToken revokes the family of a refresh token presented by its owner
+func RevokeRefreshToken(ctx context.Context, userId string, refreshToken string) error {
+	var stored models.RefreshToken
+	err := refreshTokenCollection.FindOne(ctx, bson.M{"token_hash": HashToken(refreshToken), "user_id": userId}).Decode(&stored)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return RevokeRefreshTokenFamily(ctx, stored.Family_id)
+}
+
+func storeRefreshToken(ctx context.Context, id primitive.ObjectID, refreshToken string, userId string, deviceId string, familyId string) error {
+	now := time.Now()
+	record := models.RefreshToken{
+		ID:         id,
+		Token_id:   id.Hex(),
+		User_id:    userId,
+		Family_id:  familyId,
+		Device_id:  deviceId,
+		Token_hash: HashToken(refreshToken),
+		Expires_at: now.Add(RefreshTokenTTL),
+		Created_at: now,
+		Updated_at: now,
+	}
+
+	_, err := refreshTokenCollection.InsertOne(ctx, record)
+	return err
+}
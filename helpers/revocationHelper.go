@@ -0,0 +1,195 @@
+package helper
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"gambl/database"
+	"gambl/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var revokedTokenCollection *mongo.Collection = database.OpenCollection(database.Client, "revoked_tokens")
+
+// revocations caches lookups against revoked_tokens so that every authenticated request does not hit mongo.
+// Revocations made on this instance are visible immediately, revocations made elsewhere after at most the cache TTL.
+var revocations = &revocationCache{
+	ttl:    revocationCacheTTL(),
+	tokens: map[string]tokenCacheEntry{},
+	users:  map[string]userCacheEntry{},
+}
+
+type tokenCacheEntry struct {
+	revoked   bool
+	checkedAt time.Time
+}
+
+type userCacheEntry struct {
+	revokedBefore time.Time
+	checkedAt     time.Time
+}
+
+type revocationCache struct {
+	mu     sync.RWMutex
+	ttl    time.Duration
+	tokens map[string]tokenCacheEntry
+	users  map[string]userCacheEntry
+}
+
+func revocationCacheTTL() time.Duration {
+	seconds, err := strconv.Atoi(os.Getenv("TOKEN_REVOCATION_CACHE_SECONDS"))
+	if err != nil || seconds < 0 {
+		seconds = 30
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+func (r *revocationCache) token(jti string) (tokenCacheEntry, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	entry, ok := r.tokens[jti]
+	if !ok || time.Since(entry.checkedAt) > r.ttl {
+		return entry, false
+	}
+	return entry, true
+}
+
+func (r *revocationCache) user(uid string) (userCacheEntry, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	entry, ok := r.users[uid]
+	if !ok || time.Since(entry.checkedAt) > r.ttl {
+		return entry, false
+	}
+	return entry, true
+}
+
+func (r *revocationCache) setToken(jti string, revoked bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sweep()
+	r.tokens[jti] = tokenCacheEntry{revoked: revoked, checkedAt: time.Now()}
+}
+
+func (r *revocationCache) setUser(uid string, revokedBefore time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sweep()
+	r.users[uid] = userCacheEntry{revokedBefore: revokedBefore, checkedAt: time.Now()}
+}
+
+// sweep drops stale entries once the cache grows, the caller must hold the write lock
+func (r *revocationCache) sweep() {
+	if len(r.tokens)+len(r.users) < 10000 {
+		return
+	}
+	for jti, entry := range r.tokens {
+		if time.Since(entry.checkedAt) > r.ttl {
+			delete(r.tokens, jti)
+		}
+	}
+	for uid, entry := range r.users {
+		if time.Since(entry.checkedAt) > r.ttl {
+			delete(r.users, uid)
+		}
+	}
+}
+
+// CreateRevocationIndexes indexes revocation lookups and lets mongo drop entries once the tokens they cover have expired
+func CreateRevocationIndexes() {
+	database.CreateIndexes(revokedTokenCollection,
+		mongo.IndexModel{Keys: bson.D{{Key: "jti", Value: 1}}},
+		mongo.IndexModel{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "revoked_before", Value: -1}}},
+		mongo.IndexModel{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	)
+}
+
+// RevokeToken revokes a single token until it would have expired anyway
+func RevokeToken(ctx context.Context, jti string, userId string, expiresAt time.Time) error {
+	if jti == "" {
+		return nil
+	}
+
+	now := time.Now()
+	_, err := revokedTokenCollection.InsertOne(ctx, models.RevokedToken{
+		ID:         primitive.NewObjectID(),
+		Kind:       "TOKEN",
+		Jti:        jti,
+		User_id:    userId,
+		Expires_at: expiresAt,
+		Created_at: now,
+	})
+	if err != nil {
+		return err
+	}
+
+	revocations.setToken(jti, true)
+	return nil
+}
+
+// RevokeAllUserTokens invalidates every access and refresh token issued to the user up to now
+func RevokeAllUserTokens(ctx context.Context, userId string) error {
+	now := time.Now()
+	_, err := revokedTokenCollection.InsertOne(ctx, models.RevokedToken{
+		ID:             primitive.NewObjectID(),
+		Kind:           "USER",
+		User_id:        userId,
+		Revoked_before: now,
+		Expires_at:     now.Add(RefreshTokenTTL),
+		Created_at:     now,
+	})
+	if err != nil {
+		return err
+	}
+
+	revocations.setUser(userId, now)
+
+	return RevokeUserRefreshTokens(ctx, userId)
+}
+
+// IsTokenRevoked reports whether the token itself was revoked or was issued before its owner logged out everywhere
+func IsTokenRevoked(claims *SignedDetails) (bool, error) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if claims.Id != "" {
+		entry, ok := revocations.token(claims.Id)
+		if !ok {
+			count, err := revokedTokenCollection.CountDocuments(ctx, bson.M{"jti": claims.Id})
+			if err != nil {
+				return false, err
+			}
+			entry.revoked = count > 0
+			revocations.setToken(claims.Id, entry.revoked)
+		}
+		if entry.revoked {
+			return true, nil
+		}
+	}
+
+	if claims.Uid == "" {
+		return false, nil
+	}
+
+	entry, ok := revocations.user(claims.Uid)
+	if !ok {
+		var latest models.RevokedToken
+		opts := options.FindOne().SetSort(bson.D{{Key: "revoked_before", Value: -1}})
+		err := revokedTokenCollection.FindOne(ctx, bson.M{"kind": "USER", "user_id": claims.Uid}, opts).Decode(&latest)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return false, err
+		}
+		entry.revokedBefore = latest.Revoked_before
+		revocations.setUser(claims.Uid, entry.revokedBefore)
+	}
+
+	// iat has second precision, so a token minted in the same second as the revocation is kept
+	return claims.IssuedAt < entry.revokedBefore.Unix(), nil
+}
@@ -0,0 +1,113 @@
+package helper
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"gambl/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// rolePermissions caches the permissions of each role by branch so that permission checks do not hit mongo on every request.
+// Changes made through the roles API on this instance are visible immediately, changes made elsewhere after at most the cache TTL.
+var rolePermissions = &rolePermissionCache{
+	ttl:   rolePermissionCacheTTL(),
+	roles: map[string]rolePermissionEntry{},
+}
+
+type rolePermissionEntry struct {
+	permissions map[string]bool
+	checkedAt   time.Time
+}
+
+type rolePermissionCache struct {
+	mu    sync.RWMutex
+	ttl   time.Duration
+	roles map[string]rolePermissionEntry
+}
+
+func rolePermissionCacheTTL() time.Duration {
+	seconds, err := strconv.Atoi(os.Getenv("ROLE_CACHE_SECONDS"))
+	if err != nil || seconds < 0 {
+		seconds = 60
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+func rolePermissionKey(branchId string, role string) string {
+	return branchId + "/" + role
+}
+
+func (r *rolePermissionCache) get(branchId string, role string) (map[string]bool, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	entry, ok := r.roles[rolePermissionKey(branchId, role)]
+	if !ok || time.Since(entry.checkedAt) > r.ttl {
+		return nil, false
+	}
+	return entry.permissions, true
+}
+
+func (r *rolePermissionCache) set(branchId string, role string, permissions map[string]bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.roles) > 10000 {
+		for key, entry := range r.roles {
+			if time.Since(entry.checkedAt) > r.ttl {
+				delete(r.roles, key)
+			}
+		}
+	}
+	r.roles[rolePermissionKey(branchId, role)] = rolePermissionEntry{permissions: permissions, checkedAt: time.Now()}
+}
+
+func (r *rolePermissionCache) invalidate(branchId string, role string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.roles, rolePermissionKey(branchId, role))
+}
+
+// InvalidateRolePermissions drops the cached permissions of a role after it was changed or deleted
+func InvalidateRolePermissions(branchId string, role string) {
+	rolePermissions.invalidate(branchId, role)
+}
+
+// RolePermissions looks up the permissions of a role in a branch. A role that does not exist has none.
+func RolePermissions(ctx context.Context, branchId string, role string) (map[string]bool, error) {
+	if permissions, ok := rolePermissions.get(branchId, role); ok {
+		return permissions, nil
+	}
+
+	var roleDTO models.RolesDTO
+	permissions := map[string]bool{}
+
+	err := rolesCollection.FindOne(ctx, bson.M{"branch_id": branchId, "role_name": role}).Decode(&roleDTO)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+	for _, permission := range roleDTO.Permissions {
+		permissions[permission] = true
+	}
+
+	rolePermissions.set(branchId, role, permissions)
+	return permissions, nil
+}
+
+// RolesHavePermission reports whether any of the roles grants the permission in the branch
+func RolesHavePermission(ctx context.Context, branchId string, roles []string, permission string) (bool, error) {
+	for _, role := range roles {
+		permissions, err := RolePermissions(ctx, branchId, role)
+		if err != nil {
+			return false, err
+		}
+		if permissions[permission] {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,518 @@
+package helper
+
+import (
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gambl/database"
+	"gambl/models"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var signingKeyCollection *mongo.Collection = database.OpenCollection(database.Client, "signing_keys")
+
+// SIGNING_ALG selects how new tokens are signed: HS256 with SECRET_KEY (default), RS256 or EdDSA
+var SIGNING_ALG string = signingAlg()
+
+var keyRotationInterval time.Duration = keyRotationHours()
+
+var keyring = &signingKeyring{}
+
+type signingKey struct {
+	kid         string
+	method      jwt.SigningMethod
+	private     crypto.PrivateKey
+	public      crypto.PublicKey
+	activatedAt time.Time
+	retireAt    time.Time
+	expiresAt   time.Time
+}
+
+type signingKeyring struct {
+	mu       sync.RWMutex
+	keys     []signingKey
+	loadedAt time.Time
+}
+
+func signingAlg() string {
+	switch alg := os.Getenv("JWT_SIGNING_ALG"); alg {
+	case "RS256", "EdDSA":
+		return alg
+	case "", "HS256":
+		return "HS256"
+	default:
+		log.Printf("Unknown JWT_SIGNING_ALG %q, falling back to HS256", alg)
+		return "HS256"
+	}
+}
+
+func keyRotationHours() time.Duration {
+	hours, err := strconv.Atoi(os.Getenv("JWT_KEY_ROTATION_HOURS"))
+	if err != nil || hours < 1 {
+		hours = 720
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+// acceptHS256 reports whether tokens signed with SECRET_KEY are still valid. Once signing moves to an asymmetric
+// key they are accepted until JWT_HS256_ACCEPT_UNTIL, or indefinitely while it is unset and SECRET_KEY is configured.
+func acceptHS256() bool {
+	if SIGNING_ALG == "HS256" {
+		return true
+	}
+	if SECRET_KEY == "" {
+		return false
+	}
+
+	until := os.Getenv("JWT_HS256_ACCEPT_UNTIL")
+	if until == "" {
+		return true
+	}
+
+	cutoff, err := time.Parse(time.RFC3339, until)
+	if err != nil {
+		log.Printf("Invalid JWT_HS256_ACCEPT_UNTIL %q: %v", until, err)
+		return false
+	}
+
+	return time.Now().Before(cutoff)
+}
+
+// encryptedKeyPrefix marks private keys sealed with the key-encryption key, others are plaintext PEM from before
+// keys were encrypted
+const encryptedKeyPrefix = "aes-256-gcm:"
+
+// keyEncryptionKey reads JWT_KEY_ENCRYPTION_KEY, 32 random bytes in base64 (openssl rand -base64 32) that encrypt
+// the private signing keys stored in mongo
+func keyEncryptionKey() ([]byte, error) {
+	value := os.Getenv("JWT_KEY_ENCRYPTION_KEY")
+	if value == "" {
+		return nil, errors.New("JWT_KEY_ENCRYPTION_KEY is not set")
+	}
+
+	kek, err := base64.StdEncoding.DecodeString(value)
+	if err != nil || len(kek) != 32 {
+		return nil, errors.New("JWT_KEY_ENCRYPTION_KEY must be 32 bytes encoded in base64")
+	}
+	return kek, nil
+}
+
+// sealPrivateKey encrypts a PEM private key with AES-256-GCM. The kid is authenticated with it, so a sealed key
+// cannot be swapped into another record.
+func sealPrivateKey(kek []byte, kid string, privatePEM string) (string, error) {
+	aead, err := newKeyAEAD(kek)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := aead.Seal(nonce, nonce, []byte(privatePEM), []byte(kid))
+	return encryptedKeyPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// openPrivateKey decrypts a key sealed by sealPrivateKey. Plaintext keys are returned as they are.
+func openPrivateKey(kek []byte, kid string, stored string) (string, error) {
+	if !strings.HasPrefix(stored, encryptedKeyPrefix) {
+		return stored, nil
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(stored, encryptedKeyPrefix))
+	if err != nil {
+		return "", err
+	}
+
+	aead, err := newKeyAEAD(kek)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", errors.New("sealed key is too short")
+	}
+
+	privatePEM, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(kid))
+	if err != nil {
+		return "", errors.New("private key cannot be decrypted with JWT_KEY_ENCRYPTION_KEY")
+	}
+	return string(privatePEM), nil
+}
+
+func newKeyAEAD(kek []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// CreateSigningKeyIndexes lets mongo drop keys once no token signed with them can still be valid
+func CreateSigningKeyIndexes() {
+	database.CreateIndexes(signingKeyCollection,
+		mongo.IndexModel{Keys: bson.D{{Key: "kid", Value: 1}}, Options: options.Index().SetUnique(true)},
+		mongo.IndexModel{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	)
+}
+
+// StartSigningKeyRotation loads the asymmetric signing keys and keeps rotating them in the background.
+// It does nothing while tokens are signed with HS256.
+func StartSigningKeyRotation() {
+	if SIGNING_ALG == "HS256" {
+		return
+	}
+
+	if _, err := keyEncryptionKey(); err != nil {
+		log.Fatalf("Error loading jwt signing keys: %v", err)
+	}
+
+	if err := rotateSigningKeys(); err != nil {
+		log.Fatalf("Error loading jwt signing keys: %v", err)
+	}
+
+	interval := keyRotationInterval / 24
+	if interval > 10*time.Minute {
+		interval = 10 * time.Minute
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := rotateSigningKeys(); err != nil {
+				log.Printf("Error rotating jwt signing keys: %v", err)
+			}
+		}
+	}()
+}
+
+// rotateSigningKeys makes sure there is an active key and that its successor is published ahead of time,
+// so verifiers caching the JWKS already know the next key when it starts signing.
+// Two instances rotating at once can both create a key, which is harmless: both verify and the newest signs.
+func rotateSigningKeys() error {
+	var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := loadSigningKeys(ctx); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	active, ok := keyring.active(now)
+	if !ok {
+		if err := createSigningKey(ctx, now); err != nil {
+			return err
+		}
+		return loadSigningKeys(ctx)
+	}
+
+	prepublish := keyRotationInterval / 2
+	if prepublish > 24*time.Hour {
+		prepublish = 24 * time.Hour
+	}
+
+	if active.retireAt.Sub(now) < prepublish && !keyring.hasSuccessor(active) {
+		if err := createSigningKey(ctx, active.retireAt); err != nil {
+			return err
+		}
+		return loadSigningKeys(ctx)
+	}
+
+	return nil
+}
+
+func loadSigningKeys(ctx context.Context) error {
+	opts := options.Find().SetSort(bson.D{{Key: "activated_at", Value: -1}})
+	cursor, err := signingKeyCollection.Find(ctx, bson.M{"expires_at": bson.M{"$gt": time.Now()}}, opts)
+	if err != nil {
+		return err
+	}
+
+	var records []models.SigningKey
+	if err = cursor.All(ctx, &records); err != nil {
+		return err
+	}
+
+	kek, err := keyEncryptionKey()
+	if err != nil {
+		return err
+	}
+
+	keys := make([]signingKey, 0, len(records))
+	for _, record := range records {
+		key, err := parseSigningKey(record, kek)
+		if err != nil {
+			log.Printf("Skipping jwt signing key %s: %v", record.Kid, err)
+			continue
+		}
+		keys = append(keys, key)
+
+		if !strings.HasPrefix(record.Private_key, encryptedKeyPrefix) {
+			sealPlaintextKey(ctx, kek, record)
+		}
+	}
+
+	keyring.mu.Lock()
+	keyring.keys = keys
+	keyring.loadedAt = time.Now()
+	keyring.mu.Unlock()
+
+	return nil
+}
+
+// sealPlaintextKey encrypts a key stored before keys were encrypted
+func sealPlaintextKey(ctx context.Context, kek []byte, record models.SigningKey) {
+	sealed, err := sealPrivateKey(kek, record.Kid, record.Private_key)
+	if err == nil {
+		_, err = signingKeyCollection.UpdateOne(ctx,
+			bson.M{"kid": record.Kid, "private_key": record.Private_key},
+			bson.M{"$set": bson.M{"private_key": sealed}})
+	}
+	if err != nil {
+		log.Printf("Error encrypting jwt signing key %s: %v", record.Kid, err)
+	}
+}
+
+func createSigningKey(ctx context.Context, activatedAt time.Time) error {
+	var private crypto.PrivateKey
+	var public crypto.PublicKey
+
+	switch SIGNING_ALG {
+	case "RS256":
+		rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return err
+		}
+		private, public = rsaKey, &rsaKey.PublicKey
+	case "EdDSA":
+		edPublic, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return err
+		}
+		private, public = edPrivate, edPublic
+	default:
+		return fmt.Errorf("cannot create a signing key for %s", SIGNING_ALG)
+	}
+
+	privateDER, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return err
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		return err
+	}
+
+	kek, err := keyEncryptionKey()
+	if err != nil {
+		return err
+	}
+
+	id := primitive.NewObjectID()
+	sealed, err := sealPrivateKey(kek, id.Hex(), string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER})))
+	if err != nil {
+		return err
+	}
+
+	retireAt := activatedAt.Add(keyRotationInterval)
+	record := models.SigningKey{
+		ID:           id,
+		Kid:          id.Hex(),
+		Alg:          SIGNING_ALG,
+		Private_key:  sealed,
+		Public_key:   string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})),
+		Activated_at: activatedAt,
+		Retire_at:    retireAt,
+		Expires_at:   retireAt.Add(RefreshTokenTTL),
+		Created_at:   time.Now(),
+	}
+
+	_, err = signingKeyCollection.InsertOne(ctx, record)
+	return err
+}
+
+func parseSigningKey(record models.SigningKey, kek []byte) (signingKey, error) {
+	key := signingKey{
+		kid:         record.Kid,
+		activatedAt: record.Activated_at,
+		retireAt:    record.Retire_at,
+		expiresAt:   record.Expires_at,
+	}
+
+	switch record.Alg {
+	case "RS256":
+		key.method = jwt.SigningMethodRS256
+	case "EdDSA":
+		key.method = EdDSASigningMethod
+	default:
+		return key, fmt.Errorf("unsupported alg %s", record.Alg)
+	}
+
+	privatePEM, err := openPrivateKey(kek, record.Kid, record.Private_key)
+	if err != nil {
+		return key, err
+	}
+
+	privateBlock, _ := pem.Decode([]byte(privatePEM))
+	publicBlock, _ := pem.Decode([]byte(record.Public_key))
+	if privateBlock == nil || publicBlock == nil {
+		return key, errors.New("invalid pem")
+	}
+
+	if key.private, err = x509.ParsePKCS8PrivateKey(privateBlock.Bytes); err != nil {
+		return key, err
+	}
+	if key.public, err = x509.ParsePKIXPublicKey(publicBlock.Bytes); err != nil {
+		return key, err
+	}
+
+	return key, nil
+}
+
+// active returns the newest key that is allowed to sign at the given time
+func (k *signingKeyring) active(at time.Time) (signingKey, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	for _, key := range k.keys {
+		if key.method.Alg() == SIGNING_ALG && !key.activatedAt.After(at) && key.retireAt.After(at) {
+			return key, true
+		}
+	}
+	return signingKey{}, false
+}
+
+func (k *signingKeyring) hasSuccessor(current signingKey) bool {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	for _, key := range k.keys {
+		if key.method.Alg() == SIGNING_ALG && key.activatedAt.After(current.activatedAt) {
+			return true
+		}
+	}
+	return false
+}
+
+func (k *signingKeyring) find(kid string) (signingKey, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	for _, key := range k.keys {
+		if key.kid == kid {
+			return key, true
+		}
+	}
+	return signingKey{}, false
+}
+
+func (k *signingKeyring) all() []signingKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return append([]signingKey(nil), k.keys...)
+}
+
+// signToken signs claims with the active asymmetric key, or with SECRET_KEY while SIGNING_ALG is HS256
+func signToken(claims jwt.Claims) (string, error) {
+	if SIGNING_ALG == "HS256" {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(SECRET_KEY))
+	}
+
+	key, ok := keyring.active(time.Now())
+	if !ok {
+		return "", errors.New("no active signing key")
+	}
+
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.kid
+
+	return token.SignedString(key.private)
+}
+
+// verificationKey is the jwt.Keyfunc used by ValidateToken
+func verificationKey(token *jwt.Token) (interface{}, error) {
+	switch token.Method.Alg() {
+	case "HS256":
+		if !acceptHS256() {
+			return nil, errors.New("HS256 tokens are no longer accepted")
+		}
+		return []byte(SECRET_KEY), nil
+	case "RS256", "EdDSA":
+		kid, _ := token.Header["kid"].(string)
+		key, ok := keyring.find(kid)
+		if !ok {
+			// the key may have just been created by another instance
+			keyring.mu.RLock()
+			stale := time.Since(keyring.loadedAt) > 10*time.Second
+			keyring.mu.RUnlock()
+			if stale {
+				var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				if err := loadSigningKeys(ctx); err != nil {
+					return nil, err
+				}
+				key, ok = keyring.find(kid)
+			}
+		}
+		if !ok || key.method.Alg() != token.Method.Alg() {
+			return nil, errors.New("unknown signing key")
+		}
+		return key.public, nil
+	default:
+		return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+	}
+}
+
+// JWKS returns the public keys that can verify tokens, in JSON Web Key Set format
+func JWKS() map[string]interface{} {
+	keys := []map[string]interface{}{}
+
+	for _, key := range keyring.all() {
+		if key.expiresAt.Before(time.Now()) {
+			continue
+		}
+
+		switch public := key.public.(type) {
+		case *rsa.PublicKey:
+			keys = append(keys, map[string]interface{}{
+				"kty": "RSA",
+				"use": "sig",
+				"alg": "RS256",
+				"kid": key.kid,
+				"n":   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			keys = append(keys, map[string]interface{}{
+				"kty": "OKP",
+				"crv": "Ed25519",
+				"use": "sig",
+				"alg": "EdDSA",
+				"kid": key.kid,
+				"x":   base64.RawURLEncoding.EncodeToString(public),
+			})
+		}
+	}
+
+	return map[string]interface{}{"keys": keys}
+}
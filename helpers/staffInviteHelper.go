@@ -0,0 +1,153 @@
+package helper
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gambl/database"
+	"gambl/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var staffInviteCollection *mongo.Collection = database.OpenCollection(database.Client, "staff_invites")
+
+// Staff invite statuses
+const (
+	StaffInvitePending  = "PENDING"
+	StaffInviteAccepted = "ACCEPTED"
+)
+
+var (
+	ErrStaffInAnotherSchool = errors.New("this user belongs to another school")
+	ErrStaffNotInvitable    = errors.New("admins cannot be invited as staff")
+	ErrStaffInviteNotFound  = errors.New("you have not been invited to a school, ask its admin for an invite")
+)
+
+// InviteStaff records the invite of a school's admin, replacing an earlier invite of the same email to the school.
+// An account that already exists and has completed its profile is attached to the school at once and the invite is
+// stored as accepted, new accounts take the invite when they onboard.
+func InviteStaff(ctx context.Context, invite models.StaffInvite) (models.StaffInvite, error) {
+	var user models.User
+	err := userCollection.FindOne(ctx, bson.M{"email": invite.Email}).Decode(&user)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return invite, err
+	}
+	found := err == nil
+
+	invite.Status = StaffInvitePending
+	if found {
+		if user.School_id != "" && user.School_id != invite.School_id {
+			return invite, ErrStaffInAnotherSchool
+		}
+		if user.User_type != nil && (*user.User_type == AdminUserType || *user.User_type == SuperAdminUserType) {
+			return invite, ErrStaffNotInvitable
+		}
+		if user.User_type == nil || *user.User_type != UnboardedUserType {
+			if err := AttachStaff(ctx, user, invite); err != nil {
+				return invite, err
+			}
+			invite.Status = StaffInviteAccepted
+			invite.User_id = user.User_id
+		}
+	}
+
+	now := time.Now()
+	id := primitive.NewObjectID()
+	err = staffInviteCollection.FindOneAndUpdate(ctx,
+		bson.M{"school_id": invite.School_id, "email": invite.Email},
+		bson.M{
+			"$set": bson.M{
+				"branch_id":  invite.Branch_id,
+				"user_type":  invite.User_type,
+				"role":       invite.Role,
+				"invited_by": invite.Invited_by,
+				"status":     invite.Status,
+				"user_id":    invite.User_id,
+				"updated_at": now,
+			},
+			"$setOnInsert": bson.M{"_id": id, "invite_id": id.Hex(), "created_at": now},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&invite)
+	return invite, err
+}
+
+// PendingStaffInvite returns the latest invite of an email that was not accepted yet
+func PendingStaffInvite(ctx context.Context, email string) (models.StaffInvite, error) {
+	var invite models.StaffInvite
+	err := staffInviteCollection.FindOne(ctx,
+		bson.M{"email": email, "status": StaffInvitePending},
+		options.FindOne().SetSort(bson.D{{Key: "updated_at", Value: -1}}),
+	).Decode(&invite)
+	if err == mongo.ErrNoDocuments {
+		return invite, ErrStaffInviteNotFound
+	}
+	return invite, err
+}
+
+// AcceptStaffInvite records that the user took the invite
+func AcceptStaffInvite(ctx context.Context, inviteId string, userId string) error {
+	_, err := staffInviteCollection.UpdateOne(ctx,
+		bson.M{"invite_id": inviteId, "status": StaffInvitePending},
+		bson.M{"$set": bson.M{"status": StaffInviteAccepted, "user_id": userId, "updated_at": time.Now()}},
+	)
+	return err
+}
+
+// AttachStaff moves an account into the school and branch of an invite with its user type and roles, generating a
+// staff id if the account has none. It fails if the account joined another school or became an admin meanwhile.
+func AttachStaff(ctx context.Context, user models.User, invite models.StaffInvite) error {
+	staffId := user.Staff_id
+	if staffId == "" {
+		var err error
+		staffId, err = GenerateStaffID(ctx, invite.School_id)
+		if err != nil {
+			return err
+		}
+	}
+
+	result, err := userCollection.UpdateOne(ctx,
+		bson.M{
+			"user_id":   user.User_id,
+			"school_id": bson.M{"$in": []interface{}{nil, "", invite.School_id}},
+			"user_type": bson.M{"$nin": []string{AdminUserType, SuperAdminUserType}},
+		},
+		bson.M{"$set": bson.M{
+			"school_id":  invite.School_id,
+			"branch_id":  invite.Branch_id,
+			"user_type":  invite.User_type,
+			"role":       invite.Role,
+			"staff_id":   staffId,
+			"updated_at": time.Now(),
+		}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrStaffInAnotherSchool
+	}
+	return nil
+}
+
+// BackfillStaff attaches the staff accounts created before schools existed to a branch. Only onboarded accounts
+// without a school created before createdBefore are moved, newer ones must be invited.
+func BackfillStaff(ctx context.Context, schoolId string, branchId string, createdBefore time.Time) (int64, error) {
+	result, err := userCollection.UpdateMany(ctx,
+		bson.M{
+			"school_id":  bson.M{"$in": []interface{}{nil, ""}},
+			"user_type":  bson.M{"$in": []string{AdminUserType, TeacherUserType, NonTeacherUserType}},
+			"created_at": bson.M{"$lt": createdBefore},
+		},
+		bson.M{"$set": bson.M{"school_id": schoolId, "branch_id": branchId, "updated_at": time.Now()}},
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
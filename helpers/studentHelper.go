@@ -0,0 +1,63 @@
+package helper
+
+import (
+	"strings"
+	"time"
+
+	"gambl/database"
+	"gambl/models"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var studentCollection *mongo.Collection = database.OpenCollection(database.Client, "students")
+
+// StudentUserType is the user_type carried by student tokens
+const StudentUserType = "STUDENT"
+
+// StudentTokenTTL is the lifetime of a student token, from STUDENT_TOKEN_TTL_HOURS. Students get no refresh token.
+func StudentTokenTTL() time.Duration {
+	return time.Duration(envInt("STUDENT_TOKEN_TTL_HOURS", 12)) * time.Hour
+}
+
+// CreateStudentIndexes makes admission numbers unique within a school
+func CreateStudentIndexes() {
+	database.CreateIndexes(studentCollection,
+		mongo.IndexModel{Keys: bson.D{{Key: "school_id", Value: 1}, {Key: "admission_num", Value: 1}}, Options: options.Index().SetUnique(true)},
+		mongo.IndexModel{Keys: bson.D{{Key: "student_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+	)
+}
+
+// GenerateStudentToken mints the token of a student. Its student token type is only accepted by StudentAuthentication,
+// so it cannot be used on the staff routes.
+func GenerateStudentToken(student models.Student) (string, error) {
+	claims := &SignedDetails{
+		Uid:        student.Student_id,
+		User_type:  StudentUserType,
+		School_id:  student.School_id,
+		Branch_id:  student.Branch_id,
+		Token_type: StudentTokenType,
+		StandardClaims: jwt.StandardClaims{
+			Id:        primitive.NewObjectID().Hex(),
+			IssuedAt:  time.Now().Local().Unix(),
+			ExpiresAt: time.Now().Local().Add(StudentTokenTTL()).Unix(),
+		},
+	}
+
+	return signToken(claims)
+}
+
+// NormalizeAdmissionNum is the form admission numbers are stored and looked up in, so "adm/001" and " ADM/001"
+// are the same student
+func NormalizeAdmissionNum(admissionNum string) string {
+	return strings.ToUpper(strings.TrimSpace(admissionNum))
+}
+
+// StudentLoginKey identifies a student account to the login throttling
+func StudentLoginKey(schoolId string, admissionNum string) string {
+	return "student:" + schoolId + ":" + NormalizeAdmissionNum(admissionNum)
+}
@@ -0,0 +1,23 @@
+package helper
+
+import "testing"
+
+func TestStudentLoginKeyMatchesStoredAdmissionNum(t *testing.T) {
+	tests := []struct {
+		entered string
+		stored  string
+	}{
+		{entered: "ADM/001", stored: "ADM/001"},
+		{entered: "adm/001", stored: "ADM/001"},
+		{entered: "  Adm/001\t", stored: "ADM/001"},
+	}
+
+	for _, tt := range tests {
+		if got := NormalizeAdmissionNum(tt.entered); got != tt.stored {
+			t.Errorf("NormalizeAdmissionNum(%q) = %q, want %q", tt.entered, got, tt.stored)
+		}
+		if StudentLoginKey("s1", tt.entered) != StudentLoginKey("s1", tt.stored) {
+			t.Errorf("StudentLoginKey(%q) differs from the key of %q", tt.entered, tt.stored)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package helper
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"gambl/database"
+	"gambl/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var schoolCollection *mongo.Collection = database.OpenCollection(database.Client, "schools")
+var branchCollection *mongo.Collection = database.OpenCollection(database.Client, "branches")
+
+const (
+	// AdminUserType administers a single school and holds every permission in its branches
+	AdminUserType = "ADMIN"
+	// SuperAdminUserType holds every permission in every school
+	SuperAdminUserType = "SUPER_ADMIN"
+	// TeacherUserType and NonTeacherUserType are staff of a school, holding the permissions of their roles
+	TeacherUserType    = "TEACHER"
+	NonTeacherUserType = "NON_TEACHER"
+	// UnboardedUserType is a new account that has not completed its profile yet
+	UnboardedUserType = "UNBOARDED"
+)
+
+var ErrBranchNotFound = errors.New("branch doesnt exist")
+
+// branchSchools caches which school a branch belongs to. Branches never move between schools so entries do not expire.
+var branchSchools sync.Map
+
+// CreateTenantIndexes indexes schools, branches, users and staff invites by tenant
+func CreateTenantIndexes() {
+	database.CreateIndexes(schoolCollection,
+		mongo.IndexModel{Keys: bson.D{{Key: "school_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+	)
+	database.CreateIndexes(branchCollection,
+		mongo.IndexModel{Keys: bson.D{{Key: "branch_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		mongo.IndexModel{Keys: bson.D{{Key: "school_id", Value: 1}}},
+	)
+	database.CreateIndexes(userCollection,
+		mongo.IndexModel{Keys: bson.D{{Key: "school_id", Value: 1}}},
+	)
+	database.CreateIndexes(staffInviteCollection,
+		mongo.IndexModel{Keys: bson.D{{Key: "school_id", Value: 1}, {Key: "email", Value: 1}}, Options: options.Index().SetUnique(true)},
+		mongo.IndexModel{Keys: bson.D{{Key: "email", Value: 1}, {Key: "status", Value: 1}}},
+	)
+}
+
+// BranchSchool returns the school a branch belongs to
+func BranchSchool(ctx context.Context, branchId string) (string, error) {
+	if schoolId, ok := branchSchools.Load(branchId); ok {
+		return schoolId.(string), nil
+	}
+
+	var branch models.Branch
+	err := branchCollection.FindOne(ctx, bson.M{"branch_id": branchId}).Decode(&branch)
+	if err == mongo.ErrNoDocuments {
+		return "", ErrBranchNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+
+	branchSchools.Store(branchId, branch.School_id)
+	return branch.School_id, nil
+}
+
+// IsSuperAdmin reports whether the caller can cross tenants
+func IsSuperAdmin(c *gin.Context) bool {
+	return c.GetString("user_type") == SuperAdminUserType
+}
+
+// CanManageSchool reports whether the caller administers the school
+func CanManageSchool(c *gin.Context, schoolId string) bool {
+	if IsSuperAdmin(c) {
+		return true
+	}
+	return c.GetString("user_type") == AdminUserType && schoolId != "" && c.GetString("school_id") == schoolId
+}
+
+// ScopeToTenant restricts a users filter to the caller's school. A super admin sees every school, or only the one
+// selected with the X-School-Id header. A user who does not belong to a school yet only sees themselves.
+func ScopeToTenant(c *gin.Context, filter bson.M) bson.M {
+	schoolId := c.GetString("school_id")
+
+	var scope bson.M
+	switch {
+	case IsSuperAdmin(c) && schoolId == "":
+		return filter
+	case schoolId == "":
+		scope = bson.M{"user_id": c.GetString("uid")}
+	default:
+		scope = bson.M{"school_id": schoolId}
+	}
+
+	if len(filter) == 0 {
+		return scope
+	}
+	return bson.M{"$and": []bson.M{filter, scope}}
+}
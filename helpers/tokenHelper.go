@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"gambl/database"
+	"gambl/models"
 
 	jwt "github.com/dgrijalva/jwt-go"
 	"go.mongodb.org/mongo-driver/bson"
@@ -17,43 +18,99 @@ import (
 
 // SignedDetails
 type SignedDetails struct {
+	Email      string
+	Uid        string
+	User_type  string
+	Roles      []string
+	School_id  string
+	Branch_id  string
+	Token_type string
+	jwt.StandardClaims
+}
+
+// TokenSubject is the user a token pair is issued to
+type TokenSubject struct {
 	Email     string
 	Uid       string
 	User_type string
-	jwt.StandardClaims
+	Roles     []string
+	School_id string
+	Branch_id string
 }
 
+const (
+	AccessTokenType     = "access"
+	RefreshTokenType    = "refresh"
+	MfaPendingTokenType = "mfa_pending"
+	StudentTokenType    = "student"
+)
+
+// AccessTokenTTL and RefreshTokenTTL are the lifetimes of the tokens minted by GenerateAllTokens. Access tokens
+// carry the user's type and roles, so they are kept short for role changes to take effect on the next refresh.
+const (
+	AccessTokenTTL  = 15 * time.Minute
+	RefreshTokenTTL = 168 * time.Hour
+)
+
 var userCollection *mongo.Collection = database.OpenCollection(database.Client, "user")
 
 var SECRET_KEY string = os.Getenv("SECRET_KEY")
 
+// SubjectFromUser builds the token subject of a stored user
+func SubjectFromUser(user models.User) TokenSubject {
+	subject := TokenSubject{
+		Uid:       user.User_id,
+		Roles:     user.Role,
+		School_id: user.School_id,
+		Branch_id: user.Branch_id,
+	}
+	if user.Email != nil {
+		subject.Email = *user.Email
+	}
+	if user.User_type != nil {
+		subject.User_type = *user.User_type
+	}
+	return subject
+}
+
 // GenerateAllTokens generates both the detailed token and refresh token
-func GenerateAllTokens(email string, userType string, uid string) (signedToken string, signedRefreshToken string, err error) {
+func GenerateAllTokens(subject TokenSubject) (signedToken string, signedRefreshToken string, err error) {
 	claims := &SignedDetails{
-		Email:     email,
-		Uid:       uid,
-		User_type: userType,
+		Email:      subject.Email,
+		Uid:        subject.Uid,
+		User_type:  subject.User_type,
+		Roles:      subject.Roles,
+		School_id:  subject.School_id,
+		Branch_id:  subject.Branch_id,
+		Token_type: AccessTokenType,
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Local().Add(time.Hour * time.Duration(100)).Unix(),
+			Id:        primitive.NewObjectID().Hex(),
+			IssuedAt:  time.Now().Local().Unix(),
+			ExpiresAt: time.Now().Local().Add(AccessTokenTTL).Unix(),
 		},
 	}
 
+	// the refresh token carries a unique id so that every token stored server-side has a distinct hash
 	refreshClaims := &SignedDetails{
+		Uid:        subject.Uid,
+		Token_type: RefreshTokenType,
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Local().Add(time.Hour * time.Duration(168)).Unix(),
+			Id:        primitive.NewObjectID().Hex(),
+			IssuedAt:  time.Now().Local().Unix(),
+			ExpiresAt: time.Now().Local().Add(RefreshTokenTTL).Unix(),
 		},
 	}
 
-	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(SECRET_KEY))
+	token, err := signToken(claims)
 
 	if err != nil {
-		log.Panic(err)
+		log.Println(err)
 		return
 	}
-	refreshToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, refreshClaims).SignedString([]byte(SECRET_KEY))
+	refreshToken, err := signToken(refreshClaims)
 
 	if err != nil {
-		log.Panic(err)
+		log.Println(err)
 		return
 	}
 
@@ -66,9 +123,7 @@ func ValidateToken(signedToken string) (claims *SignedDetails, msg string) {
 	token, err := jwt.ParseWithClaims(
 		signedToken,
 		&SignedDetails{},
-		func(token *jwt.Token) (interface{}, error) {
-			return []byte(SECRET_KEY), nil
-		},
+		verificationKey,
 	)
 
 	if err != nil {
@@ -89,6 +144,16 @@ func ValidateToken(signedToken string) (claims *SignedDetails, msg string) {
 		return
 	}
 
+	revoked, err := IsTokenRevoked(claims)
+	if err != nil {
+		msg = "could not verify token"
+		return
+	}
+	if revoked {
+		msg = "token has been revoked"
+		return
+	}
+
 	return claims, msg
 }
 
@@ -0,0 +1,92 @@
+package helper
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the shared secret of the RFC 4226 and RFC 6238 test vectors, "12345678901234567890" in base32
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestHOTPRFC4226Vectors(t *testing.T) {
+	want := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+
+	for counter, code := range want {
+		if got := hotp([]byte("12345678901234567890"), int64(counter)); got != code {
+			t.Errorf("hotp(counter %d) = %s, want %s", counter, got, code)
+		}
+	}
+}
+
+func TestValidateTOTPRFC6238Vectors(t *testing.T) {
+	// the SHA1 vectors of RFC 6238 are 8 digits long, a 6 digit code is their last 6 digits
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		step, ok := ValidateTOTP(rfcSecret, tt.code, time.Unix(tt.unix, 0))
+		if !ok {
+			t.Errorf("ValidateTOTP(%s at %d) was rejected", tt.code, tt.unix)
+			continue
+		}
+		if step != tt.unix/totpPeriod {
+			t.Errorf("ValidateTOTP(%s at %d) matched step %d, want %d", tt.code, tt.unix, step, tt.unix/totpPeriod)
+		}
+	}
+}
+
+func TestValidateTOTPDriftWindow(t *testing.T) {
+	// at 59s the current step is 1, codes of steps 0 to 2 are accepted
+	at := time.Unix(59, 0)
+
+	tests := []struct {
+		name     string
+		secret   string
+		code     string
+		wantStep int64
+		wantOK   bool
+	}{
+		{"current step", rfcSecret, "287082", 1, true},
+		{"one step behind", rfcSecret, "755224", 0, true},
+		{"one step ahead", rfcSecret, "359152", 2, true},
+		{"two steps ahead", rfcSecret, "969429", 0, false},
+		{"secret typed in lower case with spaces", " " + strings.ToLower(rfcSecret) + " ", "287082", 1, true},
+		{"wrong code", rfcSecret, "123456", 0, false},
+		{"too short", rfcSecret, "28708", 0, false},
+		{"8 digit code", rfcSecret, "94287082", 0, false},
+		{"invalid secret", "not base32!", "287082", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := ValidateTOTP(tt.secret, tt.code, at)
+			if ok != tt.wantOK || step != tt.wantStep {
+				t.Fatalf("ValidateTOTP = (%d, %v), want (%d, %v)", step, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestGenerateTOTPSecret(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil || len(key) != 20 {
+		t.Fatalf("secret %q does not decode to 20 bytes: %v", secret, err)
+	}
+
+	code := hotp(key, time.Now().Unix()/totpPeriod)
+	if _, ok := ValidateTOTP(secret, code, time.Now()); !ok {
+		t.Fatal("a code of a generated secret is rejected")
+	}
+}
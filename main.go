@@ -3,6 +3,16 @@ package main
 import (
 	"os"
 
+	"gambl/config"
+	"gambl/database"
+	helper "gambl/helpers"
+	aiRoutes "gambl/routes/ai"
+	emailRoutes "gambl/routes/email"
+	onboardingRoutes "gambl/routes/onboarding"
+	referralRoutes "gambl/routes/referral"
+	rolesRoutes "gambl/routes/roles"
+	studentRoutes "gambl/routes/student"
+	tenantRoutes "gambl/routes/tenant"
 	userRoutes "gambl/routes/user"
 
 	"github.com/DeanThompson/ginpprof"
@@ -18,6 +28,26 @@ func main() {
 		port = "8000"
 	}
 
+	database.Connect()
+
+	helper.CreateRefreshTokenIndexes()
+	helper.CreateRevocationIndexes()
+	helper.CreateSigningKeyIndexes()
+	helper.CreateOTPIndexes()
+	helper.CreateMfaIndexes()
+	helper.CreateLoginAttemptIndexes()
+	helper.CreateTenantIndexes()
+	helper.CreateOnboardingIndexes()
+	helper.CreateReferralIndexes()
+	helper.CreateStudentIndexes()
+	helper.CreateAIIndexes()
+	helper.CreateAIUsageIndexes()
+	helper.CreateDraftIndexes()
+	helper.CreateDocumentIndexes()
+	config.CreateEmailOutboxIndexes()
+	helper.StartSigningKeyRotation()
+	config.StartEmailWorkers()
+
 	router := gin.New()
 
 	router.Use(gin.Logger())
@@ -26,7 +56,8 @@ func main() {
 	router.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"http://localhost:3000/*", "http://localhost:3000", "http://localhost:3000/"},
 		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "OPTIONS", "DELETE"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Accept-Language", "Content-Length", "Accept-Language", "Accept-Encoding", "X-CSRF-Token", "accept", "origin", "Cache-Control", "authorizationrequired", "Authorizationrequired", "authorization", "Connection", "Access-Control-Allow-Origin", "Authorization"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Accept-Language", "Content-Length", "Accept-Language", "Accept-Encoding", "X-CSRF-Token", "accept", "origin", "Cache-Control", "authorizationrequired", "Authorizationrequired", "authorization", "Connection", "Access-Control-Allow-Origin", "Authorization", "X-Device-Id", "X-Branch-Id", "X-School-Id"},
+		ExposeHeaders:    []string{"X-Device-Id"},
 		AllowWildcard:    true,
 		AllowCredentials: true,
 	}))
@@ -34,8 +65,19 @@ func main() {
 	//Unprotected routes
 	userRoutes.AuthRoutes(router)
 
+	//protected by their own middleware
+	userRoutes.MfaRoutes(router)
+	studentRoutes.StudentAuthRoutes(router)
+
 	//protected
 	userRoutes.UserRoutes(router)
+	rolesRoutes.RolesRoutes(router)
+	tenantRoutes.TenantRoutes(router)
+	onboardingRoutes.OnboardingRoutes(router)
+	referralRoutes.ReferralRoutes(router)
+	studentRoutes.StudentRoutes(router)
+	emailRoutes.EmailRoutes(router)
+	aiRoutes.AIRoutes(router)
 
 	// API-2
 
@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	config "gambl/config"
+	helper "gambl/helpers"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequireAIQuota reserves OPENAI_MAX_TOKENS on the quotas of the caller and their home branch, and answers 429 with
+// the reset time when a quota has fewer tokens left, so an exhausted quota is refused before the request is read.
+// Handlers replace the reservation with the estimate of the model request they send, its prompt and context
+// included. Tokens the handler did not use are given back. It must run after Authentication and Tenant.
+func RequireAIQuota() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := helper.ReserveAIRequest(c, config.AIChatSettings().MaxTokens); err != nil {
+			WriteAIQuotaError(c, err)
+			c.Abort()
+			return
+		}
+
+		c.Next()
+		helper.ReleaseAIRequest(c)
+	}
+}
+
+// WriteAIQuotaError answers 429 with a Retry-After header and the quota when err is an AIQuotaExceededError,
+// and 500 otherwise
+func WriteAIQuotaError(c *gin.Context, err error) {
+	var exceeded *helper.AIQuotaExceededError
+	if !errors.As(err, &exceeded) {
+		log.Printf("Error reserving the AI quota of %s: %v", c.GetString("uid"), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not check the AI quota"})
+		return
+	}
+
+	retryAfter := int(time.Until(exceeded.Reset_at).Seconds()) + 1
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":    exceeded.Error(),
+		"scope":    exceeded.Scope,
+		"period":   exceeded.Period,
+		"limit":    exceeded.Limit,
+		"used":     exceeded.Used,
+		"reset_at": exceeded.Reset_at,
+	})
+}
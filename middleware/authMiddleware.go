@@ -11,6 +11,20 @@ import (
 
 // Authz validates token and authorizes users
 func Authentication() gin.HandlerFunc {
+	return authenticate(helper.AccessTokenType)
+}
+
+// MfaAuthentication accepts access tokens as well as the mfa pending token returned by login, for the MFA enrollment routes
+func MfaAuthentication() gin.HandlerFunc {
+	return authenticate(helper.AccessTokenType, helper.MfaPendingTokenType)
+}
+
+// StudentAuthentication only accepts student tokens, for the routes of logged in students
+func StudentAuthentication() gin.HandlerFunc {
+	return authenticate(helper.StudentTokenType)
+}
+
+func authenticate(allowedTokenTypes ...string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 
 		if c.Request.Method == "OPTIONS" {
@@ -34,9 +48,33 @@ func Authentication() gin.HandlerFunc {
 			return
 		}
 
+		// tokens minted before token types existed are access tokens
+		tokenType := claims.Token_type
+		if tokenType == "" {
+			tokenType = helper.AccessTokenType
+		}
+
+		allowed := false
+		for _, allowedType := range allowedTokenTypes {
+			if tokenType == allowedType {
+				allowed = true
+			}
+		}
+		if !allowed {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": tokenType + " tokens cannot be used to access this resource"})
+			c.Abort()
+			return
+		}
+
 		c.Set("email", claims.Email)
 		c.Set("uid", claims.Uid)
 		c.Set("user_type", claims.User_type)
+		c.Set("roles", claims.Roles)
+		c.Set("school_id", claims.School_id)
+		c.Set("branch_id", claims.Branch_id)
+		c.Set("jti", claims.Id)
+		c.Set("token_type", tokenType)
+		c.Set("token_expires_at", claims.ExpiresAt)
 
 		c.Next()
 
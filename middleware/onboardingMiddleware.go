@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	helper "gambl/helpers"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequireOnboarding blocks users held to the onboarding steps until they have completed them. Routes whose path
+// starts with one of the exempt prefixes stay reachable, so the steps themselves can be done.
+// It must run after Authentication.
+func RequireOnboarding(exemptPrefixes ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !helper.OnboardingRequired(c.GetString("user_type")) {
+			c.Next()
+			return
+		}
+
+		path := c.FullPath()
+		for _, prefix := range exemptPrefixes {
+			if strings.HasPrefix(path, prefix) {
+				c.Next()
+				return
+			}
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		done, next, err := helper.IsOnboarded(ctx, c.GetString("uid"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "could not check onboarding"})
+			c.Abort()
+			return
+		}
+		if !done {
+			c.JSON(http.StatusForbidden, gin.H{"error": "please complete onboarding first", "next_step": next})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
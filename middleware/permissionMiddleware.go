@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	helper "gambl/helpers"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PermissionChecker decides whether a set of roles grants a permission in a branch
+type PermissionChecker interface {
+	HasPermission(ctx context.Context, branchId string, roles []string, permission string) (bool, error)
+}
+
+// PermissionCheckerFunc adapts a function to the PermissionChecker interface
+type PermissionCheckerFunc func(ctx context.Context, branchId string, roles []string, permission string) (bool, error)
+
+func (f PermissionCheckerFunc) HasPermission(ctx context.Context, branchId string, roles []string, permission string) (bool, error) {
+	return f(ctx, branchId, roles, permission)
+}
+
+// Permissions checks roles against the roles collection. It can be replaced to test routes without mongo.
+var Permissions PermissionChecker = PermissionCheckerFunc(helper.RolesHavePermission)
+
+// RequirePermission only lets the request through when one of the caller's roles grants the permission
+// in the branch resolved by Tenant, which must run before it. Admins hold every permission in the branches
+// of their school and super admins in every branch.
+func RequirePermission(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// a super admin may act without a branch, across every school
+		if helper.IsSuperAdmin(c) {
+			c.Next()
+			return
+		}
+
+		branchId := c.GetString("branch_id")
+		if branchId == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "X-Branch-Id header is required"})
+			c.Abort()
+			return
+		}
+
+		if c.GetString("user_type") == helper.AdminUserType {
+			c.Next()
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		allowed, err := Permissions.HasPermission(ctx, branchId, c.GetStringSlice("roles"), permission)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "could not check permissions"})
+			c.Abort()
+			return
+		}
+		if !allowed {
+			c.JSON(http.StatusForbidden, gin.H{"error": "missing permission " + permission})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
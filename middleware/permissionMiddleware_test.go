@@ -0,0 +1,90 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	helper "gambl/helpers"
+
+	"github.com/gin-gonic/gin"
+)
+
+// stubPermissions grants permission only to the "teacher" role in branch "b1" and records whether it was asked
+type stubPermissions struct {
+	called bool
+	err    error
+}
+
+func (s *stubPermissions) HasPermission(ctx context.Context, branchId string, roles []string, permission string) (bool, error) {
+	s.called = true
+	if s.err != nil {
+		return false, s.err
+	}
+	for _, role := range roles {
+		if branchId == "b1" && role == "teacher" && permission == "students.read" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func usePermissions(t *testing.T, checker PermissionChecker) {
+	previous := Permissions
+	Permissions = checker
+	t.Cleanup(func() { Permissions = previous })
+}
+
+func TestRequirePermission(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name        string
+		userType    string
+		branchId    string
+		roles       []string
+		err         error
+		wantStatus  int
+		wantChecked bool
+	}{
+		{name: "role grants the permission", userType: "TEACHER", branchId: "b1", roles: []string{"teacher"}, wantStatus: http.StatusOK, wantChecked: true},
+		{name: "role does not grant the permission", userType: "TEACHER", branchId: "b1", roles: []string{"bursar"}, wantStatus: http.StatusForbidden, wantChecked: true},
+		{name: "role of another branch", userType: "TEACHER", branchId: "b2", roles: []string{"teacher"}, wantStatus: http.StatusForbidden, wantChecked: true},
+		{name: "no roles", userType: "TEACHER", branchId: "b1", wantStatus: http.StatusForbidden, wantChecked: true},
+		{name: "missing branch", userType: "TEACHER", roles: []string{"teacher"}, wantStatus: http.StatusBadRequest},
+		{name: "admin holds every permission", userType: helper.AdminUserType, branchId: "b1", wantStatus: http.StatusOK},
+		{name: "admin still needs a branch", userType: helper.AdminUserType, wantStatus: http.StatusBadRequest},
+		{name: "super admin", userType: helper.SuperAdminUserType, branchId: "b2", wantStatus: http.StatusOK},
+		{name: "super admin without a branch", userType: helper.SuperAdminUserType, wantStatus: http.StatusOK},
+		{name: "checker error", userType: "TEACHER", branchId: "b1", roles: []string{"teacher"}, err: errors.New("mongo is down"), wantStatus: http.StatusInternalServerError, wantChecked: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checker := &stubPermissions{err: tt.err}
+			usePermissions(t, checker)
+
+			router := gin.New()
+			router.GET("/students", func(c *gin.Context) {
+				c.Set("user_type", tt.userType)
+				c.Set("roles", tt.roles)
+				c.Set("branch_id", tt.branchId)
+				c.Next()
+			}, RequirePermission("students.read"), func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/students", nil))
+
+			if recorder.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d, body %s", recorder.Code, tt.wantStatus, recorder.Body.String())
+			}
+			if checker.called != tt.wantChecked {
+				t.Errorf("checker called = %v, want %v", checker.called, tt.wantChecked)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	helper "gambl/helpers"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Tenant resolves the school and branch a request acts on and sets school_id and branch_id for the handlers.
+// It must run after Authentication. Users act on their own school in their home branch, which stays in
+// home_branch_id. An admin may pick another branch of the school with the X-Branch-Id header, other users
+// are refused one that is not their home branch since their roles only hold there. A super admin picks the
+// school with X-School-Id, or the branch alone, and without either acts across every school.
+func Tenant() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		superAdmin := helper.IsSuperAdmin(c)
+
+		schoolId := c.GetString("school_id")
+		if superAdmin {
+			schoolId = c.Request.Header.Get("X-School-Id")
+		}
+
+		homeBranchId := c.GetString("branch_id")
+		branchId := c.Request.Header.Get("X-Branch-Id")
+		if branchId == "" && !superAdmin {
+			branchId = homeBranchId
+		}
+		if !superAdmin && c.GetString("user_type") != helper.AdminUserType && branchId != homeBranchId {
+			c.JSON(http.StatusForbidden, gin.H{"error": "you can only act in your home branch"})
+			c.Abort()
+			return
+		}
+
+		if branchId != "" {
+			var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			branchSchool, err := helper.BranchSchool(ctx, branchId)
+			if err == helper.ErrBranchNotFound {
+				c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+				c.Abort()
+				return
+			}
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "could not resolve the branch"})
+				c.Abort()
+				return
+			}
+
+			if superAdmin && schoolId == "" {
+				schoolId = branchSchool
+			}
+			if branchSchool != schoolId {
+				c.JSON(http.StatusForbidden, gin.H{"error": "branch does not belong to your school"})
+				c.Abort()
+				return
+			}
+		}
+
+		c.Set("school_id", schoolId)
+		c.Set("home_branch_id", homeBranchId)
+		c.Set("branch_id", branchId)
+
+		c.Next()
+	}
+}
@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	helper "gambl/helpers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestTenantBranchHeader(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name         string
+		userType     string
+		homeBranchId string
+		header       string
+		wantStatus   int
+		wantBranch   string
+	}{
+		{name: "another branch", userType: "TEACHER", homeBranchId: "b1", header: "b2", wantStatus: http.StatusForbidden},
+		{name: "a branch without a home branch", userType: "TEACHER", header: "b2", wantStatus: http.StatusForbidden},
+		{name: "no branch at all", userType: "TEACHER", wantStatus: http.StatusOK},
+		{name: "super admin across every school", userType: helper.SuperAdminUserType, homeBranchId: "b1", wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var branchId, homeBranchId string
+			router := gin.New()
+			router.GET("/", func(c *gin.Context) {
+				c.Set("user_type", tt.userType)
+				c.Set("school_id", "s1")
+				c.Set("branch_id", tt.homeBranchId)
+			}, Tenant(), func(c *gin.Context) {
+				branchId, homeBranchId = c.GetString("branch_id"), c.GetString("home_branch_id")
+				c.Status(http.StatusOK)
+			})
+
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				request.Header.Set("X-Branch-Id", tt.header)
+			}
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", recorder.Code, tt.wantStatus)
+			}
+			if recorder.Code == http.StatusOK && (branchId != tt.wantBranch || homeBranchId != tt.homeBranchId) {
+				t.Errorf("branch_id = %q and home_branch_id = %q, want %q and %q", branchId, homeBranchId, tt.wantBranch, tt.homeBranchId)
+			}
+		})
+	}
+}
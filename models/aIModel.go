@@ -1,6 +1,109 @@
 package models
 
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
 // Calendar Model
 type AIModel struct {
-	Prompt string `json:"prompt" validate:"required"`
+	Prompt string `json:"prompt" validate:"required,max=8000"`
+	// Max_tokens and Temperature override the configured defaults, Max_tokens cannot go above OPENAI_MAX_TOKENS
+	Max_tokens  *int     `json:"max_tokens" validate:"omitempty,min=1"`
+	Temperature *float64 `json:"temperature" validate:"omitempty,min=0,max=2"`
+}
+
+// AIUsage is the token count the provider billed for a completion
+type AIUsage struct {
+	Prompt_tokens     int `json:"prompt_tokens"`
+	Completion_tokens int `json:"completion_tokens"`
+	Total_tokens      int `json:"total_tokens"`
+}
+
+// AIChatResponse is the answer to a prompt
+type AIChatResponse struct {
+	ID            string    `json:"id"`
+	Model         string    `json:"model"`
+	Content       string    `json:"content"`
+	Finish_reason string    `json:"finish_reason"`
+	Usage         AIUsage   `json:"usage"`
+	Created_at    time.Time `json:"created_at"`
+}
+
+// AIMessage is one message of a chat with the model, Role is system, user or assistant
+type AIMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// AIChat records a prompt and the answer it got, with the conversation it continued if any. An answer interrupted
+// by the client is kept with the CANCELLED status.
+type AIChat struct {
+	ID              primitive.ObjectID `bson:"_id"`
+	Chat_id         string             `json:"chat_id"`
+	Conversation_id string             `json:"conversation_id,omitempty"`
+	User_id         string             `json:"user_id"`
+	School_id       string             `json:"school_id"`
+	Branch_id       string             `json:"branch_id"`
+	Model           string             `json:"model"`
+	Prompt          string             `json:"prompt"`
+	Content         string             `json:"content"`
+	Finish_reason   string             `json:"finish_reason"`
+	Status          string             `json:"status"`
+	Streamed        bool               `json:"streamed"`
+	Usage           AIUsage            `json:"usage"`
+	Created_at      time.Time          `json:"created_at"`
+}
+
+// AIConversation is a multi-turn chat of a user with the model. Messages before Summarized_count are only sent to
+// the model through Summary once the conversation outgrows the context window.
+type AIConversation struct {
+	ID               primitive.ObjectID      `bson:"_id"`
+	Conversation_id  string                  `json:"conversation_id"`
+	User_id          string                  `json:"user_id"`
+	School_id        string                  `json:"school_id"`
+	Branch_id        string                  `json:"branch_id"`
+	Title            string                  `json:"title"`
+	System_prompt    string                  `json:"system_prompt"`
+	Summary          string                  `json:"summary,omitempty"`
+	Summarized_count int                     `json:"summarized_count"`
+	Message_count    int                     `json:"message_count"`
+	Messages         []AIConversationMessage `json:"messages,omitempty"`
+	Created_at       time.Time               `json:"created_at"`
+	Updated_at       time.Time               `json:"updated_at"`
+}
+
+// AIConversationMessage is a message of a conversation, from the user or the assistant
+type AIConversationMessage struct {
+	Role       string    `json:"role"`
+	Content    string    `json:"content"`
+	Usage      *AIUsage  `json:"usage,omitempty"`
+	Created_at time.Time `json:"created_at"`
+}
+
+type CreateAIConversation struct {
+	Title         string `json:"title" validate:"max=120"`
+	System_prompt string `json:"system_prompt" validate:"max=4000"`
+}
+
+type EditAIConversation struct {
+	Title         *string `json:"title" validate:"omitempty,max=120"`
+	System_prompt *string `json:"system_prompt" validate:"omitempty,max=4000"`
+}
+
+// AIUsageCounter adds up the tokens used by a user or a branch over a day or a month
+type AIUsageCounter struct {
+	ID                primitive.ObjectID `bson:"_id"`
+	Scope             string             `json:"scope"`
+	Scope_id          string             `json:"scope_id"`
+	School_id         string             `json:"school_id"`
+	Branch_id         string             `json:"branch_id"`
+	Period            string             `json:"period"`
+	Period_start      time.Time          `json:"period_start"`
+	Prompt_tokens     int64              `json:"prompt_tokens"`
+	Completion_tokens int64              `json:"completion_tokens"`
+	Total_tokens      int64              `json:"total_tokens"`
+	Requests          int64              `json:"requests"`
+	Expires_at        time.Time          `json:"expires_at"`
 }
@@ -0,0 +1,62 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AIDocument is a curriculum or policy document uploaded to a branch for the AI assistant to answer from. Its text
+// is stored as AIDocumentChunk records.
+type AIDocument struct {
+	ID              primitive.ObjectID `bson:"_id"`
+	Document_id     string             `json:"document_id"`
+	School_id       string             `json:"school_id"`
+	Branch_id       string             `json:"branch_id"`
+	Title           string             `json:"title"`
+	Filename        string             `json:"filename"`
+	Content_type    string             `json:"content_type"`
+	Size            int64              `json:"size"`
+	Chunk_count     int                `json:"chunk_count"`
+	Embedding_model string             `json:"embedding_model"`
+	Usage           AIUsage            `json:"usage"`
+	Uploaded_by     string             `json:"uploaded_by"`
+	Created_at      time.Time          `json:"created_at"`
+}
+
+// AIDocumentChunk is a passage of a document with its embedding, the unit documents are searched and cited by
+type AIDocumentChunk struct {
+	ID              primitive.ObjectID `bson:"_id"`
+	Chunk_id        string             `json:"chunk_id"`
+	Document_id     string             `json:"document_id"`
+	School_id       string             `json:"school_id"`
+	Branch_id       string             `json:"branch_id"`
+	Title           string             `json:"title"`
+	Index           int                `json:"index"`
+	Content         string             `json:"content"`
+	Embedding       []float64          `json:"-"`
+	Embedding_model string             `json:"-"`
+}
+
+// AIAsk is a question answered from the documents of the caller's branch
+type AIAsk struct {
+	Question string `json:"question" validate:"required,max=2000"`
+	Top_k    *int   `json:"top_k" validate:"omitempty,min=1,max=20"`
+}
+
+// AICitation is a passage an answer was given from. Number is the [n] marker the answer cites it with.
+type AICitation struct {
+	Number      int     `json:"number"`
+	Document_id string  `json:"document_id"`
+	Title       string  `json:"title"`
+	Chunk_id    string  `json:"chunk_id"`
+	Index       int     `json:"index"`
+	Score       float64 `json:"score"`
+	Content     string  `json:"content"`
+}
+
+// AIAnswer is the answer to a question with the passages it cites
+type AIAnswer struct {
+	AIChatResponse
+	Citations []AICitation `json:"citations"`
+}
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Address is a mailbox with an optional display name
+type Address struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+// Message is an email ready to be sent by any Mailer, rendered by the server with a text and an html body
+type Message struct {
+	From    Address   `json:"from"`
+	To      []Address `json:"to"`
+	Subject string    `json:"subject"`
+	Text    string    `json:"text"`
+	HTML    string    `json:"html"`
+}
+
+// OutboxEmail is an email waiting in the outbox, or the record of one that was sent or gave up on
+type OutboxEmail struct {
+	ID              primitive.ObjectID `bson:"_id"`
+	Message_id      string             `json:"message_id"`
+	Idempotency_key string             `json:"idempotency_key"`
+	Message         Message            `json:"message"`
+	Status          string             `json:"status"`
+	Attempts        int                `json:"attempts"`
+	Max_attempts    int                `json:"max_attempts"`
+	Last_error      string             `json:"last_error,omitempty"`
+	Next_attempt_at time.Time          `json:"next_attempt_at"`
+	Locked_until    time.Time          `json:"locked_until"`
+	Sent_at         *time.Time         `json:"sent_at,omitempty"`
+	Purge_at        *time.Time         `json:"purge_at,omitempty"`
+	Created_at      time.Time          `json:"created_at"`
+	Updated_at      time.Time          `json:"updated_at"`
+}
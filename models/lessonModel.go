@@ -0,0 +1,80 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// LessonPlan is a lesson plan written by the AI assistant and edited by its teacher
+type LessonPlan struct {
+	Title      string           `json:"title" validate:"required"`
+	Objectives []string         `json:"objectives" validate:"min=1,dive,required"`
+	Materials  []string         `json:"materials" validate:"dive,required"`
+	Activities []LessonActivity `json:"activities" validate:"min=1,dive"`
+	Assessment string           `json:"assessment" validate:"required"`
+	Homework   string           `json:"homework"`
+}
+
+type LessonActivity struct {
+	Title            string `json:"title" validate:"required"`
+	Description      string `json:"description" validate:"required"`
+	Duration_minutes int    `json:"duration_minutes" validate:"min=1,max=240"`
+}
+
+// Quiz is a multiple-choice quiz, each question has four options and the index of the right one
+type Quiz struct {
+	Title     string         `json:"title" validate:"required"`
+	Questions []QuizQuestion `json:"questions" validate:"min=1,max=50,dive"`
+}
+
+type QuizQuestion struct {
+	Question     string   `json:"question" validate:"required"`
+	Options      []string `json:"options" validate:"len=4,dive,required"`
+	Answer_index int      `json:"answer_index" validate:"min=0,max=3"`
+	Explanation  string   `json:"explanation"`
+}
+
+type GenerateLessonPlan struct {
+	Subject          string `json:"subject" validate:"required,max=200"`
+	Class            string `json:"class" validate:"required,max=200"`
+	Topic            string `json:"topic" validate:"required,max=200"`
+	Duration_minutes int    `json:"duration_minutes" validate:"omitempty,min=10,max=240"`
+	Notes            string `json:"notes" validate:"max=2000"`
+}
+
+type GenerateQuiz struct {
+	Subject        string `json:"subject" validate:"required,max=200"`
+	Class          string `json:"class" validate:"required,max=200"`
+	Topic          string `json:"topic" validate:"required,max=200"`
+	Question_count int    `json:"question_count" validate:"omitempty,min=1,max=30"`
+	Difficulty     string `json:"difficulty" validate:"omitempty,eq=easy|eq=medium|eq=hard"`
+	Notes          string `json:"notes" validate:"max=2000"`
+}
+
+// AIDraft is generated teaching content owned by the teacher who asked for it. Lesson_plan or Quiz is set depending on Kind.
+type AIDraft struct {
+	ID          primitive.ObjectID `bson:"_id"`
+	Draft_id    string             `json:"draft_id"`
+	Kind        string             `json:"kind"`
+	Owner_id    string             `json:"owner_id"`
+	School_id   string             `json:"school_id"`
+	Branch_id   string             `json:"branch_id"`
+	Subject     string             `json:"subject"`
+	Class       string             `json:"class"`
+	Topic       string             `json:"topic"`
+	Title       string             `json:"title"`
+	Status      string             `json:"status"`
+	Lesson_plan *LessonPlan        `json:"lesson_plan,omitempty"`
+	Quiz        *Quiz              `json:"quiz,omitempty"`
+	Model       string             `json:"model"`
+	Usage       AIUsage            `json:"usage"`
+	Created_at  time.Time          `json:"created_at"`
+	Updated_at  time.Time          `json:"updated_at"`
+}
+
+// EditAIDraft replaces the content of a draft, the one matching its kind
+type EditAIDraft struct {
+	Lesson_plan *LessonPlan `json:"lesson_plan"`
+	Quiz        *Quiz       `json:"quiz"`
+}
@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// LoginAttempt counts consecutive failed logins for an account ("email:<email>") or a client ("ip:<address>").
+// An account's counter also holds the login attempt in progress on it.
+type LoginAttempt struct {
+	ID              primitive.ObjectID `bson:"_id"`
+	Key             string             `json:"key"`
+	Failures        int                `json:"failures"`
+	Last_failure_at time.Time          `json:"last_failure_at"`
+	Next_attempt_at time.Time          `json:"next_attempt_at"`
+	Locked_until    time.Time          `json:"locked_until"`
+	Expires_at      time.Time          `json:"expires_at"`
+	Reserved_by     string             `json:"reserved_by,omitempty"`
+	Reserved_until  time.Time          `json:"reserved_until"`
+}
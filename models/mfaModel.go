@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MfaFactor is a user's TOTP second factor. Recovery codes are stored hashed and removed once used.
+type MfaFactor struct {
+	ID             primitive.ObjectID `bson:"_id"`
+	User_id        string             `json:"user_id"`
+	Secret         string             `json:"-"`
+	Enabled        bool               `json:"enabled"`
+	Recovery_codes []string           `json:"-"`
+	Last_used_step int64              `json:"-"`
+	Created_at     time.Time          `json:"created_at"`
+	Updated_at     time.Time          `json:"updated_at"`
+}
+
+type MfaCode struct {
+	Code *string `json:"code" validate:"required"`
+}
+
+type MfaLogin struct {
+	Mfa_token     *string `json:"mfa_token" validate:"required"`
+	Code          *string `json:"code" validate:"required_without=Recovery_code"`
+	Recovery_code *string `json:"recovery_code" validate:"required_without=Code"`
+}
@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// OtpChallenge is the current one-time password issued to a user for a purpose (signup, login, reset).
+// Only a salted hash of the code is stored, and a new code replaces the previous one.
+type OtpChallenge struct {
+	ID           primitive.ObjectID `bson:"_id"`
+	Challenge_id string             `json:"challenge_id"`
+	Purpose      string             `json:"purpose" validate:"eq=SIGNUP|eq=LOGIN|eq=RESET"`
+	User_id      string             `json:"user_id"`
+	Code_hash    string             `json:"-"`
+	Salt         string             `json:"-"`
+	Attempts     int                `json:"attempts"`
+	Consumed     bool               `json:"consumed"`
+	Issued_at    time.Time          `json:"issued_at"`
+	Expires_at   time.Time          `json:"expires_at"`
+	Locked_until time.Time          `json:"locked_until"`
+	Purge_at     time.Time          `json:"purge_at"`
+}
@@ -29,4 +29,10 @@ type CreateRolesDTO struct {
 	Role_type	  *string			  `json:"role_type" validate:"required"`
 	Created_at    time.Time          `json:"created_at"`
 	Updated_at    time.Time          `json:"updated_at"`
-}
\ No newline at end of file
+}
+type UpdateRolesDTO struct {
+	Role_name   *string   `json:"name"`
+	Permissions *[]string `json:"permissions"`
+	Description *string   `json:"description"`
+	Role_type   *string   `json:"role_type"`
+}
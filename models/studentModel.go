@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Student is an account created by staff, students log in with their admission number and a PIN or password
+type Student struct {
+	ID            primitive.ObjectID `bson:"_id"`
+	Student_id    string             `json:"student_id"`
+	First_name    *string            `json:"first_name"`
+	Last_name     *string            `json:"last_name"`
+	Admission_num string             `json:"admission_num"`
+	Password      *string            `json:"password,omitempty"`
+	Class         string             `json:"class"`
+	School_id     string             `json:"school_id"`
+	Branch_id     string             `json:"branch_id"`
+	Active        bool               `json:"isActive"`
+	Created_by    string             `json:"created_by"`
+	Created_at    time.Time          `json:"created_at"`
+	Updated_at    time.Time          `json:"updated_at"`
+}
+
+type CreateStudent struct {
+	First_name    *string `json:"first_name" validate:"required"`
+	Last_name     *string `json:"last_name" validate:"required"`
+	Admission_num *string `json:"admission_num" validate:"required"`
+	Password      *string `json:"password" validate:"required,min=4"`
+	Class         *string `json:"class"`
+}
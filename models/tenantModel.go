@@ -0,0 +1,68 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// School is a tenant. Users, roles and branches all belong to exactly one school.
+type School struct {
+	ID         primitive.ObjectID `bson:"_id"`
+	School_id  string             `json:"school_id"`
+	Name       string             `json:"name"`
+	Owner_id   string             `json:"owner_id"`
+	Created_at time.Time          `json:"created_at"`
+	Updated_at time.Time          `json:"updated_at"`
+}
+
+// Branch is a campus of a school, roles are defined per branch
+type Branch struct {
+	ID         primitive.ObjectID `bson:"_id"`
+	Branch_id  string             `json:"branch_id"`
+	School_id  string             `json:"school_id"`
+	Name       string             `json:"name"`
+	Address    string             `json:"address"`
+	Created_at time.Time          `json:"created_at"`
+	Updated_at time.Time          `json:"updated_at"`
+}
+
+type CreateSchool struct {
+	Name        *string `json:"name" validate:"required"`
+	Branch_name *string `json:"branch_name" validate:"required"`
+	Address     *string `json:"address"`
+}
+
+type CreateBranch struct {
+	Name    *string `json:"name" validate:"required"`
+	Address *string `json:"address"`
+}
+
+// StaffInvite lets the admin of a school decide the user type, roles and branch of a staff member. An account that
+// signs up with the invited email takes them when it onboards, an existing account is attached right away.
+type StaffInvite struct {
+	ID         primitive.ObjectID `bson:"_id"`
+	Invite_id  string             `json:"invite_id"`
+	Email      string             `json:"email"`
+	School_id  string             `json:"school_id"`
+	Branch_id  string             `json:"branch_id"`
+	User_type  string             `json:"user_type"`
+	Role       []string           `json:"role"`
+	Invited_by string             `json:"invited_by"`
+	Status     string             `json:"status"`
+	User_id    string             `json:"user_id"`
+	Created_at time.Time          `json:"created_at"`
+	Updated_at time.Time          `json:"updated_at"`
+}
+
+type InviteStaff struct {
+	Email     *string   `json:"email" validate:"email,required"`
+	Branch_id *string   `json:"branch_id" validate:"required"`
+	User_type *string   `json:"user_type" validate:"required,eq=TEACHER|eq=NON_TEACHER"`
+	Role      *[]string `json:"role"`
+}
+
+type BackfillStaff struct {
+	Branch_id      *string    `json:"branch_id" validate:"required"`
+	Created_before *time.Time `json:"created_before" validate:"required"`
+}
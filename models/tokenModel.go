@@ -0,0 +1,55 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RefreshToken is a server-side record of an issued refresh token. Only the hash of the token is stored.
+type RefreshToken struct {
+	ID          primitive.ObjectID `bson:"_id"`
+	Token_id    string             `json:"token_id"`
+	User_id     string             `json:"user_id"`
+	Family_id   string             `json:"family_id"`
+	Device_id   string             `json:"device_id"`
+	Token_hash  string             `json:"-"`
+	Replaced_by string             `json:"replaced_by"`
+	Revoked     bool               `json:"revoked"`
+	Expires_at  time.Time          `json:"expires_at"`
+	Created_at  time.Time          `json:"created_at"`
+	Updated_at  time.Time          `json:"updated_at"`
+}
+
+type RefreshTokenRequest struct {
+	Refresh_token *string `json:"refresh_token" validate:"required"`
+}
+
+// RevokedToken marks either a single token (by jti) or every token a user was issued before Revoked_before as revoked
+type RevokedToken struct {
+	ID             primitive.ObjectID `bson:"_id"`
+	Kind           string             `json:"kind" validate:"eq=TOKEN|eq=USER"`
+	Jti            string             `json:"jti"`
+	User_id        string             `json:"user_id"`
+	Revoked_before time.Time          `json:"revoked_before"`
+	Expires_at     time.Time          `json:"expires_at"`
+	Created_at     time.Time          `json:"created_at"`
+}
+
+type LogoutRequest struct {
+	Refresh_token *string `json:"refresh_token"`
+}
+
+// SigningKey is an asymmetric key used to sign jwts. A key signs tokens between Activated_at and Retire_at and
+// is published for verification until Expires_at. Private_key is encrypted with JWT_KEY_ENCRYPTION_KEY.
+type SigningKey struct {
+	ID           primitive.ObjectID `bson:"_id"`
+	Kid          string             `json:"kid"`
+	Alg          string             `json:"alg" validate:"eq=RS256|eq=EdDSA"`
+	Private_key  string             `json:"-"`
+	Public_key   string             `json:"public_key"`
+	Activated_at time.Time          `json:"activated_at"`
+	Retire_at    time.Time          `json:"retire_at"`
+	Expires_at   time.Time          `json:"expires_at"`
+	Created_at   time.Time          `json:"created_at"`
+}
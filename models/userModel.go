@@ -18,16 +18,23 @@ type User struct {
 	Gender     string             `json:"gender" validate:"eq=M|eq=F"`
 	PostalCode string             `json:"postal_code"`
 	Country    string             `json:"country"`
+	Locale     string             `json:"locale"`
 	Department string             `json:"department"`
 	Staff_id   string             `json:"staff_id"`
 	Active     bool               `json:"isActive" default:"true"`
 	Status     string             `json:"status"`
 	// Token         *string            `json:"token"`
-	Role        []string `json:"role,omitempty"`
-	OTP         string   `json:"otp"`
-	OtpVerified bool     `json:"otpVerified" validate:"eq=true|eq=false"`
+	Role           []string `json:"role,omitempty"`
+	ClassesHandled []string `json:"classesHandled"`
+	OtpVerified    bool     `json:"otpVerified" validate:"eq=true|eq=false"`
+	// Is_Verified is nil on accounts created before email verification was enforced
+	Is_Verified          *bool     `json:"is_verified"`
+	Verification_sent_at time.Time `json:"verification_sent_at"`
+	Referral_code        string    `json:"referral_code"`
 	// User_type     *string            `json:"user_type" validate:"required,eq=ADMIN|eq=USER"`
-	User_type *string `json:"user_type" validate:"eq=ADMIN|eq=TEACHER|eq=NON_TEACHER|eq=UNBOARDED"`
+	User_type *string `json:"user_type" validate:"eq=SUPER_ADMIN|eq=ADMIN|eq=TEACHER|eq=NON_TEACHER|eq=UNBOARDED"`
+	School_id string  `json:"school_id"`
+	Branch_id string  `json:"branch_id"`
 	// Refresh_token *string            `json:"refresh_token"`
 	Created_at time.Time `json:"created_at"`
 	Updated_at time.Time `json:"updated_at"`
@@ -41,11 +48,22 @@ type ChangeUserPassword struct {
 }
 
 type ChangeStudentPassword struct {
-	Email            *string `json:"email" validate:"required"`
+	Admission_num    *string `json:"admission_num" validate:"required"`
 	New_password     *string `json:"new_password" validate:"required"`
 	Confirm_password *string `json:"confirm_password" validate:"required"`
 }
 
+type ForgotPassword struct {
+	Email *string `json:"email" validate:"email,required"`
+}
+
+type ResetPassword struct {
+	Email            *string `json:"email" validate:"email,required"`
+	Code             *string `json:"code" validate:"required"`
+	New_password     *string `json:"new_password" validate:"required,min=6"`
+	Confirm_password *string `json:"confirm_password" validate:"required"`
+}
+
 type EditUser struct {
 	ID         primitive.ObjectID `bson:"_id"`
 	First_name *string            `json:"first_name"`
@@ -54,6 +72,7 @@ type EditUser struct {
 	Phone      *string            `json:"phone"`
 	PostalCode *string            `json:"postal_code"`
 	Country    *string            `json:"country"`
+	Locale     *string            `json:"locale"`
 	Department *string            `json:"department"`
 	// Token         *string            `json:"token"`
 	Role *[]string `json:"role,omitempty"`
@@ -62,15 +81,14 @@ type EditUser struct {
 	Updated_at time.Time `json:"updated_at"`
 }
 
+// ValidateUser is the profile completed at onboarding, the user type and roles come from the staff invite and the
+// staff id is generated
 type ValidateUser struct {
 	First_name     *string   `json:"first_name" validate:"required"`
 	Last_name      *string   `json:"last_name" validate:"required"`
 	Phone          *string   `json:"phone" validate:"required"`
-	Role           *[]string `json:"role,omitempty" validate:"required"`
 	ClassesHandled *[]string `json:"classesHandled"`
-	Staff_id       string    `json:"staff_id"`
 	Address        *string   `json:"address" validate:"required"`
-	User_type      *string   `json:"user_type" validate:"eq=ADMIN|eq=TEACHER|eq=NON_TEACHER"`
 	Updated_at     time.Time `json:"updated_at"`
 }
 
@@ -83,7 +101,9 @@ type UserReferrer struct {
 	Referee_type     string             `json:"referee_type" validate:"eq=USER|eq=TEACHER|eq=NON_TEACHER|eq=STUDENT"`
 	Referrer_type    string             `json:"referrer_type" validate:"eq=USER|eq=TEACHER|eq=NON_TEACHER|eq=STUDENT"`
 	Status           string             `json:"status"`
+	School_id        string             `json:"school_id"`
 	Created_at       time.Time          `json:"created_at"`
+	Updated_at       time.Time          `json:"updated_at"`
 }
 
 type SignUpUser struct {
@@ -93,9 +113,14 @@ type SignUpUser struct {
 	Email      *string            `json:"email" validate:"email,required"`
 	User_id    string             `json:"user_id"`
 	Status     string             `json:"status"`
-	OTP        string             `json:"otp"`
+	Locale     string             `json:"locale"`
 	Created_at time.Time          `json:"created_at"`
 	Updated_at time.Time          `json:"updated_at"`
+	// Is_Verified is set once the email address is confirmed, by OTP or verification link
+	Is_Verified          bool      `json:"is_verified"`
+	Verification_sent_at time.Time `json:"verification_sent_at"`
+	// Referral_code is the code of the user who referred this one, it is recorded as a referral and not stored on the user
+	Referral_code *string `json:"referral_code" bson:"-"`
 }
 
 type NewUserAlert struct {
@@ -117,7 +142,9 @@ type SignUpPrecisionUser struct {
 }
 
 type PrecisionStudentLogin struct {
+	School_id     *string `json:"school_id" validate:"required"`
 	Admission_num *string `json:"admission_num" validate:"required"`
+	Password      *string `json:"password" validate:"required"`
 }
 
 type Otp struct {
@@ -128,6 +155,11 @@ type ResendOtp struct {
 	Email *string `json:"email" validate:"required"`
 }
 
+type LoginOtp struct {
+	Email *string `json:"email" validate:"email,required"`
+	OTP   *string `json:"otp" validate:"required"`
+}
+
 type OnboardedUserStatus struct {
 	ID                  primitive.ObjectID `bson:"_id"`
 	User_id             string             `json:"user_id"`
@@ -0,0 +1,34 @@
+package aiRoutes
+
+import (
+	aIcontroller "gambl/controllers/ai"
+	"gambl/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AIRoutes function
+func AIRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.POST("/ai/chat", middleware.RequireAIQuota(), aIcontroller.OpenAiEndpoint())
+	incomingRoutes.POST("/ai/chat/stream", middleware.RequireAIQuota(), aIcontroller.StreamAiEndpoint())
+	incomingRoutes.POST("/ai/conversations", aIcontroller.CreateConversation())
+	incomingRoutes.GET("/ai/conversations", aIcontroller.GetConversations())
+	incomingRoutes.GET("/ai/conversations/:conversation_id", aIcontroller.GetConversation())
+	incomingRoutes.PATCH("/ai/conversations/:conversation_id", aIcontroller.UpdateConversation())
+	incomingRoutes.DELETE("/ai/conversations/:conversation_id", aIcontroller.DeleteConversation())
+	incomingRoutes.POST("/ai/conversations/:conversation_id/messages", middleware.RequireAIQuota(), aIcontroller.ContinueConversation())
+	incomingRoutes.POST("/ai/conversations/:conversation_id/messages/stream", middleware.RequireAIQuota(), aIcontroller.StreamConversation())
+	incomingRoutes.POST("/ai/lesson-plans", middleware.RequireAIQuota(), aIcontroller.GenerateLessonPlan())
+	incomingRoutes.POST("/ai/quizzes", middleware.RequireAIQuota(), aIcontroller.GenerateQuiz())
+	incomingRoutes.GET("/ai/drafts", aIcontroller.GetDrafts())
+	incomingRoutes.GET("/ai/drafts/:draft_id", aIcontroller.GetDraft())
+	incomingRoutes.PATCH("/ai/drafts/:draft_id", aIcontroller.UpdateDraft())
+	incomingRoutes.DELETE("/ai/drafts/:draft_id", aIcontroller.DeleteDraft())
+	incomingRoutes.POST("/ai/documents", middleware.RequirePermission("ai_documents:write"), middleware.RequireAIQuota(), aIcontroller.UploadDocument())
+	incomingRoutes.GET("/ai/documents", aIcontroller.GetDocuments())
+	incomingRoutes.GET("/ai/documents/:document_id", aIcontroller.GetDocument())
+	incomingRoutes.DELETE("/ai/documents/:document_id", middleware.RequirePermission("ai_documents:write"), aIcontroller.DeleteDocument())
+	incomingRoutes.POST("/ai/ask", middleware.RequireAIQuota(), aIcontroller.AskDocuments())
+	incomingRoutes.GET("/ai/usage/me", aIcontroller.GetMyAIUsage())
+	incomingRoutes.GET("/ai/usage/report", middleware.RequirePermission("ai_usage:read"), aIcontroller.GetAIUsageReport())
+}
@@ -0,0 +1,17 @@
+package emailRoutes
+
+import (
+	controller "gambl/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+// EmailRoutes function
+func EmailRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/emails", controller.GetOutboxEmails())
+	incomingRoutes.POST("/emails/replay", controller.ReplayOutboxEmails())
+	incomingRoutes.GET("/emails/templates", controller.GetEmailTemplates())
+	incomingRoutes.GET("/emails/templates/:name", controller.PreviewEmailTemplate())
+	incomingRoutes.GET("/emails/:message_id", controller.GetOutboxEmail())
+	incomingRoutes.POST("/emails/:message_id/replay", controller.ReplayOutboxEmail())
+}
@@ -0,0 +1,13 @@
+package onboardingRoutes
+
+import (
+	controller "gambl/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+// OnboardingRoutes function
+func OnboardingRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/onboarding/status", controller.GetOnboardingStatus())
+	incomingRoutes.POST("/onboarding/steps/:step/complete", controller.CompleteOnboardingStep())
+}
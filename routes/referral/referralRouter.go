@@ -0,0 +1,15 @@
+package referralRoutes
+
+import (
+	controller "gambl/controllers"
+	"gambl/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ReferralRoutes function
+func ReferralRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/referrals/me", controller.GetMyReferrals())
+	incomingRoutes.GET("/referrals/report", middleware.RequirePermission("referrals:read"), controller.GetReferralReport())
+	incomingRoutes.POST("/referrals/:referral_id/reward", middleware.RequirePermission("referrals:write"), controller.RewardReferral())
+}
@@ -0,0 +1,18 @@
+package rolesRoutes
+
+import (
+	controller "gambl/controllers"
+	"gambl/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RolesRoutes function
+func RolesRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/roles/permissions", controller.GetPermissions())
+	incomingRoutes.GET("/roles", middleware.RequirePermission("roles:read"), controller.GetRoles())
+	incomingRoutes.POST("/roles", middleware.RequirePermission("roles:write"), controller.CreateRole())
+	incomingRoutes.GET("/roles/:role_id", middleware.RequirePermission("roles:read"), controller.GetRole())
+	incomingRoutes.PATCH("/roles/:role_id", middleware.RequirePermission("roles:write"), controller.UpdateRole())
+	incomingRoutes.DELETE("/roles/:role_id", middleware.RequirePermission("roles:write"), controller.DeleteRole())
+}
@@ -0,0 +1,25 @@
+package studentRoutes
+
+import (
+	controller "gambl/controllers"
+	"gambl/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// StudentAuthRoutes are used by students. They only accept student tokens, so they must be registered
+// before the routes that use the global Authentication middleware.
+func StudentAuthRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.POST("/students/login", controller.StudentLogin())
+
+	studentRoutes := incomingRoutes.Group("/students/me")
+	studentRoutes.Use(middleware.StudentAuthentication())
+	studentRoutes.GET("", controller.GetStudentProfile())
+	studentRoutes.POST("/logout", controller.StudentLogout())
+}
+
+// StudentRoutes let staff manage student accounts
+func StudentRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/students", middleware.RequirePermission("students:read"), controller.GetStudents())
+	incomingRoutes.POST("/students", middleware.RequirePermission("students:write"), controller.CreateStudent())
+}
@@ -0,0 +1,18 @@
+package tenantRoutes
+
+import (
+	controller "gambl/controllers"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TenantRoutes function
+func TenantRoutes(incomingRoutes *gin.Engine) {
+	incomingRoutes.GET("/schools", controller.GetSchools())
+	incomingRoutes.POST("/schools", controller.CreateSchool())
+	incomingRoutes.GET("/schools/:school_id", controller.GetSchool())
+	incomingRoutes.GET("/schools/:school_id/branches", controller.GetBranches())
+	incomingRoutes.POST("/schools/:school_id/branches", controller.CreateBranch())
+	incomingRoutes.POST("/schools/:school_id/staff", controller.InviteStaff())
+	incomingRoutes.POST("/schools/:school_id/staff/backfill", controller.BackfillStaff())
+}
@@ -11,6 +11,15 @@ func AuthRoutes(incomingRoutes *gin.Engine) {
 	// incomingRoutes.Use(middleware.CORSMiddleware())
 	incomingRoutes.POST("/users/signup", controller.SignUp())
 	incomingRoutes.POST("/users/login", controller.Login())
+	incomingRoutes.POST("/users/login/otp", controller.RequestLoginOTP())
+	incomingRoutes.POST("/users/login/otp/verify", controller.LoginWithOTP())
+	incomingRoutes.POST("/users/login/mfa", controller.LoginMfa())
 	incomingRoutes.POST("/users/resend-otp", controller.ResendOTP())
+	incomingRoutes.GET("/users/verify/:token", controller.VerifyEmail())
+	incomingRoutes.POST("/users/verify/resend", controller.ResendVerificationEmail())
+	incomingRoutes.POST("/users/token/refresh", controller.RefreshToken())
+	incomingRoutes.POST("/users/password/forgot", controller.ForgotPassword())
+	incomingRoutes.POST("/users/password/reset", controller.ResetPassword())
 	incomingRoutes.POST("/otp", controller.TestOTP())
+	incomingRoutes.GET("/.well-known/jwks.json", controller.JWKS())
 }
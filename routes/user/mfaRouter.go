@@ -0,0 +1,20 @@
+package userRoutes
+
+import (
+	controller "gambl/controllers"
+	"gambl/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MfaRoutes accept the mfa pending token returned by login as well as access tokens, so they
+// must be registered before the routes that use the global Authentication middleware
+func MfaRoutes(incomingRoutes *gin.Engine) {
+	mfaRoutes := incomingRoutes.Group("/users/mfa")
+	mfaRoutes.Use(middleware.MfaAuthentication())
+	mfaRoutes.POST("/enroll", controller.EnrollMfa())
+	mfaRoutes.GET("/enroll/qr", controller.MfaQRCode())
+	mfaRoutes.POST("/enable", controller.EnableMfa())
+	mfaRoutes.POST("/disable", controller.DisableMfa())
+	mfaRoutes.POST("/recovery-codes", controller.RegenerateRecoveryCodes())
+}
@@ -10,10 +10,17 @@ import (
 // UserRoutes function
 func UserRoutes(incomingRoutes *gin.Engine) {
 	// incomingRoutes.Use(middleware.CORSMiddleware())
-	incomingRoutes.Use(middleware.Authentication())
-	incomingRoutes.GET("/users", controller.GetUsers())
+	incomingRoutes.Use(middleware.Authentication(), middleware.Tenant())
+	// everything an admin needs to finish onboarding stays reachable before it is finished
+	incomingRoutes.Use(middleware.RequireOnboarding("/onboarding", "/schools", "/roles", "/users/onboard", "/users/validate-otp", "/users/logout", "/user/change-password"))
+	incomingRoutes.GET("/users", middleware.RequirePermission("users:read"), controller.GetUsers())
 	incomingRoutes.POST("/users/validate-otp", controller.ValidateOTP())
+	incomingRoutes.POST("/users/onboard", controller.OnboardUser())
 	incomingRoutes.GET("/users/:user_id", controller.GetUser())
 	incomingRoutes.POST("/users/:user_id/edit", controller.EditUser())
+	incomingRoutes.POST("/users/:user_id/unlock", middleware.RequirePermission("users:write"), controller.UnlockUser())
 	incomingRoutes.POST("/user/change-password", controller.ChangePassword())
+	incomingRoutes.POST("/users/password/student-reset", middleware.RequirePermission("students:write"), controller.ResetStudentPassword())
+	incomingRoutes.POST("/users/logout", controller.Logout())
+	incomingRoutes.POST("/users/logout-all", controller.LogoutAll())
 }